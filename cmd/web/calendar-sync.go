@@ -0,0 +1,14 @@
+package main
+
+import (
+	"context"
+
+	"github.com/amiranbari/bookings/internal/calsync"
+	"github.com/amiranbari/bookings/pkg/handlers"
+)
+
+// syncCalendars imports the calendars of other booking sites in the background, for as long as the app runs
+func syncCalendars() {
+	syncer := calsync.New(handlers.Repo.DB, app.CalendarImportDir, errorLog)
+	go syncer.Run(context.Background(), app.CalendarSyncInterval)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/amiranbari/bookings/internal/importer"
+	"github.com/amiranbari/bookings/pkg/handlers"
+)
+
+// errImportUsage is returned when the import command isn't given a kind and a file
+var errImportUsage = fmt.Errorf("usage: web import %s file.csv", strings.Join(importer.Kinds, "|"))
+
+// importCSV runs `web import <kind> <file>`, importing the rooms or reservations of a CSV file and writing
+// the rows that weren't imported to w
+func importCSV(w io.Writer, args []string) error {
+	if len(args) != 2 {
+		return errImportUsage
+	}
+
+	f, err := os.Open(args[1])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	result, err := importer.New(handlers.Repo.DB).Import(context.Background(), args[0], f)
+	if errors.Is(err, importer.ErrUnknownKind) {
+		return errImportUsage
+	} else if err != nil {
+		return err
+	}
+
+	for _, e := range result.Errors {
+		fmt.Fprintln(w, e)
+	}
+	fmt.Fprintln(w, result)
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportCSV(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rooms.csv")
+	err := os.WriteFile(file, []byte("title,max_occupancy,nightly_rate\nColonel's Room,2,100\nTi,2,100\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := importCSV(&out, []string{"rooms", file}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "line 3: title: this field must be at least 3 characters long\n1 imported, 1 not imported\n"
+	if out.String() != expected {
+		t.Errorf("expected %q but got %q", expected, out.String())
+	}
+
+	tests := []struct {
+		name          string
+		args          []string
+		expectedError string
+	}{
+		{"no-args", nil, errImportUsage.Error()},
+		{"no-file", []string{"rooms"}, errImportUsage.Error()},
+		{"unknown-kind", []string{"users", file}, errImportUsage.Error()},
+		{"missing-file", []string{"rooms", filepath.Join(t.TempDir(), "missing.csv")}, "no such file"},
+	}
+
+	for _, e := range tests {
+		err := importCSV(&out, e.args)
+		if err == nil || !strings.Contains(err.Error(), e.expectedError) {
+			t.Errorf("%s: expected error %q but got %v", e.name, e.expectedError, err)
+		}
+	}
+}
@@ -2,15 +2,18 @@ package main
 
 import (
 	// "errors"
+	"crypto/rand"
 	"encoding/gob"
 	"flag"
 	"fmt"
 	"github.com/amiranbari/bookings/internal/driver"
 	"github.com/amiranbari/bookings/internal/helpers"
+	"github.com/amiranbari/bookings/internal/pricing"
 	"github.com/joho/godotenv"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
@@ -70,10 +73,23 @@ func main() {
 	}
 	defer db.SQL.Close()
 
+	// web import rooms|reservations file.csv loads a CSV file instead of starting the server
+	if flag.Arg(0) == "import" {
+		if err := importCSV(os.Stdout, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	defer close(app.MailChan)
 	fmt.Println("Starting mail listening ...")
 	listenForMail()
 
+	if app.CalendarSyncInterval > 0 {
+		fmt.Println(fmt.Sprintf("Starting calendar sync every %s ...", app.CalendarSyncInterval))
+		syncCalendars()
+	}
+
 	fmt.Println(fmt.Sprintf("starting application on port number %s", portNumber))
 
 	srv := &http.Server{
@@ -94,6 +110,7 @@ func run() (*driver.DB, error) {
 	gob.Register(models.Restriction{})
 	gob.Register(models.RoomRestriction{})
 	gob.Register(map[string]int{})
+	gob.Register(pricing.Quote{})
 
 	err := godotenv.Load()
 	if err != nil {
@@ -102,6 +119,37 @@ func run() (*driver.DB, error) {
 
 	inProduction, _ := strconv.ParseBool(os.Getenv("PRODUCTION"))
 
+	// per-query database timeout, e.g. DB_TIMEOUT=5s
+	dbTimeout, err := time.ParseDuration(os.Getenv("DB_TIMEOUT"))
+	if err != nil {
+		dbTimeout = 3 * time.Second
+	}
+
+	secretKey := []byte(os.Getenv("APP_SECRET"))
+	if len(secretKey) == 0 {
+		// links signed with a random key stop working when the server restarts
+		secretKey = make([]byte, 32)
+		if _, err := rand.Read(secretKey); err != nil {
+			return nil, err
+		}
+		log.Println("APP_SECRET is not set, using a random key")
+	}
+
+	baseURL := os.Getenv("APP_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost" + portNumber
+	}
+
+	// users with at least this access level must set up two-factor authentication, e.g. TWO_FACTOR_LEVEL=2
+	// for owners only, 0 or unset leaves it optional for everybody
+	twoFactorLevel, _ := strconv.Atoi(os.Getenv("TWO_FACTOR_LEVEL"))
+
+	// how often calendars of other booking sites are imported, e.g. CALENDAR_SYNC_INTERVAL=15m, 0 turns it off
+	calendarSyncInterval, err := time.ParseDuration(os.Getenv("CALENDAR_SYNC_INTERVAL"))
+	if err != nil {
+		calendarSyncInterval = 15 * time.Minute
+	}
+
 	useCache := flag.Bool("cache", false, "User cache for templates or not!")
 	flag.Parse()
 
@@ -110,6 +158,12 @@ func run() (*driver.DB, error) {
 
 	// change this to true in production
 	app.InProduction = inProduction
+	app.DBTimeout = dbTimeout
+	app.SecretKey = secretKey
+	app.BaseURL = strings.TrimSuffix(baseURL, "/")
+	app.TwoFactorLevel = twoFactorLevel
+	app.CalendarImportDir = os.Getenv("CALENDAR_IMPORT_DIR")
+	app.CalendarSyncInterval = calendarSyncInterval
 
 	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	app.InfoLog = infoLog
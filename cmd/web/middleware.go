@@ -1,8 +1,16 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/amiranbari/bookings/internal/apikeys"
 	"github.com/amiranbari/bookings/internal/helpers"
+	"github.com/amiranbari/bookings/pkg/handlers"
+	"github.com/amiranbari/bookings/pkg/models"
 	"net/http"
+	"strings"
 
 	"github.com/justinas/nosurf"
 )
@@ -17,6 +25,11 @@ func NoSurf(next http.Handler) http.Handler {
 		SameSite: http.SameSiteLaxMode,
 	})
 
+	// API clients authenticate with a key instead of the session cookie, see APIAuth
+	csrfHandler.ExemptFunc(func(r *http.Request) bool {
+		return strings.HasPrefix(r.URL.Path, "/api/")
+	})
+
 	return csrfHandler
 }
 
@@ -36,3 +49,84 @@ func Auth(next http.Handler) http.Handler {
 		next.ServeHTTP(rw, r)
 	})
 }
+
+//RequireAccessLevel router only users whose access level is at least level, the level is read from the
+//users table on every request so role changes apply immediately
+func RequireAccessLevel(level int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			user, err := handlers.Repo.DB.GetUserByID(r.Context(), session.GetInt(r.Context(), "user_id"))
+			if err != nil {
+				session.Remove(r.Context(), "user_id")
+				session.Remove(r.Context(), "access_level")
+				session.Put(r.Context(), "error", "Log in first!")
+				http.Redirect(rw, r, "/login", http.StatusSeeOther)
+				return
+			}
+
+			session.Put(r.Context(), "access_level", user.AccessLevel)
+
+			// users who must use two-factor authentication can't do anything else until it is set up
+			if user.TwoFactorRequired(app.TwoFactorLevel) && !user.TOTPEnabled && r.URL.Path != "/admin/two-factor" {
+				session.Put(r.Context(), "warning", "Please set up two-factor authentication to continue.")
+				http.Redirect(rw, r, "/admin/two-factor", http.StatusSeeOther)
+				return
+			}
+
+			if user.AccessLevel < level {
+				session.Put(r.Context(), "error", "You don't have permission to do that!")
+				http.Redirect(rw, r, "/admin/dashboard", http.StatusSeeOther)
+				return
+			}
+
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// apiKeyContextKey is the request context key APIAuth stores the authenticated key under
+type apiKeyContextKey struct{}
+
+//APIAuth authenticates machine clients by the API key in their "Authorization: Bearer" header, these
+//requests don't use the session so NoSurf lets them through
+func APIAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		token, ok := apikeys.FromHeader(r.Header.Get("Authorization"))
+		if !ok {
+			handlers.Repo.WriteAPIError(rw, http.StatusUnauthorized, handlers.APIErrUnauthorized, "missing API key")
+			return
+		}
+
+		key, err := handlers.Repo.DB.GetAPIKeyByHash(r.Context(), apikeys.Hash(token))
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			handlers.Repo.WriteAPIServerError(rw, err)
+			return
+		}
+		if err != nil || key.Revoked {
+			handlers.Repo.WriteAPIError(rw, http.StatusUnauthorized, handlers.APIErrUnauthorized, "invalid API key")
+			return
+		}
+
+		if err := handlers.Repo.DB.UpdateAPIKeyLastUsed(r.Context(), key.ID); err != nil {
+			app.ErrorLog.Println("can't record api key use:", err)
+		}
+
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+	})
+}
+
+//RequireAPIScope router only API keys that were given scope, it must run after APIAuth
+func RequireAPIScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			key, _ := r.Context().Value(apiKeyContextKey{}).(models.APIKey)
+			if !key.HasScope(scope) {
+				handlers.Repo.WriteAPIError(rw, http.StatusForbidden, handlers.APIErrForbidden,
+					fmt.Sprintf("this API key needs the %s scope", scope))
+				return
+			}
+
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
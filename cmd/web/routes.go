@@ -5,6 +5,7 @@ import (
 
 	"github.com/amiranbari/bookings/pkg/config"
 	"github.com/amiranbari/bookings/pkg/handlers"
+	"github.com/amiranbari/bookings/pkg/models"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
@@ -34,24 +35,90 @@ func route(app *config.AppConfig) http.Handler {
 	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
 	mux.Get("/make-reservation", handlers.Repo.MakeReservation)
 	mux.Post("/make-reservation", handlers.Repo.PostReservation)
+	mux.Get("/rooms/{id}/calendar.ics", handlers.Repo.RoomCalendar)
+
+	//guest reservation
+	mux.Get("/my-reservation", handlers.Repo.MyReservation)
+	mux.Post("/my-reservation", handlers.Repo.PostMyReservation)
+	mux.Get("/my-reservation/view", handlers.Repo.MyReservationView)
+	mux.Post("/my-reservation/dates", handlers.Repo.PostMyReservationDates)
+	mux.Post("/my-reservation/cancel", handlers.Repo.PostMyReservationCancel)
 
 	//user
 	mux.Get("/login", handlers.Repo.Login)
 	mux.Post("/login", handlers.Repo.PostLogin)
+	mux.Get("/login/two-factor", handlers.Repo.LoginTwoFactor)
+	mux.Post("/login/two-factor", handlers.Repo.PostLoginTwoFactor)
 	mux.Get("/logout", handlers.Repo.Logout)
+	mux.Get("/forgot-password", handlers.Repo.ForgotPassword)
+	mux.Post("/forgot-password", handlers.Repo.PostForgotPassword)
+	mux.Get("/reset-password", handlers.Repo.ResetPassword)
+	mux.Post("/reset-password", handlers.Repo.PostResetPassword)
+
+	//api
+	mux.Get("/api/openapi.json", handlers.Repo.OpenAPI)
+	mux.Route("/api/v1", func(mux chi.Router) {
+		mux.Use(APIAuth)
+		mux.With(RequireAPIScope(models.APIScopeRoomsRead)).Get("/availability", handlers.Repo.APIAvailability)
+		mux.With(RequireAPIScope(models.APIScopeRoomsRead)).Get("/rooms", handlers.Repo.APIRooms)
+		mux.With(RequireAPIScope(models.APIScopeRoomsRead)).Get("/rooms/{id}", handlers.Repo.APIRoom)
+		mux.With(RequireAPIScope(models.APIScopeReservationsWrite)).Post("/reservations", handlers.Repo.APIPostReservation)
+		mux.With(RequireAPIScope(models.APIScopeReservationsRead)).Get("/reservations/{code}", handlers.Repo.APIReservation)
+		mux.With(RequireAPIScope(models.APIScopeReservationsWrite)).Post("/reservations/{code}/cancel", handlers.Repo.APIPostReservationCancel)
+	})
 
 	//admin dashboard
 	mux.Route("/admin", func(mux chi.Router) {
-		//mux.Use(Auth)
+		mux.Use(Auth)
+		mux.Use(RequireAccessLevel(models.AccessLevelStaff))
 		mux.Get("/dashboard", handlers.Repo.Dashboard)
 		mux.Get("/reservations", handlers.Repo.AdminReservations)
 		mux.Get("/new-reservations", handlers.Repo.AdminNewReservations)
+		mux.Get("/reservations/export", handlers.Repo.AdminReservationsExport)
 		mux.Get("/reservations/{id}", handlers.Repo.AdminShowReservations)
 		mux.Post("/reservations/{id}", handlers.Repo.AdminPostShowReservations)
-		mux.Get("/reservations/{id}/processed", handlers.Repo.AdminPutShowReservations)
-		mux.Get("/reservations/{id}/delete", handlers.Repo.AdminDeleteReservation)
+		mux.Post("/reservations/{id}/status", handlers.Repo.AdminPostReservationStatus)
+		mux.Post("/reservations/{id}/move", handlers.Repo.AdminPostMoveReservation)
 		mux.Get("/reservations-calender", handlers.Repo.AdminReservationsCalender)
 		mux.Post("/reservations-calender", handlers.Repo.AdminPostReservationsCalender)
+		mux.Post("/blocks/{id}/delete", handlers.Repo.AdminPostDeleteBlock)
+		mux.Get("/profile", handlers.Repo.Profile)
+		mux.Post("/profile", handlers.Repo.PostProfilePassword)
+		mux.Get("/two-factor", handlers.Repo.TwoFactor)
+		mux.Post("/two-factor", handlers.Repo.PostTwoFactor)
+		mux.Post("/two-factor/disable", handlers.Repo.PostTwoFactorDisable)
+
+		//owner only
+		mux.Group(func(mux chi.Router) {
+			mux.Use(RequireAccessLevel(models.AccessLevelOwner))
+			mux.Get("/rooms", handlers.Repo.AdminRooms)
+			mux.Get("/rooms/new", handlers.Repo.AdminNewRoom)
+			mux.Post("/rooms/new", handlers.Repo.AdminPostNewRoom)
+			mux.Get("/rooms/{id}", handlers.Repo.AdminShowRoom)
+			mux.Post("/rooms/{id}", handlers.Repo.AdminPostShowRoom)
+			mux.Post("/rooms/{id}/archive", handlers.Repo.AdminPostArchiveRoom)
+			mux.Post("/rooms/{id}/rates", handlers.Repo.AdminPostRoomRate)
+			mux.Post("/rooms/{id}/calendar-token", handlers.Repo.AdminPostRoomCalendarToken)
+			mux.Post("/rooms/{id}/calendar-sources", handlers.Repo.AdminPostCalendarSource)
+			mux.Post("/rooms/{id}/calendar-sources/{sourceID}/sync", handlers.Repo.AdminPostSyncCalendarSource)
+			mux.Post("/rooms/{id}/calendar-sources/{sourceID}/delete", handlers.Repo.AdminPostDeleteCalendarSource)
+			mux.Post("/rooms/{id}/rates/{rateID}/delete", handlers.Repo.AdminPostDeleteRoomRate)
+			mux.Get("/restrictions", handlers.Repo.AdminRestrictions)
+			mux.Get("/restrictions/new", handlers.Repo.AdminNewRestriction)
+			mux.Post("/restrictions/new", handlers.Repo.AdminPostNewRestriction)
+			mux.Get("/restrictions/{id}", handlers.Repo.AdminShowRestriction)
+			mux.Post("/restrictions/{id}", handlers.Repo.AdminPostShowRestriction)
+			mux.Post("/restrictions/{id}/delete", handlers.Repo.AdminPostDeleteRestriction)
+			mux.Get("/users", handlers.Repo.AdminUsers)
+			mux.Post("/users", handlers.Repo.AdminPostNewUser)
+			mux.Get("/logins", handlers.Repo.AdminLogins)
+			mux.Post("/logins/unlock", handlers.Repo.AdminPostUnlockLogin)
+			mux.Get("/api-keys", handlers.Repo.AdminAPIKeys)
+			mux.Post("/api-keys", handlers.Repo.AdminPostNewAPIKey)
+			mux.Post("/api-keys/{id}/revoke", handlers.Repo.AdminPostRevokeAPIKey)
+			mux.Get("/import", handlers.Repo.AdminImport)
+			mux.Post("/import", handlers.Repo.AdminPostImport)
+		})
 	})
 
 	fileServer := http.FileServer(http.Dir("../../static/"))
@@ -1,10 +1,18 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/amiranbari/bookings/internal/repository/dbrepo"
 	"github.com/amiranbari/bookings/pkg/config"
+	"github.com/amiranbari/bookings/pkg/handlers"
+	"github.com/amiranbari/bookings/pkg/models"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -20,3 +28,215 @@ func TestRoute(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not *chi.Mux:, but is %T", v))
 	}
 }
+
+var adminAccessTests = []struct {
+	name               string
+	userID             int
+	url                string
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"anonymous-dashboard", 0, "/admin/dashboard", http.StatusSeeOther, "/login"},
+	{"unknown-user-dashboard", 5, "/admin/dashboard", http.StatusSeeOther, "/login"},
+	{"staff-dashboard", 2, "/admin/dashboard", http.StatusOK, ""},
+	{"owner-dashboard", 1, "/admin/dashboard", http.StatusOK, ""},
+	{"staff-reservations", 2, "/admin/reservations", http.StatusOK, ""},
+	{"staff-rooms", 2, "/admin/rooms", http.StatusSeeOther, "/admin/dashboard"},
+	{"owner-rooms", 1, "/admin/rooms", http.StatusOK, ""},
+	{"staff-room", 2, "/admin/rooms/1", http.StatusSeeOther, "/admin/dashboard"},
+	{"owner-room", 1, "/admin/rooms/1", http.StatusOK, ""},
+	{"staff-api-keys", 2, "/admin/api-keys", http.StatusSeeOther, "/admin/dashboard"},
+	{"owner-api-keys", 1, "/admin/api-keys", http.StatusOK, ""},
+}
+
+func TestAdminAccessLevels(t *testing.T) {
+	mux := route(&app)
+
+	for _, e := range adminAccessTests {
+		req := httptest.NewRequest("GET", e.url, nil)
+		if e.userID > 0 {
+			req.AddCookie(loginAs(t, e.userID))
+		}
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("for %s, expected redirect to %q but got %q", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestTwoFactorRequired(t *testing.T) {
+	defer func(level int) { app.TwoFactorLevel = level }(app.TwoFactorLevel)
+	app.TwoFactorLevel = models.AccessLevelOwner
+
+	tests := []struct {
+		name             string
+		userID           int
+		url              string
+		expectedLocation string
+	}{
+		{"owner-not-enrolled", 1, "/admin/dashboard", "/admin/two-factor"},
+		{"owner-enrolment-page", 1, "/admin/two-factor", ""},
+		{"owner-not-enrolled-rooms", 1, "/admin/rooms", "/admin/two-factor"},
+		{"staff-optional", 2, "/admin/dashboard", ""},
+	}
+
+	mux := route(&app)
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", e.url, nil)
+		req.AddCookie(loginAs(t, e.userID))
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("for %s, expected redirect to %q but got %q", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestCSRFExemptions(t *testing.T) {
+	mux := route(&app)
+
+	tests := []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+	}{
+		// reaches the API key check instead
+		{"api", "/api/v1/reservations/ABC123/cancel", http.StatusUnauthorized},
+		{"html-form", "/my-reservation/cancel", http.StatusBadRequest},
+		{"admin-form", "/admin/rooms/1/archive", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("POST", e.url, strings.NewReader(`{"email": "jane@smith.com"}`))
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+var apiAuthTests = []struct {
+	name               string
+	method             string
+	url                string
+	authorization      string
+	expectedStatusCode int
+}{
+	{"missing-key", "GET", "/api/v1/rooms", "", http.StatusUnauthorized},
+	{"not-bearer", "GET", "/api/v1/rooms", "Basic " + dbrepo.TestAPIKey, http.StatusUnauthorized},
+	{"unknown-key", "GET", "/api/v1/rooms", "Bearer bk_unknown", http.StatusUnauthorized},
+	{"revoked-key", "GET", "/api/v1/rooms", "Bearer " + dbrepo.TestRevokedAPIKey, http.StatusUnauthorized},
+	{"database-error", "GET", "/api/v1/rooms", "Bearer bk_db-error", http.StatusInternalServerError},
+	{"in-scope", "GET", "/api/v1/rooms", "Bearer " + dbrepo.TestAPIKey, http.StatusOK},
+	{"out-of-scope-read", "GET", "/api/v1/reservations/ABC123?email=john@smith.com", "Bearer " + dbrepo.TestAPIKey, http.StatusForbidden},
+	{"out-of-scope-write", "POST", "/api/v1/reservations", "Bearer " + dbrepo.TestAPIKey, http.StatusForbidden},
+}
+
+func TestAPIAuth(t *testing.T) {
+	mux := route(&app)
+
+	for _, e := range apiAuthTests {
+		req := httptest.NewRequest(e.method, e.url, nil)
+		if e.authorization != "" {
+			req.Header.Set("Authorization", e.authorization)
+		}
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d: %s", e.name, e.expectedStatusCode, rr.Code, rr.Body.String())
+		}
+
+		if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("for %s, expected a json response but got %q", e.name, ct)
+		}
+	}
+}
+
+// loginAs returns a session cookie for a session logged in as the user with id
+func loginAs(t *testing.T, id int) *http.Cookie {
+	ctx, err := session.Load(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session.Put(ctx, "user_id", id)
+
+	token, _, err := session.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &http.Cookie{Name: session.Cookie.Name, Value: token}
+}
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	mux := route(&app).(chi.Routes)
+	spec := handlers.APISpec()
+
+	registered := make(map[string]bool)
+	err := chi.Walk(mux, func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if strings.HasPrefix(route, "/static/") {
+			return nil
+		}
+
+		// chi reports routes of sub routers with a trailing slash when they are registered as "/"
+		if route != "/" {
+			route = strings.TrimSuffix(route, "/")
+		}
+
+		registered[method+" "+route] = true
+		if !spec.Has(method, route) {
+			t.Errorf("%s %s is registered but missing from the OpenAPI document", method, route)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, ops := range spec.Paths {
+		for method := range ops {
+			if !registered[strings.ToUpper(method)+" "+path] {
+				t.Errorf("%s %s is in the OpenAPI document but not registered", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+func TestOpenAPIServed(t *testing.T) {
+	mux := route(&app)
+
+	req := httptest.NewRequest("GET", "/api/openapi.json", nil)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected %d but got %d", http.StatusOK, rr.Code)
+	}
+	if rr.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected JSON but got %s", rr.Header().Get("Content-Type"))
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["openapi"] == nil || doc["paths"] == nil {
+		t.Errorf("unexpected document %s", rr.Body.String())
+	}
+}
@@ -1,12 +1,43 @@
 package main
 
 import (
+	"encoding/gob"
+	"log"
 	"net/http"
 	"os"
 	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/amiranbari/bookings/internal/helpers"
+	"github.com/amiranbari/bookings/internal/pricing"
+	"github.com/amiranbari/bookings/pkg/handlers"
+	"github.com/amiranbari/bookings/pkg/models"
+	"github.com/amiranbari/bookings/pkg/renders"
 )
 
 func TestMain(m *testing.M) {
+	gob.Register(models.Reservation{})
+	gob.Register(models.User{})
+	gob.Register(map[string]int{})
+	gob.Register(pricing.Quote{})
+
+	app.InProduction = false
+	app.InfoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	app.ErrorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
+	session = scs.New()
+	session.Lifetime = 24 * time.Hour
+	session.Cookie.Persist = true
+	session.Cookie.SameSite = http.SameSiteLaxMode
+	session.Cookie.Secure = app.InProduction
+
+	app.Session = session
+
+	handlers.NewHandlers(handlers.NewTestRepo(&app))
+	renders.NewRenderer(&app)
+	helpers.NewHelpers(&app)
+
 	os.Exit(m.Run())
 }
 
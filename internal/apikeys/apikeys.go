@@ -0,0 +1,46 @@
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	// keyPrefix marks our keys so they are easy to spot, e.g. by secret scanners
+	keyPrefix = "bk_"
+	// displayLength is how much of a key is stored in the clear to tell keys apart
+	displayLength = len(keyPrefix) + 8
+)
+
+// New returns a random key together with its first few characters, which are kept to recognise it
+// later. The key itself is only shown once and stored as Hash(key).
+func New() (key, display string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	key = keyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, key[:displayLength], nil
+}
+
+// Hash returns the hash a key is stored and looked up by. Keys are long and random, so unlike
+// passwords they don't need a slow hash.
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// FromHeader returns the key from an Authorization header like "Bearer bk_..."
+func FromHeader(header string) (string, bool) {
+	const scheme = "bearer "
+	if len(header) <= len(scheme) || !strings.EqualFold(header[:len(scheme)], scheme) {
+		return "", false
+	}
+
+	key := strings.TrimSpace(header[len(scheme):])
+	return key, key != ""
+}
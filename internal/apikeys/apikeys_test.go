@@ -0,0 +1,44 @@
+package apikeys
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	key, display, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(key, "bk_") || !strings.HasPrefix(key, display) || len(display) != 11 {
+		t.Errorf("unexpected key %s shown as %s", key, display)
+	}
+
+	other, _, _ := New()
+	if Hash(key) == Hash(other) {
+		t.Error("two new keys have the same hash")
+	}
+}
+
+var headerTests = []struct {
+	header   string
+	expected string
+	ok       bool
+}{
+	{"Bearer bk_abc", "bk_abc", true},
+	{"bearer  bk_abc ", "bk_abc", true},
+	{"Bearer ", "", false},
+	{"Basic dXNlcjpwYXNz", "", false},
+	{"bk_abc", "", false},
+	{"", "", false},
+}
+
+func TestFromHeader(t *testing.T) {
+	for _, e := range headerTests {
+		key, ok := FromHeader(e.header)
+		if key != e.expected || ok != e.ok {
+			t.Errorf("for %q expected %q, %v but got %q, %v", e.header, e.expected, e.ok, key, ok)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package calsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/amiranbari/bookings/internal/ical"
+	"github.com/amiranbari/bookings/internal/repository"
+	"github.com/amiranbari/bookings/pkg/models"
+)
+
+const (
+	// maxCalendarSize is the largest calendar that is read, feeds of booking sites are much smaller
+	maxCalendarSize = 10 << 20
+	// fetchTimeout bounds how long a calendar URL may take to answer
+	fetchTimeout = 30 * time.Second
+	// dateLayout compares the days of blocks and events
+	dateLayout = "2006-01-02"
+)
+
+// ErrSourceNotAllowed is returned for sources that are neither http(s) URLs nor files in the import directory
+var ErrSourceNotAllowed = errors.New("calendar must be an http(s) URL or a file in the import directory")
+
+// Store is the part of the repository a Syncer needs
+type Store interface {
+	AllCalendarSources(ctx context.Context) ([]models.CalendarSource, error)
+	UpdateCalendarSourceSync(ctx context.Context, id int, syncedAt time.Time, syncErr string) error
+	GetBlocksForCalendarSource(ctx context.Context, sourceID int, from time.Time) ([]models.RoomRestriction, error)
+	InsertExternalBlock(ctx context.Context, r models.RoomRestriction) error
+	UpdateExternalBlock(ctx context.Context, id int, start, end time.Time) error
+	DeleteBlockByID(ctx context.Context, id int) error
+}
+
+// Result counts the blocks a sync changed
+type Result struct {
+	Added   int
+	Updated int
+	Removed int
+	// Failed lists the events that couldn't be blocked, mostly because they overlap a reservation
+	Failed []string
+}
+
+func (r Result) String() string {
+	s := fmt.Sprintf("%d added, %d updated, %d removed", r.Added, r.Updated, r.Removed)
+	if len(r.Failed) > 0 {
+		s += fmt.Sprintf(", %d could not be blocked", len(r.Failed))
+	}
+	return s
+}
+
+// Syncer turns the events of calendar sources into blocks of their rooms. Syncing again only changes
+// the blocks of events that were added, moved or removed since.
+type Syncer struct {
+	Store  Store
+	Client *http.Client
+	// ImportDir is the only directory local calendar files are read from, empty allows URLs only
+	ImportDir string
+	ErrorLog  *log.Logger
+	now       func() time.Time
+}
+
+// New returns a Syncer storing blocks in store
+func New(store Store, importDir string, errorLog *log.Logger) *Syncer {
+	return &Syncer{
+		Store:     store,
+		Client:    &http.Client{Timeout: fetchTimeout},
+		ImportDir: importDir,
+		ErrorLog:  errorLog,
+		now:       time.Now,
+	}
+}
+
+// CheckSource returns an error unless source is an http(s) URL or a file inside importDir
+func CheckSource(source, importDir string) error {
+	if u, err := url.Parse(source); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		if u.Host == "" {
+			return ErrSourceNotAllowed
+		}
+		return nil
+	}
+
+	if importDir == "" {
+		return ErrSourceNotAllowed
+	}
+
+	dir, err := filepath.Abs(importDir)
+	if err != nil {
+		return err
+	}
+
+	path, err := filepath.Abs(strings.TrimPrefix(source, "file://"))
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+		return ErrSourceNotAllowed
+	}
+
+	return nil
+}
+
+// Run syncs every calendar source right away and then every interval, until ctx is done
+func (s *Syncer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := s.SyncAll(ctx)
+		if err != nil {
+			s.ErrorLog.Println(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SyncAll syncs every calendar source, a source that fails doesn't stop the others
+func (s *Syncer) SyncAll(ctx context.Context) error {
+	sources, err := s.Store.AllCalendarSources(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, source := range sources {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		_, err := s.Sync(ctx, source)
+		if err != nil {
+			s.ErrorLog.Printf("calendar %d (%s) of room %d: %s", source.ID, source.Name, source.RoomID, err)
+		}
+	}
+
+	return nil
+}
+
+// Sync makes the blocks of source match its upcoming events. Blocks that already ended are kept even if
+// the event is gone, so the history of the room stays intact.
+func (s *Syncer) Sync(ctx context.Context, source models.CalendarSource) (Result, error) {
+	var result Result
+
+	now := s.now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	events, err := s.fetch(ctx, source.URL)
+	if err != nil {
+		if updateErr := s.Store.UpdateCalendarSourceSync(ctx, source.ID, now, err.Error()); updateErr != nil {
+			s.ErrorLog.Println(updateErr)
+		}
+		return result, err
+	}
+
+	wanted := upcomingEvents(events, today)
+
+	blocks, err := s.Store.GetBlocksForCalendarSource(ctx, source.ID, today)
+	if err != nil {
+		return result, err
+	}
+
+	for _, block := range blocks {
+		event, ok := wanted[block.ExternalUID]
+		if !ok {
+			err = s.Store.DeleteBlockByID(ctx, block.ID)
+			if err != nil {
+				return result, err
+			}
+			result.Removed++
+			continue
+		}
+		delete(wanted, block.ExternalUID)
+
+		last := lastDay(event)
+		if block.StartDate.Format(dateLayout) == event.Start.Format(dateLayout) && block.EndDate.Format(dateLayout) == last.Format(dateLayout) {
+			continue
+		}
+
+		err = s.Store.UpdateExternalBlock(ctx, block.ID, event.Start, last)
+		if errors.Is(err, repository.ErrRoomNotAvailable) {
+			result.Failed = append(result.Failed, s.failed(event, err))
+			continue
+		} else if err != nil {
+			return result, err
+		}
+		result.Updated++
+	}
+
+	uids := make([]string, 0, len(wanted))
+	for uid := range wanted {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+
+	for _, uid := range uids {
+		event := wanted[uid]
+		err = s.Store.InsertExternalBlock(ctx, models.RoomRestriction{
+			RoomId:           source.RoomID,
+			CalendarSourceID: source.ID,
+			ExternalUID:      uid,
+			StartDate:        event.Start,
+			EndDate:          lastDay(event),
+		})
+		if errors.Is(err, repository.ErrRoomNotAvailable) {
+			result.Failed = append(result.Failed, s.failed(event, err))
+			continue
+		} else if err != nil {
+			return result, err
+		}
+		result.Added++
+	}
+
+	syncErr := ""
+	if len(result.Failed) > 0 {
+		syncErr = fmt.Sprintf("could not block %s, they overlap reservations or blocks", strings.Join(result.Failed, ", "))
+	}
+
+	err = s.Store.UpdateCalendarSourceSync(ctx, source.ID, now, syncErr)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// failed describes an event that couldn't be blocked because the room is taken, the error only goes to the log
+func (s *Syncer) failed(event ical.Event, err error) string {
+	s.ErrorLog.Printf("calendar event %s: %s", event.UID, err)
+	return fmt.Sprintf("%s to %s", event.Start.Format(dateLayout), lastDay(event).Format(dateLayout))
+}
+
+// upcomingEvents returns the events that still block a day from today on, keyed by their UID
+func upcomingEvents(events []ical.Event, today time.Time) map[string]ical.Event {
+	wanted := make(map[string]ical.Event)
+
+	for _, event := range events {
+		if event.Status == "CANCELLED" || lastDay(event).Before(today) {
+			continue
+		}
+
+		uid := event.UID
+		if uid == "" {
+			uid = event.Start.Format(dateLayout) + "/" + event.End.Format(dateLayout)
+		}
+		if _, ok := wanted[uid]; ok {
+			// changed occurrences of recurring events share the UID of the event
+			uid += "/" + event.Start.Format(dateLayout)
+		}
+
+		wanted[uid] = event
+	}
+
+	return wanted
+}
+
+// lastDay returns the last day an event blocks, restrictions include their last day and events don't
+func lastDay(event ical.Event) time.Time {
+	return event.End.AddDate(0, 0, -1)
+}
+
+// fetch reads the events of the calendar at source
+func (s *Syncer) fetch(ctx context.Context, source string) ([]ical.Event, error) {
+	err := CheckSource(source, s.ImportDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.ReadCloser
+
+	u, _ := url.Parse(source)
+	if u != nil && (u.Scheme == "http" || u.Scheme == "https") {
+		req, err := http.NewRequestWithContext(ctx, "GET", source, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "text/calendar")
+
+		resp, err := s.Client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("calendar URL answered %s", resp.Status)
+		}
+		body = resp.Body
+	} else {
+		body, err = os.Open(strings.TrimPrefix(source, "file://"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer body.Close()
+
+	return ical.Parse(io.LimitReader(body, maxCalendarSize))
+}
@@ -0,0 +1,289 @@
+package calsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amiranbari/bookings/internal/repository"
+	"github.com/amiranbari/bookings/pkg/models"
+)
+
+// memoryStore keeps blocks in memory and refuses blocks overlapping the reservation on 2050-02-01, the
+// event "broken" can't be stored at all
+type memoryStore struct {
+	blocks   map[int]models.RoomRestriction
+	nextID   int
+	lastSync string
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{blocks: make(map[int]models.RoomRestriction), nextID: 1}
+}
+
+var reserved = time.Date(2050, 2, 1, 0, 0, 0, 0, time.UTC)
+
+func overlapsReservation(start, end time.Time) bool {
+	return !reserved.Before(start) && !reserved.After(end)
+}
+
+func (m *memoryStore) AllCalendarSources(ctx context.Context) ([]models.CalendarSource, error) {
+	return nil, nil
+}
+
+func (m *memoryStore) UpdateCalendarSourceSync(ctx context.Context, id int, syncedAt time.Time, syncErr string) error {
+	m.lastSync = syncErr
+	return nil
+}
+
+func (m *memoryStore) GetBlocksForCalendarSource(ctx context.Context, sourceID int, from time.Time) ([]models.RoomRestriction, error) {
+	var blocks []models.RoomRestriction
+	for _, b := range m.blocks {
+		if b.CalendarSourceID == sourceID && !b.EndDate.Before(from) {
+			blocks = append(blocks, b)
+		}
+	}
+	return blocks, nil
+}
+
+func (m *memoryStore) InsertExternalBlock(ctx context.Context, r models.RoomRestriction) error {
+	if r.ExternalUID == "broken" {
+		return errors.New("some error!")
+	}
+	if overlapsReservation(r.StartDate, r.EndDate) {
+		return repository.ErrRoomNotAvailable
+	}
+	r.ID = m.nextID
+	m.nextID++
+	m.blocks[r.ID] = r
+	return nil
+}
+
+func (m *memoryStore) UpdateExternalBlock(ctx context.Context, id int, start, end time.Time) error {
+	if overlapsReservation(start, end) {
+		return repository.ErrRoomNotAvailable
+	}
+	b := m.blocks[id]
+	b.StartDate, b.EndDate = start, end
+	m.blocks[id] = b
+	return nil
+}
+
+func (m *memoryStore) DeleteBlockByID(ctx context.Context, id int) error {
+	delete(m.blocks, id)
+	return nil
+}
+
+// byUID returns the blocks as "start/end" keyed by UID
+func (m *memoryStore) byUID() map[string]string {
+	out := make(map[string]string)
+	for _, b := range m.blocks {
+		out[b.ExternalUID] = b.StartDate.Format(dateLayout) + "/" + b.EndDate.Format(dateLayout)
+	}
+	return out
+}
+
+func calendar(events ...string) string {
+	return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" + strings.Join(events, "") + "END:VCALENDAR\r\n"
+}
+
+func event(uid, start, end string) string {
+	return fmt.Sprintf("BEGIN:VEVENT\r\nUID:%s\r\nDTSTART;VALUE=DATE:%s\r\nDTEND;VALUE=DATE:%s\r\nEND:VEVENT\r\n", uid, start, end)
+}
+
+// feed is a stand-in for another booking site, serving whatever calendar it was given last
+type feed struct {
+	mu       sync.Mutex
+	calendar string
+}
+
+func (f *feed) set(calendar string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calendar = calendar
+}
+
+func (f *feed) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.calendar == "" {
+		http.NotFound(rw, r)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/calendar")
+	fmt.Fprint(rw, f.calendar)
+}
+
+func newTestSyncer(store Store, importDir string) *Syncer {
+	s := New(store, importDir, log.New(io.Discard, "", 0))
+	s.now = func() time.Time {
+		return time.Date(2050, 1, 10, 12, 0, 0, 0, time.UTC)
+	}
+	return s
+}
+
+func TestSync(t *testing.T) {
+	f := &feed{}
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+
+	store := newMemoryStore()
+	syncer := newTestSyncer(store, "")
+	source := models.CalendarSource{ID: 1, RoomID: 1, URL: ts.URL + "/room-1.ics"}
+
+	steps := []struct {
+		name     string
+		calendar string
+		expected map[string]string
+		result   Result
+	}{
+		{
+			"first-sync",
+			calendar(
+				event("past", "20500101", "20500103"),
+				event("a", "20500115", "20500118"),
+				event("b", "20500120", "20500121"),
+				event("overlaps-reservation", "20500130", "20500203"),
+			),
+			map[string]string{"a": "2050-01-15/2050-01-17", "b": "2050-01-20/2050-01-20"},
+			Result{Added: 2, Failed: []string{"2050-01-30 to 2050-02-02"}},
+		},
+		{
+			"unchanged",
+			calendar(
+				event("a", "20500115", "20500118"),
+				event("b", "20500120", "20500121"),
+			),
+			map[string]string{"a": "2050-01-15/2050-01-17", "b": "2050-01-20/2050-01-20"},
+			Result{},
+		},
+		{
+			"moved-and-removed",
+			calendar(
+				event("a", "20500116", "20500119"),
+				event("c", "20500301", "20500305"),
+			),
+			map[string]string{"a": "2050-01-16/2050-01-18", "c": "2050-03-01/2050-03-04"},
+			Result{Added: 1, Updated: 1, Removed: 1},
+		},
+		{
+			"moved-onto-reservation",
+			calendar(
+				event("a", "20500201", "20500202"),
+				event("c", "20500301", "20500305"),
+			),
+			map[string]string{"a": "2050-01-16/2050-01-18", "c": "2050-03-01/2050-03-04"},
+			Result{Failed: []string{"2050-02-01 to 2050-02-01"}},
+		},
+	}
+
+	for _, e := range steps {
+		f.set(e.calendar)
+
+		result, err := syncer.Sync(context.Background(), source)
+		if err != nil {
+			t.Fatalf("for %s, unexpected error %s", e.name, err)
+		}
+
+		if result.String() != e.result.String() || strings.Join(result.Failed, ",") != strings.Join(e.result.Failed, ",") {
+			t.Errorf("for %s, expected %s %v but got %s %v", e.name, e.result, e.result.Failed, result, result.Failed)
+		}
+
+		blocks := store.byUID()
+		if fmt.Sprint(blocks) != fmt.Sprint(e.expected) {
+			t.Errorf("for %s, expected blocks %v but got %v", e.name, e.expected, blocks)
+		}
+
+		if (len(e.result.Failed) > 0) != (store.lastSync != "") {
+			t.Errorf("for %s, unexpected sync error %q", e.name, store.lastSync)
+		}
+	}
+
+	// a feed that can't be read leaves the blocks alone
+	f.set("")
+	_, err := syncer.Sync(context.Background(), source)
+	if err == nil || store.lastSync == "" {
+		t.Error("expected the failed fetch to be recorded")
+	}
+	if len(store.blocks) != 2 {
+		t.Errorf("expected the blocks to be kept but got %v", store.byUID())
+	}
+}
+
+func TestSyncStoreError(t *testing.T) {
+	f := &feed{}
+	f.set(calendar(event("a", "20500115", "20500118"), event("broken", "20500120", "20500121")))
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+
+	store := newMemoryStore()
+	source := models.CalendarSource{ID: 1, RoomID: 1, URL: ts.URL + "/room-1.ics"}
+
+	// only overlaps are reported as failed events, other errors stop the sync
+	result, err := newTestSyncer(store, "").Sync(context.Background(), source)
+	if err == nil || len(result.Failed) != 0 {
+		t.Errorf("expected the store error to stop the sync but got %s %v, %v", result, result.Failed, err)
+	}
+}
+
+func TestSyncLocalFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "room-1.ics")
+	err := os.WriteFile(path, []byte(calendar(event("a", "20500115", "20500118"))), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := newMemoryStore()
+	source := models.CalendarSource{ID: 1, RoomID: 1, URL: path}
+
+	result, err := newTestSyncer(store, dir).Sync(context.Background(), source)
+	if err != nil || result.Added != 1 {
+		t.Errorf("expected the file to be imported, got %s %v", result, err)
+	}
+
+	_, err = newTestSyncer(store, "").Sync(context.Background(), source)
+	if !errors.Is(err, ErrSourceNotAllowed) {
+		t.Errorf("expected files to be refused without an import directory, got %v", err)
+	}
+}
+
+func TestCheckSource(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		source  string
+		allowed bool
+	}{
+		{"https://other.example.com/feed.ics?s=1", true},
+		{"http://127.0.0.1:8080/feed.ics", true},
+		{"https:///feed.ics", false},
+		{"ftp://other.example.com/feed.ics", false},
+		{filepath.Join(dir, "feed.ics"), true},
+		{"file://" + filepath.Join(dir, "feed.ics"), true},
+		{filepath.Join(dir, "..", "feed.ics"), false},
+		{"/etc/passwd", false},
+		{dir, false},
+	}
+
+	for _, e := range tests {
+		err := CheckSource(e.source, dir)
+		if (err == nil) != e.allowed {
+			t.Errorf("for %s, expected allowed %t but got %v", e.source, e.allowed, err)
+		}
+	}
+
+	if CheckSource(filepath.Join(dir, "feed.ics"), "") == nil {
+		t.Error("expected files to be refused without an import directory")
+	}
+}
@@ -3,7 +3,9 @@ package forms
 import (
 	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/asaskevich/govalidator"
 )
@@ -51,6 +53,15 @@ func (f *Form) MinLength(field string, length int) bool {
 	return true
 }
 
+func (f *Form) MaxLength(field string, length int) bool {
+	x := f.Get(field)
+	if len(x) > length {
+		f.Errors.Add(field, fmt.Sprintf("this field must be at most %d characters long", length))
+		return false
+	}
+	return true
+}
+
 func (f *Form) IsEmail(field string) bool {
 	if !govalidator.IsEmail(f.Get(field)) {
 		f.Errors.Add(field, "This is not an email address.")
@@ -58,3 +69,80 @@ func (f *Form) IsEmail(field string) bool {
 	}
 	return true
 }
+
+// Matches checks that field holds the same value as other, e.g. a password confirmation
+func (f *Form) Matches(field, other string) bool {
+	if f.Get(field) != f.Get(other) {
+		f.Errors.Add(field, fmt.Sprintf("this field must match %s", other))
+		return false
+	}
+	return true
+}
+
+// IsInt checks that field holds a whole number of at least min
+func (f *Form) IsInt(field string, min int) bool {
+	x, err := strconv.Atoi(f.Get(field))
+	if err != nil {
+		f.Errors.Add(field, "This is not a whole number.")
+		return false
+	}
+	if x < min {
+		f.Errors.Add(field, fmt.Sprintf("this field must be at least %d", min))
+		return false
+	}
+	return true
+}
+
+// DateLayout is the layout of every date field, as sent by date inputs
+const DateLayout = "2006-01-02"
+
+// IsDate checks that field holds a date like 2022-10-18
+func (f *Form) IsDate(field string) bool {
+	if _, err := time.Parse(DateLayout, f.Get(field)); err != nil {
+		f.Errors.Add(field, "This is not a valid date.")
+		return false
+	}
+	return true
+}
+
+// IsPrice checks that field holds a non negative amount with at most two decimals
+func (f *Form) IsPrice(field string) bool {
+	if _, err := ParsePrice(f.Get(field)); err != nil {
+		f.Errors.Add(field, "This is not a valid price.")
+		return false
+	}
+	return true
+}
+
+// ParsePrice converts an amount like "120.50" to cents
+func ParsePrice(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	whole, fraction := value, "00"
+	if i := strings.Index(value, "."); i >= 0 {
+		whole, fraction = value[:i], (value[i+1:] + "00")[:2]
+		if len(value[i+1:]) > 2 {
+			return 0, fmt.Errorf("invalid price %q", value)
+		}
+	}
+
+	if !isDigits(whole) || !isDigits(fraction) {
+		return 0, fmt.Errorf("invalid price %q", value)
+	}
+
+	units, _ := strconv.Atoi(whole)
+	cents, _ := strconv.Atoi(fraction)
+
+	return units*100 + cents, nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
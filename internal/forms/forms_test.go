@@ -96,6 +96,30 @@ func TestMinLength(t *testing.T) {
 	}
 }
 
+func TestMaxLength(t *testing.T) {
+	r := httptest.NewRequest("POST", "/whatever", nil)
+
+	postedData := url.Values{}
+	postedData.Add("a", "aa")
+	r.PostForm = postedData
+	form := New(r.PostForm)
+
+	if !form.MaxLength("a", 2) {
+		t.Error("form has valid length when test say its not")
+	}
+
+	r, _ = http.NewRequest("POST", "/whatever", nil)
+
+	postedData = url.Values{}
+	postedData.Add("a", "aaa")
+	r.PostForm = postedData
+	form = New(r.PostForm)
+
+	if form.MaxLength("a", 2) {
+		t.Error("form does not have valid length when test say it is")
+	}
+}
+
 func TestIsEmail(t *testing.T) {
 	r := httptest.NewRequest("POST", "/whatever", nil)
 
@@ -115,3 +139,92 @@ func TestIsEmail(t *testing.T) {
 		t.Error("form shows valid email when form does not have valid email")
 	}
 }
+
+func TestIsInt(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("a", "3")
+	postedData.Add("b", "0")
+	postedData.Add("c", "x")
+	form := New(postedData)
+
+	if !form.IsInt("a", 1) {
+		t.Error("form shows invalid int when it is valid")
+	}
+
+	if form.IsInt("b", 1) {
+		t.Error("form shows valid int when it is below the minimum")
+	}
+
+	if form.IsInt("c", 0) {
+		t.Error("form shows valid int when it is not a number")
+	}
+}
+
+func TestIsDate(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("a", "2022-10-18")
+	postedData.Add("b", "18/10/2022")
+	postedData.Add("c", "2022-02-30")
+	form := New(postedData)
+
+	if !form.IsDate("a") {
+		t.Error("form shows invalid date when it is valid")
+	}
+
+	if form.IsDate("b") {
+		t.Error("form shows valid date in the wrong layout")
+	}
+
+	if form.IsDate("c") {
+		t.Error("form shows valid date for a day that doesn't exist")
+	}
+}
+
+func TestMatches(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("password", "secret123")
+	postedData.Add("password_confirmation", "secret123")
+	postedData.Add("other", "secret124")
+	form := New(postedData)
+
+	if !form.Matches("password_confirmation", "password") {
+		t.Error("form shows fields don't match when they do")
+	}
+
+	if form.Matches("other", "password") {
+		t.Error("form shows fields match when they don't")
+	}
+}
+
+var priceTests = []struct {
+	value    string
+	expected int
+	valid    bool
+}{
+	{"120", 12000, true},
+	{"120.5", 12050, true},
+	{"0.05", 5, true},
+	{" 99.99 ", 9999, true},
+	{"", 0, false},
+	{"-1", 0, false},
+	{"1.234", 0, false},
+	{"1.-5", 0, false},
+	{"abc", 0, false},
+}
+
+func TestIsPrice(t *testing.T) {
+	for _, e := range priceTests {
+		postedData := url.Values{}
+		postedData.Add("price", e.value)
+		form := New(postedData)
+
+		if form.IsPrice("price") != e.valid {
+			t.Errorf("for %q expected valid to be %t", e.value, e.valid)
+		}
+
+		cents, err := ParsePrice(e.value)
+		if e.valid && (err != nil || cents != e.expected) {
+			t.Errorf("for %q expected %d cents but got %d (%v)", e.value, e.expected, cents, err)
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package helpers
 
 import (
+	"crypto/rand"
 	"fmt"
 	"github.com/amiranbari/bookings/pkg/config"
+	"math/big"
 	"net/http"
 	"runtime/debug"
 )
@@ -30,4 +32,27 @@ func IsAuthenticated(r *http.Request) bool {
 	exist := app.Session.Exists(r.Context(), "user_id")
 	return exist
 
-}
\ No newline at end of file
+}
+
+// AccessLevel returns the access level of the logged in user, as last read from the database by the admin middleware
+func AccessLevel(r *http.Request) int {
+	return app.Session.GetInt(r.Context(), "access_level")
+}
+
+// CodeAlphabet is what codes people read back are made of, confirmation and recovery codes alike. It leaves
+// out characters that are easy to confuse (0/O, 1/I).
+const CodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// NewConfirmationCode returns a random 12 character code guests use to find their reservation
+func NewConfirmationCode() (string, error) {
+	code := make([]byte, 12)
+	max := big.NewInt(int64(len(CodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = CodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
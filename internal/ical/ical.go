@@ -0,0 +1,103 @@
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// dateLayout is the DATE value type, events are whole days
+	dateLayout = "20060102"
+	// stampLayout is the DATE-TIME value type in UTC
+	stampLayout = "20060102T150405Z"
+	// lineLength is the longest a content line may be in octets, longer ones are folded
+	lineLength = 75
+)
+
+// Calendar is an iCalendar (RFC 5545) object holding all-day events
+type Calendar struct {
+	ProdID string
+	// Name is shown by calendar apps that support X-WR-CALNAME
+	Name   string
+	Events []Event
+}
+
+// Event is an all-day VEVENT, End is exclusive like DTEND
+type Event struct {
+	UID         string
+	Start       time.Time
+	End         time.Time
+	Summary     string
+	Description string
+	// Status is TENTATIVE, CONFIRMED or CANCELLED, empty if the calendar doesn't say
+	Status string
+	// Stamp is when the event was last changed
+	Stamp time.Time
+}
+
+// Write encodes c to w
+func (c Calendar) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	line := func(name, value string) {
+		writeLine(bw, name+":"+value)
+	}
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", c.ProdID)
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	if c.Name != "" {
+		line("X-WR-CALNAME", escape(c.Name))
+	}
+
+	for _, e := range c.Events {
+		line("BEGIN", "VEVENT")
+		line("UID", e.UID)
+		line("DTSTAMP", e.Stamp.UTC().Format(stampLayout))
+		line("DTSTART;VALUE=DATE", e.Start.Format(dateLayout))
+		line("DTEND;VALUE=DATE", e.End.Format(dateLayout))
+		line("SUMMARY", escape(e.Summary))
+		if e.Description != "" {
+			line("DESCRIPTION", escape(e.Description))
+		}
+		if e.Status != "" {
+			line("STATUS", e.Status)
+		}
+		line("TRANSP", "OPAQUE")
+		line("END", "VEVENT")
+	}
+
+	line("END", "VCALENDAR")
+
+	return bw.Flush()
+}
+
+// writeLine writes a content line, folding it so no line is longer than lineLength octets. Lines are
+// never split inside a UTF-8 sequence.
+func writeLine(w *bufio.Writer, s string) {
+	limit := lineLength
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		fmt.Fprintf(w, "%s\r\n ", s[:cut])
+		s = s[cut:]
+		// the leading space of a continuation line counts towards its length
+		limit = lineLength - 1
+	}
+	fmt.Fprintf(w, "%s\r\n", s)
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// escape quotes a TEXT value
+func escape(s string) string {
+	return escaper.Replace(s)
+}
@@ -0,0 +1,82 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	cal := Calendar{
+		ProdID: "-//Bookings//Room calendar//EN",
+		Name:   "General's Quarters",
+		Events: []Event{
+			{
+				UID:         "restriction-1@bookings",
+				Start:       time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:         time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+				Summary:     "Reserved: Smith, John; 2 nights",
+				Description: "line one\nline two",
+				Stamp:       time.Date(2049, 12, 1, 10, 30, 0, 0, time.FixedZone("CET", 3600)),
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := cal.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	if !strings.HasSuffix(out, "END:VCALENDAR\r\n") {
+		t.Errorf("lines don't end in CRLF: %q", out)
+	}
+
+	for _, line := range []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"X-WR-CALNAME:General's Quarters",
+		"UID:restriction-1@bookings",
+		"DTSTAMP:20491201T093000Z",
+		"DTSTART;VALUE=DATE:20500101",
+		"DTEND;VALUE=DATE:20500103",
+		`SUMMARY:Reserved: Smith\, John\; 2 nights`,
+		`DESCRIPTION:line one\nline two`,
+		"END:VEVENT",
+	} {
+		if !strings.Contains(out, line+"\r\n") {
+			t.Errorf("missing line %q in %q", line, out)
+		}
+	}
+}
+
+func TestWriteFoldsLongLines(t *testing.T) {
+	cal := Calendar{
+		ProdID: "-//Bookings//Room calendar//EN",
+		Events: []Event{{Summary: strings.Repeat("é", 100)}},
+	}
+
+	var buf bytes.Buffer
+	if err := cal.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var summary string
+	for i, line := range strings.Split(buf.String(), "\r\n") {
+		if len(line) > lineLength {
+			t.Errorf("line %d is %d octets long", i, len(line))
+		}
+		if strings.HasPrefix(line, "SUMMARY:") {
+			summary = line
+		} else if summary != "" && strings.HasPrefix(line, " ") {
+			summary += line[1:]
+		} else if summary != "" {
+			break
+		}
+	}
+
+	if summary != "SUMMARY:"+strings.Repeat("é", 100) {
+		t.Errorf("unfolded summary doesn't match, got %q", summary)
+	}
+}
@@ -0,0 +1,162 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrNotCalendar is returned by Parse for input that isn't an iCalendar object
+var ErrNotCalendar = errors.New("not an iCalendar file")
+
+// maxLineLength is the longest unfolded content line Parse accepts
+const maxLineLength = 1 << 20
+
+// Parse reads the events of an iCalendar object. Every event is turned into whole days like the ones
+// written by Calendar.Write: times are taken as written and an event ending during a day covers that
+// day too. Recurrence rules are not expanded.
+func Parse(r io.Reader) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lines) == 0 || !strings.EqualFold(lines[0], "BEGIN:VCALENDAR") {
+		return nil, ErrNotCalendar
+	}
+
+	var events []Event
+	var event *Event
+	var end string
+
+	for _, l := range lines {
+		name, value := splitLine(l)
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			event = &Event{}
+			end = ""
+		case name == "END" && strings.EqualFold(value, "VEVENT") && event != nil:
+			if event.Start.IsZero() {
+				return nil, fmt.Errorf("event %q has no DTSTART", event.UID)
+			}
+			event.End, err = endDate(event.Start, end)
+			if err != nil {
+				return nil, fmt.Errorf("event %q: %w", event.UID, err)
+			}
+			events = append(events, *event)
+			event = nil
+		case event == nil:
+			// calendar properties and other components are of no interest
+		case name == "UID":
+			event.UID = value
+		case name == "SUMMARY":
+			event.Summary = unescape(value)
+		case name == "DESCRIPTION":
+			event.Description = unescape(value)
+		case name == "STATUS":
+			event.Status = strings.ToUpper(value)
+		case name == "DTSTAMP":
+			event.Stamp, _ = parseDate(value)
+		case name == "DTSTART":
+			event.Start, err = parseDate(value)
+			if err != nil {
+				return nil, fmt.Errorf("event %q: invalid DTSTART %q", event.UID, value)
+			}
+			event.Start = day(event.Start)
+		case name == "DTEND":
+			end = value
+		}
+	}
+
+	return events, nil
+}
+
+// endDate returns the exclusive end day of an event starting on the day start, value is its DTEND
+func endDate(start time.Time, value string) (time.Time, error) {
+	if value == "" {
+		return start.AddDate(0, 0, 1), nil
+	}
+
+	t, err := parseDate(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid DTEND %q", value)
+	}
+
+	end := day(t)
+	if !t.Equal(end) {
+		// ends during the day, so that day is taken too
+		end = end.AddDate(0, 0, 1)
+	}
+
+	if !end.After(start) {
+		end = start.AddDate(0, 0, 1)
+	}
+
+	return end, nil
+}
+
+// parseDate reads a DATE or DATE-TIME value, the time zone is ignored
+func parseDate(value string) (time.Time, error) {
+	value = strings.TrimSuffix(value, "Z")
+	if len(value) == len(dateLayout) {
+		return time.Parse(dateLayout, value)
+	}
+	return time.Parse("20060102T150405", value)
+}
+
+func day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// unfold returns the content lines of r, joining folded lines back together
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), maxLineLength)
+
+	var lines []string
+	for scanner.Scan() {
+		l := strings.TrimRight(scanner.Text(), "\r")
+		if l == "" {
+			continue
+		}
+
+		if (l[0] == ' ' || l[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += l[1:]
+			continue
+		}
+
+		lines = append(lines, l)
+	}
+
+	return lines, scanner.Err()
+}
+
+// splitLine splits a content line like DTSTART;VALUE=DATE:20500101 into its upper case name and its
+// value, parameters are dropped
+func splitLine(l string) (name, value string) {
+	quoted := false
+	for i, c := range l {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ':' && !quoted:
+			name, value = l[:i], l[i+1:]
+			if j := strings.IndexByte(name, ';'); j >= 0 {
+				name = name[:j]
+			}
+			return strings.ToUpper(name), value
+		}
+	}
+	return strings.ToUpper(l), ""
+}
+
+var unescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+
+// unescape reads a TEXT value
+func unescape(s string) string {
+	return unescaper.Replace(s)
+}
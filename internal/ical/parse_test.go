@@ -0,0 +1,100 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+const externalFeed = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Other site//EN\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:all-day@other\r\n" +
+	"DTSTART;VALUE=DATE:20500101\r\n" +
+	"DTEND;VALUE=DATE:20500104\r\n" +
+	"SUMMARY:Reserved\\, paid\r\n" +
+	"DESCRIPTION:a long description that was\r\n" +
+	"  folded\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:timed@other\r\n" +
+	"DTSTART;TZID=\"Europe/Berlin\":20500110T150000\r\n" +
+	"DTEND;TZID=\"Europe/Berlin\":20500112T110000\r\n" +
+	"STATUS:cancelled\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:no-end@other\r\n" +
+	"DTSTART:20500120T000000Z\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func date(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestParse(t *testing.T) {
+	events, err := Parse(strings.NewReader(externalFeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Event{
+		{UID: "all-day@other", Start: date(2050, 1, 1), End: date(2050, 1, 4), Summary: "Reserved, paid", Description: "a long description that was folded"},
+		{UID: "timed@other", Start: date(2050, 1, 10), End: date(2050, 1, 13), Status: "CANCELLED"},
+		{UID: "no-end@other", Start: date(2050, 1, 20), End: date(2050, 1, 21)},
+	}
+
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events but got %d", len(expected), len(events))
+	}
+
+	for i, e := range expected {
+		if events[i] != e {
+			t.Errorf("event %d: expected %+v but got %+v", i, e, events[i])
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"not-a-calendar", "<html></html>"},
+		{"empty", ""},
+		{"no-start", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:x\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+		{"invalid-start", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:tomorrow\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+		{"invalid-end", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:20500101\r\nDTEND:soon\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+	}
+
+	for _, e := range tests {
+		if _, err := Parse(strings.NewReader(e.input)); err == nil {
+			t.Errorf("for %s, expected an error", e.name)
+		}
+	}
+}
+
+func TestParseWritten(t *testing.T) {
+	cal := Calendar{
+		ProdID: "-//Bookings//Room calendar//EN",
+		Events: []Event{
+			{UID: "1@bookings", Start: date(2050, 3, 1), End: date(2050, 3, 2), Summary: "Blocked; by owner", Stamp: date(2050, 1, 1)},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := cal.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 || events[0] != cal.Events[0] {
+		t.Errorf("expected %+v but got %+v", cal.Events, events)
+	}
+}
@@ -0,0 +1,380 @@
+package importer
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amiranbari/bookings/internal/forms"
+	"github.com/amiranbari/bookings/internal/helpers"
+	"github.com/amiranbari/bookings/internal/repository"
+	"github.com/amiranbari/bookings/pkg/models"
+)
+
+// Kinds of rows that can be imported
+const (
+	Rooms        = "rooms"
+	Reservations = "reservations"
+)
+
+// Kinds lists every kind of rows that can be imported
+var Kinds = []string{Rooms, Reservations}
+
+// Columns of each kind of file, the header names them in any order and other columns are ignored
+var (
+	RoomColumns        = []string{"title", "description", "max_occupancy", "nightly_rate", "weekend_uplift", "min_stay", "amenities"}
+	ReservationColumns = []string{"firstname", "lastname", "email", "phone", "room", "start_date", "end_date", "status", "amount"}
+)
+
+var (
+	requiredRoomColumns        = []string{"title", "max_occupancy", "nightly_rate"}
+	requiredReservationColumns = []string{"firstname", "lastname", "email", "room", "start_date", "end_date"}
+)
+
+var (
+	// ErrUnknownKind is returned when asked to import something else than Kinds
+	ErrUnknownKind = errors.New("only rooms and reservations can be imported")
+	// ErrInvalidFile wraps the reason a file can't be imported at all, like a missing column
+	ErrInvalidFile = errors.New("invalid file")
+)
+
+// Store is the part of the repository an import needs
+type Store interface {
+	AllRoomsWithArchived(ctx context.Context) ([]models.Room, error)
+	InsertRoom(ctx context.Context, room models.Room) (int, error)
+	ImportReservation(ctx context.Context, res models.Reservation) (int, error)
+}
+
+// RowError is why a line of the file wasn't imported
+type RowError struct {
+	Line     int // the header is line 1
+	Messages []string
+}
+
+func (e RowError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, strings.Join(e.Messages, "; "))
+}
+
+// Result tells what an import did
+type Result struct {
+	Imported int
+	Errors   []RowError
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("%d imported, %d not imported", r.Imported, len(r.Errors))
+}
+
+// Importer loads rooms and reservations from CSV files. Every row is checked before anything is stored,
+// the valid ones are then stored in a single transaction.
+type Importer struct {
+	Store Store
+	// WithTx runs fn against a Store bound to a single transaction, committing when fn returns nil
+	WithTx func(ctx context.Context, fn func(store Store) error) error
+}
+
+// New returns an Importer storing rows in db
+func New(db repository.DatabaseRepo) *Importer {
+	return &Importer{
+		Store: db,
+		WithTx: func(ctx context.Context, fn func(store Store) error) error {
+			return db.WithTx(ctx, func(repo repository.DatabaseRepo) error {
+				return fn(repo)
+			})
+		},
+	}
+}
+
+// Import reads the CSV file r holding rows of kind. Rows that aren't valid or whose room is already taken
+// for their dates are reported in the result, an error means nothing was imported.
+func (i *Importer) Import(ctx context.Context, kind string, r io.Reader) (Result, error) {
+	switch kind {
+	case Rooms:
+		return i.rooms(ctx, r)
+	case Reservations:
+		return i.reservations(ctx, r)
+	default:
+		return Result{}, ErrUnknownKind
+	}
+}
+
+func (i *Importer) rooms(ctx context.Context, r io.Reader) (Result, error) {
+	rows, result, err := readCSV(r, requiredRoomColumns)
+	if err != nil {
+		return result, err
+	}
+
+	existing, err := i.Store.AllRoomsWithArchived(ctx)
+	if err != nil {
+		return result, err
+	}
+	titles := make(map[string]bool)
+	for _, room := range existing {
+		titles[strings.ToLower(room.Title)] = true
+	}
+
+	var rooms []models.Room
+	for _, row := range rows {
+		form := forms.New(row.values)
+		models.ValidateRoomForm(form)
+
+		var room models.Room
+		models.RoomFromForm(&room, form)
+		if room.Title != "" && titles[strings.ToLower(room.Title)] {
+			form.Errors.Add("title", fmt.Sprintf("a room called %s already exists", room.Title))
+		}
+
+		if !form.Valid() {
+			result.Errors = append(result.Errors, rowError(row.line, form, RoomColumns))
+			continue
+		}
+
+		titles[strings.ToLower(room.Title)] = true
+		rooms = append(rooms, room)
+	}
+
+	err = i.WithTx(ctx, func(store Store) error {
+		for _, room := range rooms {
+			if _, err := store.InsertRoom(ctx, room); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	result.Imported = len(rooms)
+	sortErrors(result.Errors)
+	return result, nil
+}
+
+// reservationRow is a valid reservation waiting to be stored
+type reservationRow struct {
+	line        int
+	reservation models.Reservation
+}
+
+func (i *Importer) reservations(ctx context.Context, r io.Reader) (Result, error) {
+	rows, result, err := readCSV(r, requiredReservationColumns)
+	if err != nil {
+		return result, err
+	}
+
+	rooms, err := i.Store.AllRoomsWithArchived(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	var reservations []reservationRow
+	for _, row := range rows {
+		form := forms.New(row.values)
+		validateReservation(form)
+
+		res, err := reservationFromForm(form, rooms)
+		if err != nil {
+			return result, err
+		}
+
+		if !form.Valid() {
+			result.Errors = append(result.Errors, rowError(row.line, form, ReservationColumns))
+			continue
+		}
+
+		reservations = append(reservations, reservationRow{line: row.line, reservation: res})
+	}
+
+	// stays overlapping one stored before them, from the file or not, are left out. ImportReservation runs
+	// in a savepoint of the transaction, so a refused stay doesn't stop the rows after it.
+	err = i.WithTx(ctx, func(store Store) error {
+		for _, row := range reservations {
+			_, err := store.ImportReservation(ctx, row.reservation)
+			if errors.Is(err, repository.ErrRoomNotAvailable) {
+				result.Errors = append(result.Errors, RowError{Line: row.line, Messages: []string{
+					fmt.Sprintf("room: %s is already taken between %s and %s", row.reservation.Room.Title,
+						row.reservation.StartDate.Format(forms.DateLayout), row.reservation.EndDate.Format(forms.DateLayout)),
+				}})
+				continue
+			} else if err != nil {
+				return err
+			}
+			result.Imported++
+		}
+		return nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	sortErrors(result.Errors)
+	return result, nil
+}
+
+// validateReservation checks a reservation row with the rules of the guest form, the phone is optional
+// because older records often don't have one
+func validateReservation(form *forms.Form) {
+	form.Required("firstname", "lastname", "email", "room", "start_date", "end_date")
+	form.MaxLength("firstname", 255)
+	form.MaxLength("lastname", 255)
+	if form.Get("email") != "" {
+		form.IsEmail("email")
+	}
+	if form.Get("start_date") != "" {
+		form.IsDate("start_date")
+	}
+	if form.Get("end_date") != "" {
+		form.IsDate("end_date")
+	}
+	if status := form.Get("status"); status != "" && !models.ValidReservationStatus(status) {
+		form.Errors.Add("status", fmt.Sprintf("unknown status %s", status))
+	}
+	if form.Get("amount") != "" {
+		form.IsPrice("amount")
+	}
+}
+
+// reservationFromForm reads a reservation row, the room is its ID or its title. Rows without a status were
+// confirmed in the system they come from.
+func reservationFromForm(form *forms.Form, rooms []models.Room) (models.Reservation, error) {
+	res := models.Reservation{
+		FirstName: strings.TrimSpace(form.Get("firstname")),
+		LastName:  strings.TrimSpace(form.Get("lastname")),
+		Email:     strings.TrimSpace(form.Get("email")),
+		Phone:     strings.TrimSpace(form.Get("phone")),
+		Status:    form.Get("status"),
+	}
+	if res.Status == "" {
+		res.Status = models.ReservationConfirmed
+	}
+	res.Amount, _ = forms.ParsePrice(form.Get("amount"))
+	res.StartDate, _ = time.Parse(forms.DateLayout, form.Get("start_date"))
+	res.EndDate, _ = time.Parse(forms.DateLayout, form.Get("end_date"))
+
+	if !res.StartDate.IsZero() && !res.EndDate.IsZero() && !res.EndDate.After(res.StartDate) {
+		form.Errors.Add("end_date", "departure must be after arrival")
+	}
+
+	if room, ok := findRoom(rooms, form.Get("room")); ok {
+		res.RoomId = room.ID
+		res.Room = room
+	} else if form.Get("room") != "" {
+		form.Errors.Add("room", fmt.Sprintf("there is no room %s", form.Get("room")))
+	}
+
+	var err error
+	res.ConfirmationCode, err = helpers.NewConfirmationCode()
+	return res, err
+}
+
+// findRoom looks a room up by its ID or, ignoring case, its title
+func findRoom(rooms []models.Room, ref string) (models.Room, bool) {
+	ref = strings.TrimSpace(ref)
+	id, err := strconv.Atoi(ref)
+	for _, room := range rooms {
+		if (err == nil && room.ID == id) || strings.EqualFold(room.Title, ref) {
+			return room, true
+		}
+	}
+	return models.Room{}, false
+}
+
+// row is a record of the file as form values
+type row struct {
+	line   int
+	values url.Values
+}
+
+// readCSV reads the header and the records of a CSV file. Records with more or less fields than the header
+// are reported in the result, an error means the file can't be read or lacks one of the required columns.
+func readCSV(r io.Reader, required []string) ([]row, Result, error) {
+	var result Result
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, result, fmt.Errorf("%w: the file is empty", ErrInvalidFile)
+	} else if err != nil {
+		return nil, result, fmt.Errorf("%w: %s", ErrInvalidFile, err)
+	}
+
+	columns := make([]string, len(header))
+	present := make(map[string]bool)
+	for n, name := range header {
+		// spreadsheet apps often start the file with a byte order mark
+		name = strings.TrimPrefix(name, "\ufeff")
+		name = strings.ToLower(strings.TrimSpace(name))
+		name = strings.NewReplacer(" ", "_", "-", "_").Replace(name)
+		columns[n] = name
+		present[name] = true
+	}
+
+	var missing []string
+	for _, column := range required {
+		if !present[column] {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, result, fmt.Errorf("%w: the header lacks the %s columns", ErrInvalidFile, strings.Join(missing, ", "))
+	}
+
+	var rows []row
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, result, fmt.Errorf("%w: %s", ErrInvalidFile, err)
+		}
+
+		line, _ := cr.FieldPos(0)
+		if len(record) != len(columns) {
+			result.Errors = append(result.Errors, RowError{Line: line, Messages: []string{
+				fmt.Sprintf("expected %d fields like the header but got %d", len(columns), len(record)),
+			}})
+			continue
+		}
+
+		values := url.Values{}
+		blank := true
+		for n, value := range record {
+			values.Set(columns[n], strings.TrimSpace(value))
+			blank = blank && strings.TrimSpace(value) == ""
+		}
+		if blank {
+			continue
+		}
+		rows = append(rows, row{line: line, values: values})
+	}
+
+	return rows, result, nil
+}
+
+// rowError lists the errors of form, one per field in the order of columns
+func rowError(line int, form *forms.Form, columns []string) RowError {
+	e := RowError{Line: line}
+	for _, column := range columns {
+		if msg := form.Errors.Get(column); msg != "" {
+			e.Messages = append(e.Messages, column+": "+msg)
+		}
+	}
+	return e
+}
+
+// sortErrors orders errors by line, rows refused while storing come after those refused while reading
+func sortErrors(errs []RowError) {
+	sort.SliceStable(errs, func(a, b int) bool {
+		return errs[a].Line < errs[b].Line
+	})
+}
@@ -0,0 +1,222 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amiranbari/bookings/internal/repository"
+	"github.com/amiranbari/bookings/pkg/models"
+)
+
+// memoryStore keeps rooms and reservations in memory, a reservation takes its room unless it was cancelled
+type memoryStore struct {
+	rooms        []models.Room
+	reservations []models.Reservation
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{rooms: []models.Room{
+		{ID: 1, Title: "General's Quarters"},
+		{ID: 2, Title: "Major's Suite", Archived: true},
+	}}
+}
+
+func (m *memoryStore) AllRoomsWithArchived(ctx context.Context) ([]models.Room, error) {
+	return m.rooms, nil
+}
+
+func (m *memoryStore) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	if room.Title == "Broken Room" {
+		return 0, errors.New("some error!")
+	}
+	room.ID = len(m.rooms) + 1
+	m.rooms = append(m.rooms, room)
+	return room.ID, nil
+}
+
+func (m *memoryStore) ImportReservation(ctx context.Context, res models.Reservation) (int, error) {
+	if res.FirstName == "Broken" {
+		return 0, errors.New("some error!")
+	}
+	if res.Status != models.ReservationCancelled {
+		for _, other := range m.reservations {
+			if other.RoomId == res.RoomId && other.Status != models.ReservationCancelled &&
+				!res.StartDate.After(other.EndDate) && !res.EndDate.Before(other.StartDate) {
+				return 0, repository.ErrRoomNotAvailable
+			}
+		}
+	}
+	res.ID = len(m.reservations) + 1
+	m.reservations = append(m.reservations, res)
+	return res.ID, nil
+}
+
+// withTx keeps what fn stored only when it returns nil
+func (m *memoryStore) withTx(ctx context.Context, fn func(store Store) error) error {
+	rooms, reservations := m.rooms, m.reservations
+	err := fn(m)
+	if err != nil {
+		m.rooms, m.reservations = rooms, reservations
+	}
+	return err
+}
+
+func newImporter(store *memoryStore) *Importer {
+	return &Importer{Store: store, WithTx: store.withTx}
+}
+
+func TestImportRooms(t *testing.T) {
+	store := newMemoryStore()
+
+	file := "\ufeffTitle,Max Occupancy,Nightly Rate,Weekend Uplift,Min Stay,Amenities,Notes\n" +
+		"Colonel's Room,2,120.50,10,2,\"Wifi, Sea View\",ignored\n" +
+		"Ti,2,80,,,,\n" +
+		"Private's Room,,abc,,,,\n" +
+		"general's quarters,2,100,,,,\n" +
+		",,,,,,\n" +
+		"Too,few\n" +
+		"Colonel's Room,2,100,,,,\n"
+
+	result, err := newImporter(store).Import(context.Background(), Rooms, strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Imported != 1 || len(store.rooms) != 3 {
+		t.Fatalf("expected 1 room to be imported but got %d, %d rooms stored", result.Imported, len(store.rooms))
+	}
+
+	room := store.rooms[2]
+	if room.Title != "Colonel's Room" || room.MaxOccupancy != 2 || room.NightlyRate != 12050 || room.WeekendUplift != 10 ||
+		room.MinStay != 2 || strings.Join(room.Amenities, "|") != "wifi|sea view" {
+		t.Errorf("unexpected room %+v", room)
+	}
+
+	expected := []string{
+		"line 3: title: this field must be at least 3 characters long",
+		"line 4: max_occupancy: max_occupancy cannot be blank; nightly_rate: This is not a valid price.",
+		"line 5: title: a room called general's quarters already exists",
+		"line 7: expected 7 fields like the header but got 2",
+		"line 8: title: a room called Colonel's Room already exists",
+	}
+	checkErrors(t, result, expected)
+}
+
+func TestImportRoomsRollsBack(t *testing.T) {
+	store := newMemoryStore()
+
+	file := "title,max_occupancy,nightly_rate\nColonel's Room,2,100\nBroken Room,2,100\n"
+
+	_, err := newImporter(store).Import(context.Background(), Rooms, strings.NewReader(file))
+	if err == nil {
+		t.Fatal("expected the import to fail")
+	}
+
+	if len(store.rooms) != 2 {
+		t.Errorf("expected no room to be kept but got %d rooms", len(store.rooms))
+	}
+}
+
+func TestImportReservations(t *testing.T) {
+	store := newMemoryStore()
+	store.reservations = []models.Reservation{
+		{ID: 1, RoomId: 1, Status: models.ReservationConfirmed,
+			StartDate: time.Date(2050, 3, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 3, 5, 0, 0, 0, 0, time.UTC)},
+	}
+
+	file := "firstname,lastname,email,phone,room,start_date,end_date,status,amount\n" +
+		"John,Smith,john@smith.com,555,General's Quarters,2050-01-01,2050-01-03,,240\n" +
+		"Jane,Doe,jane@doe.com,,2,2020-01-01,2020-01-03,checked-out,\n" +
+		"Jack,Doe,jack@doe.com,,1,2050-01-02,2050-01-04,,\n" +
+		"Jill,Doe,jill@doe.com,,1,2050-01-02,2050-01-04,cancelled,\n" +
+		"Joe,Doe,joe@doe.com,,1,2050-03-04,2050-03-06,,\n" +
+		"Jim,,not-an-email,,Penthouse,2050-02-05,2050-02-01,deleted,12.345\n" +
+		"Jo,Doe,jo@doe.com,,1,2050-13-01,,,\n"
+
+	result, err := newImporter(store).Import(context.Background(), Reservations, strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Imported != 3 || len(store.reservations) != 4 {
+		t.Fatalf("expected 3 reservations to be imported but got %d, %d reservations stored", result.Imported, len(store.reservations))
+	}
+
+	res := store.reservations[1]
+	if res.FirstName != "John" || res.RoomId != 1 || res.Status != models.ReservationConfirmed || res.Amount != 24000 ||
+		res.StartDate.Format("2006-01-02") != "2050-01-01" || len(res.ConfirmationCode) != 12 {
+		t.Errorf("unexpected reservation %+v", res)
+	}
+
+	if res := store.reservations[2]; res.RoomId != 2 || res.Status != models.ReservationCheckedOut || res.Amount != 0 {
+		t.Errorf("expected the past stay in the archived room, got %+v", res)
+	}
+
+	if res := store.reservations[3]; res.FirstName != "Jill" || res.Status != models.ReservationCancelled {
+		t.Errorf("expected the cancelled stay not to conflict, got %+v", res)
+	}
+
+	expected := []string{
+		"line 4: room: General's Quarters is already taken between 2050-01-02 and 2050-01-04",
+		"line 6: room: General's Quarters is already taken between 2050-03-04 and 2050-03-06",
+		"line 7: lastname: lastname cannot be blank; email: This is not an email address.; room: there is no room Penthouse; " +
+			"end_date: departure must be after arrival; status: unknown status deleted; amount: This is not a valid price.",
+		"line 8: start_date: This is not a valid date.; end_date: end_date cannot be blank",
+	}
+	checkErrors(t, result, expected)
+}
+
+func TestImportReservationsRollsBack(t *testing.T) {
+	store := newMemoryStore()
+
+	file := "firstname,lastname,email,room,start_date,end_date\n" +
+		"John,Smith,john@smith.com,1,2050-01-01,2050-01-03\n" +
+		"Broken,Smith,john@smith.com,1,2050-02-01,2050-02-03\n"
+
+	_, err := newImporter(store).Import(context.Background(), Reservations, strings.NewReader(file))
+	if err == nil {
+		t.Fatal("expected the import to fail")
+	}
+
+	if len(store.reservations) != 0 {
+		t.Errorf("expected no reservation to be kept but got %d", len(store.reservations))
+	}
+}
+
+func TestImportInvalidFiles(t *testing.T) {
+	tests := []struct {
+		name          string
+		kind          string
+		file          string
+		expected      error
+		expectedError string
+	}{
+		{"unknown-kind", "users", "email\n", ErrUnknownKind, ErrUnknownKind.Error()},
+		{"empty", Rooms, "", ErrInvalidFile, "the file is empty"},
+		{"missing-columns", Reservations, "firstname,lastname,email\n", ErrInvalidFile, "the header lacks the room, start_date, end_date columns"},
+		{"bad-quotes", Rooms, "title,max_occupancy,nightly_rate\n\"Colonel's Room,2,100\n", ErrInvalidFile, "extraneous or missing"},
+	}
+
+	for _, e := range tests {
+		_, err := newImporter(newMemoryStore()).Import(context.Background(), e.kind, strings.NewReader(e.file))
+		if !errors.Is(err, e.expected) || !strings.Contains(err.Error(), e.expectedError) {
+			t.Errorf("for %s, expected error %q but got %v", e.name, e.expectedError, err)
+		}
+	}
+}
+
+func checkErrors(t *testing.T, result Result, expected []string) {
+	t.Helper()
+
+	var got []string
+	for _, e := range result.Errors {
+		got = append(got, e.Error())
+	}
+
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected errors\n%s\nbut got\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
@@ -0,0 +1,44 @@
+package lockout
+
+import (
+	"time"
+)
+
+// Policy decides how long to refuse logins after a run of failed attempts. The first FreeAttempts
+// failures cost nothing, after that every failure doubles the wait, starting at BaseDelay and
+// never exceeding MaxDelay.
+type Policy struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+}
+
+// Delay returns how long to wait after the last of failures failed attempts
+func (p Policy) Delay(failures int) time.Duration {
+	if failures < p.FreeAttempts {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts; i < failures; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+
+	if delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+// LockedUntil returns when the next attempt is allowed after failures failed attempts, the last at last
+func (p Policy) LockedUntil(failures int, last time.Time) time.Time {
+	return last.Add(p.Delay(failures))
+}
+
+// Locked reports whether an attempt at now has to be refused
+func (p Policy) Locked(failures int, last, now time.Time) bool {
+	return now.Before(p.LockedUntil(failures, last))
+}
@@ -0,0 +1,46 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+)
+
+var policy = Policy{FreeAttempts: 3, BaseDelay: 30 * time.Second, MaxDelay: 10 * time.Minute}
+
+var delayTests = []struct {
+	failures int
+	expected time.Duration
+}{
+	{0, 0},
+	{2, 0},
+	{3, 30 * time.Second},
+	{4, time.Minute},
+	{6, 4 * time.Minute},
+	{7, 8 * time.Minute},
+	{8, 10 * time.Minute},
+	{100, 10 * time.Minute},
+}
+
+func TestDelay(t *testing.T) {
+	for _, e := range delayTests {
+		if got := policy.Delay(e.failures); got != e.expected {
+			t.Errorf("for %d failures expected %s but got %s", e.failures, e.expected, got)
+		}
+	}
+}
+
+func TestLocked(t *testing.T) {
+	last := time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if policy.Locked(2, last, last) {
+		t.Error("locked before using up the free attempts")
+	}
+
+	if !policy.Locked(4, last, last.Add(59*time.Second)) {
+		t.Error("not locked during the backoff")
+	}
+
+	if policy.Locked(4, last, last.Add(time.Minute)) {
+		t.Error("still locked after the backoff")
+	}
+}
@@ -0,0 +1,184 @@
+package openapi
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Version is the OpenAPI version documents are written in
+const Version = "3.0.3"
+
+// Document is the subset of an OpenAPI 3 document this app needs
+type Document struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       Info                            `json:"info"`
+	Paths      map[string]map[string]Operation `json:"paths"`
+	Components Components                      `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type Components struct {
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme,omitempty"`
+	In          string `json:"in,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Operation is a single method on a path
+type Operation struct {
+	Summary     string                `json:"summary"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+type Schema struct {
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// New returns an empty document
+func New(title, version, description string) *Document {
+	return &Document{
+		OpenAPI: Version,
+		Info: Info{
+			Title:       title,
+			Version:     version,
+			Description: description,
+		},
+		Paths: make(map[string]map[string]Operation),
+	}
+}
+
+// Add documents method on path, paths use the same {param} syntax as chi
+func (d *Document) Add(method, path string, op Operation) {
+	if d.Paths[path] == nil {
+		d.Paths[path] = make(map[string]Operation)
+	}
+	d.Paths[path][strings.ToLower(method)] = op
+}
+
+// Has reports whether method on path is documented
+func (d *Document) Has(method, path string) bool {
+	_, ok := d.Paths[path][strings.ToLower(method)]
+	return ok
+}
+
+// JSON returns the content of a JSON body shaped like v
+func JSON(v interface{}) map[string]MediaType {
+	return map[string]MediaType{"application/json": {Schema: SchemaOf(v)}}
+}
+
+// HTML returns the content of an HTML page
+func HTML() map[string]MediaType {
+	return map[string]MediaType{"text/html": {Schema: &Schema{Type: "string"}}}
+}
+
+// SchemaOf describes the JSON encoding of v, using the json tags of its struct fields
+func SchemaOf(v interface{}) *Schema {
+	return schemaOf(reflect.TypeOf(v))
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func schemaOf(t reflect.Type) *Schema {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaOf(t.Elem())}
+	case reflect.Struct:
+		s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		addProperties(s, t)
+		return s
+	}
+
+	// interface{} and the like can hold anything
+	return &Schema{}
+}
+
+// addProperties adds the fields of struct t to s, fields of embedded structs are promoted like
+// encoding/json does
+func addProperties(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		name := f.Name
+		if tag := f.Tag.Get("json"); tag != "" {
+			name = strings.Split(tag, ",")[0]
+		}
+		if name == "-" {
+			continue
+		}
+
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && f.Tag.Get("json") == "" {
+			addProperties(s, f.Type)
+			continue
+		}
+
+		if f.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		s.Properties[name] = schemaOf(f.Type)
+	}
+}
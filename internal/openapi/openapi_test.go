@@ -0,0 +1,81 @@
+package openapi
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+type inner struct {
+	ID int `json:"id"`
+}
+
+type outer struct {
+	inner
+	Name     string         `json:"name"`
+	Tags     []string       `json:"tags,omitempty"`
+	Counts   map[string]int `json:"counts"`
+	When     time.Time      `json:"when"`
+	Ignored  string         `json:"-"`
+	Plain    bool
+	Child    *inner      `json:"child"`
+	Anything interface{} `json:"anything"`
+	hidden   string
+}
+
+func TestSchemaOf(t *testing.T) {
+	s := SchemaOf(outer{})
+
+	expected := map[string]string{
+		"id":       "integer",
+		"name":     "string",
+		"tags":     "array",
+		"counts":   "object",
+		"when":     "string",
+		"Plain":    "boolean",
+		"child":    "object",
+		"anything": "",
+	}
+
+	if len(s.Properties) != len(expected) {
+		t.Errorf("expected %d properties but got %d", len(expected), len(s.Properties))
+	}
+
+	for name, typ := range expected {
+		p, ok := s.Properties[name]
+		if !ok {
+			t.Errorf("property %s is missing", name)
+			continue
+		}
+		if p.Type != typ {
+			t.Errorf("expected %s to be %q but got %q", name, typ, p.Type)
+		}
+	}
+
+	if s.Properties["tags"].Items.Type != "string" || s.Properties["when"].Format != "date-time" {
+		t.Error("array items or time format are not described")
+	}
+
+	if s.Properties["child"].Properties["id"] == nil {
+		t.Error("pointer to struct is not described")
+	}
+}
+
+func TestDocument(t *testing.T) {
+	doc := New("Bookings", "1", "")
+	doc.Add("GET", "/rooms/{id}", Operation{Summary: "A room", Responses: map[string]Response{"200": {Description: "the room"}}})
+
+	if !doc.Has("get", "/rooms/{id}") || doc.Has("POST", "/rooms/{id}") || doc.Has("GET", "/rooms") {
+		t.Error("Has doesn't match the added operations")
+	}
+
+	out, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var back map[string]interface{}
+	if err := json.Unmarshal(out, &back); err != nil || back["openapi"] != Version {
+		t.Errorf("unexpected document %s", out)
+	}
+}
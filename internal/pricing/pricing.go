@@ -0,0 +1,93 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/amiranbari/bookings/pkg/models"
+)
+
+// ErrInvalidStay is returned when a stay ends before it starts
+var ErrInvalidStay = errors.New("departure can't be before arrival")
+
+// MinimumStayError is returned when a stay is shorter than the room or season allows
+type MinimumStayError struct {
+	Nights int
+}
+
+func (e *MinimumStayError) Error() string {
+	return fmt.Sprintf("a minimum stay of %d nights is required for these dates", e.Nights)
+}
+
+// Night is the price of a single night of a stay
+type Night struct {
+	Date    time.Time
+	Rate    int // in cents
+	Season  bool
+	Weekend bool
+}
+
+// Quote is the priced breakdown of a stay
+type Quote struct {
+	Nights []Night
+	Total  int // in cents
+}
+
+// NewQuote prices a stay in room from start to end. Each night uses the seasonal rate covering it,
+// falling back to the room's nightly rate, and Friday and Saturday nights get the room's weekend
+// uplift. A stay arriving and leaving on the same day is charged as one night.
+func NewQuote(room models.Room, rates []models.RoomRate, start, end time.Time) (Quote, error) {
+	var q Quote
+
+	start = truncate(start)
+	end = truncate(end)
+
+	if end.Before(start) {
+		return q, ErrInvalidStay
+	}
+
+	if end.Equal(start) {
+		end = start.AddDate(0, 0, 1)
+	}
+
+	minStay := room.MinStay
+
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		night := Night{
+			Date: d,
+			Rate: room.NightlyRate,
+		}
+
+		for _, rate := range rates {
+			if d.Before(truncate(rate.StartDate)) || d.After(truncate(rate.EndDate)) {
+				continue
+			}
+			night.Rate = rate.NightlyRate
+			night.Season = true
+			if rate.MinStay > minStay {
+				minStay = rate.MinStay
+			}
+			break
+		}
+
+		if d.Weekday() == time.Friday || d.Weekday() == time.Saturday {
+			night.Weekend = true
+			night.Rate += night.Rate * room.WeekendUplift / 100
+		}
+
+		q.Nights = append(q.Nights, night)
+		q.Total += night.Rate
+	}
+
+	if len(q.Nights) < minStay {
+		return q, &MinimumStayError{Nights: minStay}
+	}
+
+	return q, nil
+}
+
+// truncate drops the time of day from t
+func truncate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
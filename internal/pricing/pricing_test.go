@@ -0,0 +1,80 @@
+package pricing
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/amiranbari/bookings/pkg/models"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+var room = models.Room{
+	ID:            1,
+	NightlyRate:   10000,
+	WeekendUplift: 20,
+	MinStay:       1,
+}
+
+var rates = []models.RoomRate{
+	{
+		RoomID:      1,
+		StartDate:   date("2050-07-01"),
+		EndDate:     date("2050-07-31"),
+		NightlyRate: 15000,
+		MinStay:     3,
+	},
+}
+
+var quoteTests = []struct {
+	name     string
+	start    string
+	end      string
+	nights   int
+	total    int
+	minStay  int
+	hasError bool
+}{
+	// 2050-01-03 is a Monday
+	{"weekdays", "2050-01-03", "2050-01-05", 2, 20000, 0, false},
+	{"same-day", "2050-01-03", "2050-01-03", 1, 10000, 0, false},
+	{"over-weekend", "2050-01-06", "2050-01-09", 3, 10000 + 12000 + 12000, 0, false},
+	{"season", "2050-07-04", "2050-07-07", 3, 45000, 0, false},
+	{"season-min-stay", "2050-07-04", "2050-07-05", 1, 15000, 3, true},
+	{"into-season", "2050-06-29", "2050-07-02", 3, 10000 + 10000 + 18000, 3, false},
+	{"backwards", "2050-01-05", "2050-01-03", 0, 0, 0, true},
+}
+
+func TestNewQuote(t *testing.T) {
+	for _, e := range quoteTests {
+		q, err := NewQuote(room, rates, date(e.start), date(e.end))
+
+		if e.hasError {
+			if err == nil {
+				t.Errorf("%s: expected an error but got none", e.name)
+			}
+			var minErr *MinimumStayError
+			if e.minStay > 0 && (!errors.As(err, &minErr) || minErr.Nights != e.minStay) {
+				t.Errorf("%s: expected a minimum stay of %d, got %v", e.name, e.minStay, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+			continue
+		}
+
+		if len(q.Nights) != e.nights {
+			t.Errorf("%s: expected %d nights but got %d", e.name, e.nights, len(q.Nights))
+		}
+
+		if q.Total != e.total {
+			t.Errorf("%s: expected total %d but got %d", e.name, e.total, q.Total)
+		}
+	}
+}
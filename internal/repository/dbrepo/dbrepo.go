@@ -1,14 +1,32 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
 	"github.com/amiranbari/bookings/internal/repository"
 	"github.com/amiranbari/bookings/pkg/config"
+	"time"
 )
 
+// defaultQueryTimeout is used when AppConfig.DBTimeout is not set
+const defaultQueryTimeout = 3 * time.Second
+
 type PostgresDBRepo struct {
 	App *config.AppConfig
 	DB  *sql.DB
+	tx  *sql.Tx
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
 }
 
 type testDBRepo struct {
@@ -28,3 +46,11 @@ func NewTestingRepo(a *config.AppConfig) repository.DatabaseRepo {
 		App: a,
 	}
 }
+
+// queryTimeout returns how long a single query may run before it is cancelled
+func (m *PostgresDBRepo) queryTimeout() time.Duration {
+	if m.App != nil && m.App.DBTimeout > 0 {
+		return m.App.DBTimeout
+	}
+	return defaultQueryTimeout
+}
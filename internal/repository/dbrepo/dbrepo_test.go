@@ -0,0 +1,165 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amiranbari/bookings/internal/repository"
+	"github.com/amiranbari/bookings/pkg/config"
+)
+
+// slowDriver opens connections whose queries only return once their context is done, like a query stuck
+// behind a lock
+type slowDriver struct{}
+
+func (slowDriver) Open(name string) (driver.Conn, error) {
+	return slowConn{}, nil
+}
+
+type slowConn struct{}
+
+func (slowConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (slowConn) Close() error {
+	return nil
+}
+
+func (slowConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+func (slowConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// recordingDriver opens connections that run nothing but remember the statements they were sent
+type recordingDriver struct {
+	statements *[]string
+}
+
+func (d recordingDriver) Open(name string) (driver.Conn, error) {
+	return recordingConn(d), nil
+}
+
+type recordingConn struct {
+	statements *[]string
+}
+
+func (c recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c recordingConn) Close() error {
+	return nil
+}
+
+func (c recordingConn) Begin() (driver.Tx, error) {
+	*c.statements = append(*c.statements, "begin")
+	return recordingTx(c), nil
+}
+
+func (c recordingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	*c.statements = append(*c.statements, query)
+	return driver.RowsAffected(0), nil
+}
+
+type recordingTx struct {
+	statements *[]string
+}
+
+func (t recordingTx) Commit() error {
+	*t.statements = append(*t.statements, "commit")
+	return nil
+}
+
+func (t recordingTx) Rollback() error {
+	*t.statements = append(*t.statements, "rollback")
+	return nil
+}
+
+var statements []string
+
+func init() {
+	sql.Register("slow", slowDriver{})
+	sql.Register("recording", recordingDriver{&statements})
+}
+
+func TestQueryTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		app      *config.AppConfig
+		expected time.Duration
+	}{
+		{"no-config", nil, defaultQueryTimeout},
+		{"not-set", &config.AppConfig{}, defaultQueryTimeout},
+		{"configured", &config.AppConfig{DBTimeout: 5 * time.Second}, 5 * time.Second},
+	}
+
+	for _, e := range tests {
+		m := &PostgresDBRepo{App: e.app}
+		if timeout := m.queryTimeout(); timeout != e.expected {
+			t.Errorf("%s: expected %s but got %s", e.name, e.expected, timeout)
+		}
+	}
+}
+
+func TestQueriesStopAtTimeout(t *testing.T) {
+	conn, err := sql.Open("slow", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	repo := NewPostgresRepo(conn, &config.AppConfig{DBTimeout: 20 * time.Millisecond})
+
+	start := time.Now()
+	_, err = repo.GetRoomById(context.Background(), 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the query to hit the deadline but got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > defaultQueryTimeout {
+		t.Errorf("expected the configured timeout to stop the query but it ran for %s", elapsed)
+	}
+}
+
+func TestNestedTransactionsUseSavepoints(t *testing.T) {
+	conn, err := sql.Open("recording", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	statements = nil
+	repo := NewPostgresRepo(conn, nil)
+
+	err = repo.WithTx(context.Background(), func(repo repository.DatabaseRepo) error {
+		// the outer transaction goes on after the failed one and after the one that worked
+		err := repo.WithTx(context.Background(), func(repo repository.DatabaseRepo) error {
+			return repository.ErrRoomNotAvailable
+		})
+		if !errors.Is(err, repository.ErrRoomNotAvailable) {
+			t.Errorf("expected the nested error but got %v", err)
+		}
+
+		return repo.WithTx(context.Background(), func(repo repository.DatabaseRepo) error {
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "begin; savepoint nested; rollback to savepoint nested; savepoint nested; release savepoint nested; commit"
+	if got := strings.Join(statements, "; "); got != expected {
+		t.Errorf("expected %s but got %s", expected, got)
+	}
+}
@@ -2,50 +2,267 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"github.com/amiranbari/bookings/internal/repository"
 	"github.com/amiranbari/bookings/pkg/models"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgtype"
 	"golang.org/x/crypto/bcrypt"
+	"strings"
 	"time"
 )
 
-func (m *PostgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
+// exclusionViolation is the postgres error code raised by the room_restrictions_no_overlap constraint
+const exclusionViolation = "23P01"
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// conn returns the transaction the repository is bound to, or the connection pool otherwise
+func (m *PostgresDBRepo) conn() queryer {
+	if m.tx != nil {
+		return m.tx
+	}
+	return m.DB
+}
+
+// WithTx runs fn inside a transaction. Nested calls run in a savepoint of the outer transaction, so when
+// they fail only their own work is undone and the outer transaction can carry on.
+func (m *PostgresDBRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	return m.withTx(ctx, func(tx *PostgresDBRepo) error {
+		return fn(tx)
+	})
+}
+
+func (m *PostgresDBRepo) withTx(ctx context.Context, fn func(tx *PostgresDBRepo) error) error {
+	if m.tx != nil {
+		return m.savepoint(ctx, fn)
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = fn(&PostgresDBRepo{
+		App: m.App,
+		DB:  m.DB,
+		tx:  tx,
+	})
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// savepoint runs fn in a savepoint of the transaction m is bound to. Postgres refuses every statement of a
+// transaction after one failed, like an insert hitting room_restrictions_no_overlap, unless it is rolled back
+// to a savepoint taken before.
+func (m *PostgresDBRepo) savepoint(ctx context.Context, fn func(tx *PostgresDBRepo) error) error {
+	_, err := m.tx.ExecContext(ctx, "savepoint nested")
+	if err != nil {
+		return err
+	}
+
+	err = fn(m)
+	if err != nil {
+		_, _ = m.tx.ExecContext(ctx, "rollback to savepoint nested")
+		return err
+	}
+
+	_, err = m.tx.ExecContext(ctx, "release savepoint nested")
+	return err
+}
+
+// InsertReservation stores a reservation together with its room restriction in a single transaction,
+// returning repository.ErrRoomNotAvailable if the room was taken in the meantime
+func (m *PostgresDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
 	var newId int
 
-	stmt := `INSERT INTO reservation (first_name, last_name, email, phone, start_date, end_date, room_id, created_at ,updated_at)
+	err := m.withTx(ctx, func(tx *PostgresDBRepo) error {
+		err := tx.lockRoom(ctx, res.RoomId, res.StartDate, res.EndDate, 0, false)
+		if err != nil {
+			return err
+		}
+
+		stmt := `INSERT INTO reservation (first_name, last_name, email, phone, start_date, end_date, room_id, amount, 
+	       confirmation_code, created_at ,updated_at)
 	       VALUES
-	       ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+	       ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) returning id`
+
+		err = tx.conn().QueryRowContext(ctx, stmt,
+			res.FirstName,
+			res.LastName,
+			res.Email,
+			res.Phone,
+			res.StartDate,
+			res.EndDate,
+			res.RoomId,
+			res.Amount,
+			res.ConfirmationCode,
+			time.Now(),
+			time.Now(),
+		).Scan(&newId)
 
-	err := m.DB.QueryRowContext(ctx, stmt,
-		res.FirstName,
-		res.LastName,
-		res.Email,
-		res.Phone,
-		res.StartDate,
-		res.EndDate,
-		res.RoomId,
-		time.Now(),
-		time.Now(),
-	).Scan(&newId)
+		if err != nil {
+			return err
+		}
+
+		restriction, err := tx.GetRestrictionByCode(ctx, models.RestrictionReservation)
+		if err != nil {
+			return err
+		}
+
+		return tx.InsertRoomRestriction(ctx, models.RoomRestriction{
+			RoomId:        res.RoomId,
+			ReservationId: newId,
+			RestrictionId: restriction.ID,
+			StartDate:     res.StartDate,
+			EndDate:       res.EndDate,
+		})
+	})
 
 	if err != nil {
-		return 0, err
+		return 0, overlapError(err)
+	}
+
+	return newId, nil
+}
+
+// ImportReservation stores a reservation brought over from another system with its status, amount and
+// confirmation code. Unless it was cancelled it takes its room like InsertReservation does, returning
+// repository.ErrRoomNotAvailable when the dates overlap a restriction, archived rooms are allowed so past
+// stays can be loaded.
+func (m *PostgresDBRepo) ImportReservation(ctx context.Context, res models.Reservation) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var newId int
+	holdsRoom := res.Status != models.ReservationCancelled
+
+	err := m.withTx(ctx, func(tx *PostgresDBRepo) error {
+		// archived rooms are allowed so past stays can be loaded
+		if holdsRoom {
+			if err := tx.lockRoom(ctx, res.RoomId, res.StartDate, res.EndDate, 0, true); err != nil {
+				return err
+			}
+		}
+
+		stmt := `INSERT INTO reservation (first_name, last_name, email, phone, start_date, end_date, room_id, amount, 
+			confirmation_code, status, created_at, updated_at)
+			VALUES
+			($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) returning id`
+
+		err := tx.conn().QueryRowContext(ctx, stmt,
+			res.FirstName,
+			res.LastName,
+			res.Email,
+			res.Phone,
+			res.StartDate,
+			res.EndDate,
+			res.RoomId,
+			res.Amount,
+			res.ConfirmationCode,
+			res.Status,
+			time.Now(),
+			time.Now(),
+		).Scan(&newId)
+		if err != nil {
+			return err
+		}
+
+		if !holdsRoom {
+			return nil
+		}
+
+		restriction, err := tx.GetRestrictionByCode(ctx, models.RestrictionReservation)
+		if err != nil {
+			return err
+		}
+
+		return tx.InsertRoomRestriction(ctx, models.RoomRestriction{
+			RoomId:        res.RoomId,
+			ReservationId: newId,
+			RestrictionId: restriction.ID,
+			StartDate:     res.StartDate,
+			EndDate:       res.EndDate,
+		})
+	})
+
+	if err != nil {
+		return 0, overlapError(err)
 	}
+
 	return newId, nil
 }
 
-func (m *PostgresDBRepo) InsertRoomRestriction(res models.RoomRestriction) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// lockRoom locks a room so concurrent bookings for it are serialized until the transaction ends, returning
+// repository.ErrRoomNotAvailable if anything blocking takes it from start through end. The restriction of
+// reservation ignoreID doesn't count, so a reservation can move over its own days. Archived rooms are
+// unavailable unless withArchived.
+func (m *PostgresDBRepo) lockRoom(ctx context.Context, roomID int, start, end time.Time, ignoreID int, withArchived bool) error {
+	query := "select id from rooms where id = $1 and archived_at is null for update"
+	if withArchived {
+		query = "select id from rooms where id = $1 for update"
+	}
+
+	var id int
+	err := m.conn().QueryRowContext(ctx, query, roomID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return repository.ErrRoomNotAvailable
+	} else if err != nil {
+		return err
+	}
+
+	var numRows int
+
+	query = `
+		select 
+			count(id)
+		from
+			room_restrictions
+		where 
+		    room_id = $1
+		    and blocks_availability
+		    and (reservation_id is null or reservation_id <> $4)
+		    and
+			$2 <= end_date and $3 >= start_date`
+
+	err = m.conn().QueryRowContext(ctx, query, roomID, start, end, ignoreID).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+
+	if numRows > 0 {
+		return repository.ErrRoomNotAvailable
+	}
+
+	return nil
+}
+
+// overlapError translates an exclusion constraint violation on room_restrictions to repository.ErrRoomNotAvailable
+func overlapError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == exclusionViolation {
+		return repository.ErrRoomNotAvailable
+	}
+	return err
+}
+
+func (m *PostgresDBRepo) InsertRoomRestriction(ctx context.Context, res models.RoomRestriction) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
-	stmt := `INSERT INTO room_restrictions (room_id, reservation_id, restriction_id, start_date, end_date, created_at ,updated_at)
+	stmt := `INSERT INTO room_restrictions (room_id, reservation_id, restriction_id, start_date, end_date, 
+	       blocks_availability, created_at ,updated_at)
 	       VALUES
-	       ($1, $2, $3, $4, $5, $6, $7)`
+	       ($1, $2, $3, $4, $5, (select blocks_availability from restrictions where id = $3), $6, $7)`
 
-	_, err := m.DB.ExecContext(ctx, stmt,
+	_, err := m.conn().ExecContext(ctx, stmt,
 		res.RoomId,
 		res.ReservationId,
 		res.RestrictionId,
@@ -56,14 +273,14 @@ func (m *PostgresDBRepo) InsertRoomRestriction(res models.RoomRestriction) error
 	)
 
 	if err != nil {
-		return err
+		return overlapError(err)
 	}
 	return nil
 }
 
-func (m *PostgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
+func (m *PostgresDBRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
 	query := `
@@ -73,12 +290,13 @@ func (m *PostgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time,
 				room_restrictions
 			where 
 			    room_id = $1
+			    and blocks_availability
 			    and
-				$2 < end_date and $3 > start_date`
+				$2 <= end_date and $3 >= start_date`
 
 	var numRows int
 
-	row := m.DB.QueryRowContext(ctx, query, roomID, start, end)
+	row := m.conn().QueryRowContext(ctx, query, roomID, start, end)
 	err := row.Scan(&numRows)
 
 	if err != nil {
@@ -92,30 +310,40 @@ func (m *PostgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time,
 	return false, nil
 }
 
-func (m *PostgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+// SearchAvailabilityForAllRooms returns the rooms free between start and end that sleep at least
+// guests people and offer all of the given amenities
+func (m *PostgresDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time, guests int, amenities []string) ([]models.Room, error) {
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
 	var rooms []models.Room
 
+	var wanted pgtype.TextArray
+	if err := wanted.Set(append([]string{}, amenities...)); err != nil {
+		return rooms, err
+	}
+
 	query := `
-				select r.id, r.title 
+				select ` + roomColumns + ` 
 			from 
 				rooms r
-				where r.id not in 
-				(select rr.room_id from room_restrictions rr where $1 <= end_date and $2 >= start_date) 
+				where r.archived_at is null 
+				and r.max_occupancy >= $3 
+				and r.amenities @> $4
+				and r.id not in 
+				(select rr.room_id from room_restrictions rr where rr.blocks_availability and $1 <= end_date and $2 >= start_date) 
+			order by r.nightly_rate, r.title
 			`
 
-	rows, err := m.DB.QueryContext(ctx, query, start, end)
+	rows, err := m.conn().QueryContext(ctx, query, start, end, guests, wanted)
 
 	if err != nil {
 		return rooms, err
 	}
 
 	for rows.Next() {
-		var room models.Room
-		err := rows.Scan(&room.ID, &room.Title)
+		room, err := scanRoom(rows)
 		if err != nil {
 			return rooms, err
 		}
@@ -129,16 +357,34 @@ func (m *PostgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 	return rooms, nil
 }
 
-func (m *PostgresDBRepo) GetRoomById(id int) (models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+// roomColumns lists the rooms columns read by scanRoom, the table must be aliased as r
+const roomColumns = `r.id, r.title, r.description, r.max_occupancy, r.amenities, r.nightly_rate, 
+				r.weekend_uplift, r.min_stay, r.archived_at is not null, coalesce(r.calendar_token, ''), r.created_at, r.updated_at`
 
+// scanRoom reads a row selected with roomColumns
+func scanRoom(row rowScanner) (models.Room, error) {
 	var room models.Room
+	var amenities pgtype.TextArray
 
-	query := `select id, title, created_at, updated_at from rooms where id = $1`
+	err := row.Scan(
+		&room.ID,
+		&room.Title,
+		&room.Description,
+		&room.MaxOccupancy,
+		&amenities,
+		&room.NightlyRate,
+		&room.WeekendUplift,
+		&room.MinStay,
+		&room.Archived,
+		&room.CalendarToken,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+	)
+	if err != nil {
+		return room, err
+	}
 
-	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&room.ID, &room.Title, &room.CreatedAt, &room.UpdatedAt)
+	err = amenities.AssignTo(&room.Amenities)
 	if err != nil {
 		return room, err
 	}
@@ -146,366 +392,1603 @@ func (m *PostgresDBRepo) GetRoomById(id int) (models.Room, error) {
 	return room, nil
 }
 
-func (m *PostgresDBRepo) GetUserByID(id int) (models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *PostgresDBRepo) GetRoomById(ctx context.Context, id int) (models.Room, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
-	var user models.User
-
-	query := `select * from users where id = $1`
-
-	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&user.ID, user.FirstName, user.LastName, user.Email, user.Password, user.AccessLevel, user.CreatedAt, user.UpdatedAt)
-	if err != nil {
-		return user, err
-	}
+	query := `select ` + roomColumns + ` from rooms r where r.id = $1`
 
-	return user, nil
+	return scanRoom(m.conn().QueryRowContext(ctx, query, id))
 }
 
-func (m *PostgresDBRepo) Authenticate(email, password string) (int, string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// AllAmenities returns the distinct amenity tags of rooms in service
+func (m *PostgresDBRepo) AllAmenities(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
-	var id int
-	var hashedPassword string
+	var amenities []string
 
-	row := m.DB.QueryRowContext(ctx, "select id, password from users where email = $1", email)
-	err := row.Scan(&id, &hashedPassword)
+	query := `select distinct unnest(amenities) as amenity from rooms where archived_at is null order by amenity`
+
+	rows, err := m.conn().QueryContext(ctx, query)
 	if err != nil {
-		return 0, "", err
+		return amenities, err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-
-	if err == bcrypt.ErrMismatchedHashAndPassword {
-		return 0, "", errors.New("incorrect password")
-	} else if err != nil {
-		return 0, "", err
+	for rows.Next() {
+		var amenity string
+		if err = rows.Scan(&amenity); err != nil {
+			return amenities, err
+		}
+		amenities = append(amenities, amenity)
 	}
 
-	return id, hashedPassword, nil
+	if err = rows.Err(); err != nil {
+		return amenities, err
+	}
 
+	return amenities, nil
 }
 
-func (m *PostgresDBRepo) AllReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *PostgresDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
-	var reservations []models.Reservation
-
-	query := `
-				select r.id, r.first_name, r.last_name,
-				       r.email, r.phone, r.start_date, r.end_date, r.created_at, r.updated_at, r.processed, rm.title 
-				from reservation r
-				left join rooms rm
-				on rm.id = r.room_id
-				order by r.id, r.start_date desc, r.processed ASC 
-				`
-
-	rows, err := m.DB.QueryContext(ctx, query)
-	if err != nil {
-		return reservations, err
-	}
-
-	for rows.Next() {
-		var i models.Reservation
-		err = rows.Scan(
-			&i.ID,
-			&i.FirstName,
-			&i.LastName,
-			&i.Email,
-			&i.Phone,
-			&i.StartDate,
-			&i.EndDate,
-			&i.CreatedAt,
-			&i.UpdatedAt,
-			&i.Processed,
-			&i.Room.Title,
-		)
-
-		if err != nil {
-			return reservations, err
-		}
+	query := `select ` + userColumns + ` from users where id = $1`
 
-		reservations = append(reservations, i)
-	}
+	return scanUser(m.conn().QueryRowContext(ctx, query, id))
+}
 
-	if err = rows.Err(); err != nil {
-		return reservations, err
-	}
+// GetUserByEmail returns the user with email, ignoring case
+func (m *PostgresDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
 
-	return reservations, nil
+	query := `select ` + userColumns + ` from users where lower(email) = lower($1)`
 
+	return scanUser(m.conn().QueryRowContext(ctx, query, email))
 }
 
-func (m *PostgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *PostgresDBRepo) AllUsers(ctx context.Context) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
-	var reservations []models.Reservation
+	var users []models.User
 
-	query := `
-				select r.id, r.first_name, r.last_name,
-				       r.email, r.phone, r.start_date, r.end_date, r.created_at, r.updated_at, r.processed, rm.title 
-				from reservation r
-				left join rooms rm
-				on rm.id = r.room_id
-				where processed = 0
-				order by r.start_date desc 
-				`
+	query := `select ` + userColumns + ` from users order by last_name, first_name`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.conn().QueryContext(ctx, query)
 	if err != nil {
-		return reservations, err
+		return users, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		var i models.Reservation
-		err = rows.Scan(
-			&i.ID,
-			&i.FirstName,
-			&i.LastName,
-			&i.Email,
-			&i.Phone,
-			&i.StartDate,
-			&i.EndDate,
-			&i.CreatedAt,
-			&i.UpdatedAt,
-			&i.Processed,
-			&i.Room.Title,
-		)
-
+		u, err := scanUser(rows)
 		if err != nil {
-			return reservations, err
+			return users, err
 		}
-
-		reservations = append(reservations, i)
+		users = append(users, u)
 	}
 
 	if err = rows.Err(); err != nil {
-		return reservations, err
+		return users, err
 	}
 
-	return reservations, nil
-
+	return users, nil
 }
 
-func (m *PostgresDBRepo) GetReservationByID(id int) (models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// InsertUser stores a new user, hashing the plain text password in u.Password
+func (m *PostgresDBRepo) InsertUser(ctx context.Context, u models.User) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
-	var reservation models.Reservation
-
-	query := `
-				select r.id, r.first_name, r.last_name,
-				       r.email, r.phone, r.start_date, r.end_date, r.created_at, r.updated_at, r.processed, rm.title 
-				from reservation r
-				left join rooms rm
-				on rm.id = r.room_id
-				where r.id = $1
-				order by r.start_date desc 
-				`
-
-	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(
-		&reservation.ID,
-		&reservation.FirstName,
-		&reservation.LastName,
-		&reservation.Email,
-		&reservation.Phone,
-		&reservation.StartDate,
-		&reservation.EndDate,
-		&reservation.CreatedAt,
-		&reservation.UpdatedAt,
-		&reservation.Processed,
-		&reservation.Room.Title,
-	)
-
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
 	if err != nil {
-		return reservation, err
+		return 0, err
 	}
 
-	return reservation, nil
-
-}
-
-func (m *PostgresDBRepo) UpdateReservation(r models.Reservation) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	var newId int
 
-	query := `
-				update reservation set first_name = $1, last_name = $2, email = $3, phone = $4, updated_at = $5 
-				where id = $6
-				`
+	stmt := `insert into users (first_name, last_name, email, password, access_level, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7) returning id`
 
-	_, err := m.DB.ExecContext(ctx, query,
-		r.FirstName,
-		r.LastName,
-		r.Email,
-		r.Phone,
+	err = m.conn().QueryRowContext(ctx, stmt,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		string(hashedPassword),
+		u.AccessLevel,
 		time.Now(),
-		r.ID,
-	)
+		time.Now(),
+	).Scan(&newId)
 
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
-
+	return newId, nil
 }
 
-func (m *PostgresDBRepo) DeleteReservation(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// UpdatePassword hashes password and stores it for the user
+func (m *PostgresDBRepo) UpdatePassword(ctx context.Context, id int, password string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
-	query := "delete from reservation where id = $1"
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
 
-	_, err := m.DB.ExecContext(ctx, query, id)
+	query := "update users set password = $1, updated_at = $2 where id = $3"
 
+	_, err = m.conn().ExecContext(ctx, query, string(hashedPassword), time.Now(), id)
 	if err != nil {
 		return err
 	}
 
 	return nil
-
 }
 
-func (m *PostgresDBRepo) UpdateProcessedForReservation(id, processed int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// EnableTOTP stores the verified secret of a user and replaces their recovery codes with codeHashes
+func (m *PostgresDBRepo) EnableTOTP(ctx context.Context, userID int, secret string, codeHashes []string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
-	query := "update reservation set processed = $1 where id = $2"
+	return m.withTx(ctx, func(tx *PostgresDBRepo) error {
+		query := "update users set totp_secret = $1, totp_enabled_at = $2, updated_at = $2 where id = $3"
 
-	_, err := m.DB.ExecContext(ctx, query, processed, id)
+		_, err := tx.conn().ExecContext(ctx, query, secret, time.Now(), userID)
+		if err != nil {
+			return err
+		}
 
-	if err != nil {
-		return err
-	}
+		_, err = tx.conn().ExecContext(ctx, "delete from recovery_codes where user_id = $1", userID)
+		if err != nil {
+			return err
+		}
 
-	return nil
+		stmt := `insert into recovery_codes (user_id, code_hash, created_at, updated_at)
+				values ($1, $2, $3, $4)`
+
+		for _, hash := range codeHashes {
+			_, err = tx.conn().ExecContext(ctx, stmt, userID, hash, time.Now(), time.Now())
+			if err != nil {
+				return err
+			}
+		}
 
+		return nil
+	})
 }
 
-func (m *PostgresDBRepo) AllRooms() ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// DisableTOTP removes the secret and recovery codes of a user
+func (m *PostgresDBRepo) DisableTOTP(ctx context.Context, userID int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
-	var rooms []models.Room
+	return m.withTx(ctx, func(tx *PostgresDBRepo) error {
+		query := "update users set totp_secret = '', totp_enabled_at = null, updated_at = $1 where id = $2"
 
-	query := `
-				select * from rooms order by created_at desc 
-				`
+		_, err := tx.conn().ExecContext(ctx, query, time.Now(), userID)
+		if err != nil {
+			return err
+		}
 
-	rows, err := m.DB.QueryContext(ctx, query)
-	if err != nil {
-		return rooms, err
-	}
+		_, err = tx.conn().ExecContext(ctx, "delete from recovery_codes where user_id = $1", userID)
+		return err
+	})
+}
 
-	for rows.Next() {
-		var i models.Room
-		err = rows.Scan(
-			&i.ID,
-			&i.Title,
-			&i.CreatedAt,
-			&i.UpdatedAt,
-		)
+// UseRecoveryCode marks an unused recovery code of the user as used, reporting whether there was one
+func (m *PostgresDBRepo) UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
 
-		if err != nil {
-			return rooms, err
-		}
+	query := `update recovery_codes set used_at = $1, updated_at = $1 
+			where user_id = $2 and code_hash = $3 and used_at is null`
 
-		rooms = append(rooms, i)
+	result, err := m.conn().ExecContext(ctx, query, time.Now(), userID, codeHash)
+	if err != nil {
+		return false, err
 	}
 
-	if err = rows.Err(); err != nil {
-		return rooms, err
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
 	}
 
-	return rooms, nil
-
+	return n > 0, nil
 }
 
-func (m *PostgresDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// RemainingRecoveryCodes counts the recovery codes of a user that haven't been used yet
+func (m *PostgresDBRepo) RemainingRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
-	var restriction []models.RoomRestriction
+	var count int
 
-	query := `select id, room_id, coalesce (reservation_id, 0), restriction_id, start_date, end_date, created_at, updated_at 
-				from room_restrictions
-				where room_id = $1 and $2 <= end_date and $3 >= start_date`
+	query := "select count(id) from recovery_codes where user_id = $1 and used_at is null"
 
-	rows, err := m.DB.QueryContext(ctx, query, roomID, start, end)
+	err := m.conn().QueryRowContext(ctx, query, userID).Scan(&count)
 	if err != nil {
-		return restriction, err
+		return 0, err
 	}
 
-	for rows.Next() {
-		var i models.RoomRestriction
-		err = rows.Scan(
-			&i.ID,
-			&i.RoomId,
-			&i.ReservationId,
-			&i.RestrictionId,
-			&i.StartDate,
-			&i.EndDate,
-			&i.CreatedAt,
-			&i.UpdatedAt,
-		)
+	return count, nil
+}
+
+// userColumns lists the user columns read by scanUser
+const userColumns = `id, first_name, last_name, email, password, access_level, totp_secret, 
+				totp_enabled_at is not null, created_at, updated_at`
+
+// scanUser reads a row selected with userColumns
+func scanUser(row rowScanner) (models.User, error) {
+	var user models.User
+	err := row.Scan(
+		&user.ID,
+		&user.FirstName,
+		&user.LastName,
+		&user.Email,
+		&user.Password,
+		&user.AccessLevel,
+		&user.TOTPSecret,
+		&user.TOTPEnabled,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	return user, err
+}
+
+func (m *PostgresDBRepo) Authenticate(ctx context.Context, email, password string) (int, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var id int
+	var hashedPassword string
+
+	row := m.conn().QueryRowContext(ctx, "select id, password from users where lower(email) = lower($1)", email)
+	err := row.Scan(&id, &hashedPassword)
+	if err != nil {
+		return 0, "", err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return 0, "", errors.New("incorrect password")
+	} else if err != nil {
+		return 0, "", err
+	}
+
+	return id, hashedPassword, nil
+
+}
+
+// reservationSortColumns maps models.ReservationSorts to the columns they order by
+var reservationSortColumns = map[string][]string{
+	models.ReservationSortID:     {"r.id"},
+	models.ReservationSortName:   {"r.last_name", "r.first_name"},
+	models.ReservationSortStart:  {"r.start_date"},
+	models.ReservationSortEnd:    {"r.end_date"},
+	models.ReservationSortRoom:   {"rm.title"},
+	models.ReservationSortAmount: {"r.amount"},
+	models.ReservationSortStatus: {"r.status"},
+}
+
+// likeEscaper escapes the wildcards of like patterns
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SearchReservations returns a page of the reservations matching filter, searching the guest's name, email
+// and phone case insensitively, and how many reservations match in total
+func (m *PostgresDBRepo) SearchReservations(ctx context.Context, filter models.ReservationFilter) ([]models.Reservation, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	pattern := ""
+	if search := strings.TrimSpace(filter.Search); search != "" {
+		pattern = "%" + likeEscaper.Replace(search) + "%"
+	}
+
+	// null bounds don't restrict the arrival
+	var from, to interface{}
+	if !filter.From.IsZero() {
+		from = filter.From
+	}
+	if !filter.To.IsZero() {
+		to = filter.To
+	}
+
+	where := `
+				where ($1 = '' or r.status = $1)
+				and ($2 = '' or (r.first_name || ' ' || r.last_name) ilike $2 or r.email ilike $2 or r.phone ilike $2)
+				and ($3::date is null or r.start_date >= $3::date)
+				and ($4::date is null or r.start_date <= $4::date)
+				`
+	args := []interface{}{filter.Status, pattern, from, to}
+
+	var total int
+	err := m.conn().QueryRowContext(ctx, `select count(*) from reservation r `+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	columns, ok := reservationSortColumns[filter.Sort]
+	if !ok {
+		columns = reservationSortColumns[models.ReservationSortStart]
+	}
+	direction := "asc"
+	if filter.Desc {
+		direction = "desc"
+	}
+	var order []string
+	for _, column := range append(columns, "r.id") {
+		order = append(order, column+" "+direction)
+	}
+
+	// a null limit returns every row
+	var limit interface{}
+	if filter.Limit > 0 {
+		limit = filter.Limit
+	}
+
+	query := `
+				select ` + reservationColumns + ` 
+				from reservation r
+				left join rooms rm
+				on rm.id = r.room_id
+				` + where + `
+				order by ` + strings.Join(order, ", ") + `
+				limit $5 offset $6
+				`
+
+	reservations, err := m.queryReservations(ctx, query, append(args, limit, filter.Offset)...)
+	return reservations, total, err
+}
+
+// reservationColumns lists the reservation columns read by scanReservation, the reservation
+// table must be aliased as r and joined to rooms as rm
+const reservationColumns = `r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, 
+				r.created_at, r.updated_at, r.status, r.amount, r.confirmation_code, coalesce(rm.title, '')`
+
+// scanReservation reads a row selected with reservationColumns
+func scanReservation(row rowScanner) (models.Reservation, error) {
+	var i models.Reservation
+	err := row.Scan(
+		&i.ID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Email,
+		&i.Phone,
+		&i.StartDate,
+		&i.EndDate,
+		&i.RoomId,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Status,
+		&i.Amount,
+		&i.ConfirmationCode,
+		&i.Room.Title,
+	)
+	i.Room.ID = i.RoomId
+
+	return i, err
+}
+
+func (m *PostgresDBRepo) queryReservations(ctx context.Context, query string, args ...interface{}) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+
+	rows, err := m.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return reservations, err
+	}
 
+	for rows.Next() {
+		i, err := scanReservation(rows)
 		if err != nil {
-			return restriction, err
+			return reservations, err
 		}
 
-		restriction = append(restriction, i)
+		reservations = append(reservations, i)
 	}
 
 	if err = rows.Err(); err != nil {
-		return restriction, err
+		return reservations, err
 	}
 
-	return restriction, nil
+	return reservations, nil
+}
+
+func (m *PostgresDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `
+				select ` + reservationColumns + ` 
+				from reservation r
+				left join rooms rm
+				on rm.id = r.room_id
+				where r.id = $1
+				`
 
+	return scanReservation(m.conn().QueryRowContext(ctx, query, id))
 }
 
-func (m *PostgresDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// GetReservationByCode finds a reservation by its confirmation code and the guest's email
+func (m *PostgresDBRepo) GetReservationByCode(ctx context.Context, code, email string) (models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
-	stmt := `INSERT INTO room_restrictions (room_id, reservation_id, restriction_id, start_date, end_date, created_at ,updated_at)
-	       VALUES
-	       ($1, $2, $3, $4, $5, $6, $7)`
+	query := `
+				select ` + reservationColumns + ` 
+				from reservation r
+				left join rooms rm
+				on rm.id = r.room_id
+				where r.confirmation_code = $1 and lower(r.email) = lower($2)
+				`
 
-	_, err := m.DB.ExecContext(ctx, stmt,
-		id,
-		nil,
-		2,
-		startDate,
-		startDate,
+	return scanReservation(m.conn().QueryRowContext(ctx, query, strings.ToUpper(code), email))
+}
+
+func (m *PostgresDBRepo) UpdateReservation(ctx context.Context, r models.Reservation) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `
+				update reservation set first_name = $1, last_name = $2, email = $3, phone = $4, updated_at = $5 
+				where id = $6
+				`
+
+	_, err := m.conn().ExecContext(ctx, query,
+		r.FirstName,
+		r.LastName,
+		r.Email,
+		r.Phone,
+		time.Now(),
+		r.ID,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+
+}
+
+// UpdateReservationStay changes the room, dates and amount of a reservation, the caller is
+// responsible for moving its room restriction
+func (m *PostgresDBRepo) UpdateReservationStay(ctx context.Context, r models.Reservation) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `
+				update reservation set room_id = $1, start_date = $2, end_date = $3, amount = $4, updated_at = $5 
+				where id = $6
+				`
+
+	_, err := m.conn().ExecContext(ctx, query,
+		r.RoomId,
+		r.StartDate,
+		r.EndDate,
+		r.Amount,
+		time.Now(),
+		r.ID,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// MoveReservation moves a reservation and its room restriction to res.RoomId from res.StartDate through
+// res.EndDate at res.Amount in a single transaction, returning repository.ErrRoomNotAvailable if anything
+// but the reservation itself takes the room on any of those days
+func (m *PostgresDBRepo) MoveReservation(ctx context.Context, res models.Reservation) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	err := m.withTx(ctx, func(tx *PostgresDBRepo) error {
+		err := tx.lockRoom(ctx, res.RoomId, res.StartDate, res.EndDate, res.ID, false)
+		if err != nil {
+			return err
+		}
+
+		err = tx.UpdateReservationStay(ctx, res)
+		if err != nil {
+			return err
+		}
+
+		stmt := `update room_restrictions set room_id = $1, start_date = $2, end_date = $3, updated_at = $4 
+				where reservation_id = $5`
+
+		result, err := tx.conn().ExecContext(ctx, stmt, res.RoomId, res.StartDate, res.EndDate, time.Now(), res.ID)
+		if err != nil {
+			return err
+		}
+
+		// cancelled reservations no longer hold a room
+		moved, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if moved == 0 {
+			return sql.ErrNoRows
+		}
+
+		return nil
+	})
+
+	return overlapError(err)
+}
+
+func (m *PostgresDBRepo) DeleteRestrictionsForReservation(ctx context.Context, reservationID int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := "delete from room_restrictions where reservation_id = $1"
+
+	_, err := m.conn().ExecContext(ctx, query, reservationID)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+
+}
+
+func (m *PostgresDBRepo) UpdateReservationStatus(ctx context.Context, id int, status string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := "update reservation set status = $1, updated_at = $2 where id = $3"
+
+	_, err := m.conn().ExecContext(ctx, query, status, time.Now(), id)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+
+}
+
+// AllRooms returns the rooms that are still in service
+func (m *PostgresDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	return m.allRooms(ctx, false)
+}
+
+// AllRoomsWithArchived returns every room, including archived ones
+func (m *PostgresDBRepo) AllRoomsWithArchived(ctx context.Context) ([]models.Room, error) {
+	return m.allRooms(ctx, true)
+}
+
+func (m *PostgresDBRepo) allRooms(ctx context.Context, withArchived bool) ([]models.Room, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var rooms []models.Room
+
+	query := `
+				select ` + roomColumns + ` 
+				from rooms r
+				where $1 or r.archived_at is null
+				order by r.created_at desc 
+				`
+
+	rows, err := m.conn().QueryContext(ctx, query, withArchived)
+	if err != nil {
+		return rooms, err
+	}
+
+	for rows.Next() {
+		i, err := scanRoom(rows)
+		if err != nil {
+			return rooms, err
+		}
+
+		rooms = append(rooms, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+
+	return rooms, nil
+
+}
+
+func (m *PostgresDBRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var newId int
+
+	var amenities pgtype.TextArray
+	if err := amenities.Set(append([]string{}, room.Amenities...)); err != nil {
+		return 0, err
+	}
+
+	stmt := `INSERT INTO rooms (title, description, max_occupancy, amenities, nightly_rate, weekend_uplift, min_stay, 
+			created_at, updated_at) 
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+
+	err := m.conn().QueryRowContext(ctx, stmt,
+		room.Title,
+		room.Description,
+		room.MaxOccupancy,
+		amenities,
+		room.NightlyRate,
+		room.WeekendUplift,
+		room.MinStay,
+		time.Now(),
 		time.Now(),
+	).Scan(&newId)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+func (m *PostgresDBRepo) UpdateRoom(ctx context.Context, room models.Room) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var amenities pgtype.TextArray
+	if err := amenities.Set(append([]string{}, room.Amenities...)); err != nil {
+		return err
+	}
+
+	query := `update rooms set title = $1, description = $2, max_occupancy = $3, amenities = $4, nightly_rate = $5, 
+			weekend_uplift = $6, min_stay = $7, updated_at = $8 where id = $9`
+
+	_, err := m.conn().ExecContext(ctx, query,
+		room.Title,
+		room.Description,
+		room.MaxOccupancy,
+		amenities,
+		room.NightlyRate,
+		room.WeekendUplift,
+		room.MinStay,
 		time.Now(),
+		room.ID,
 	)
 
 	if err != nil {
 		return err
 	}
+
+	return nil
+}
+
+// ArchiveRoom retires a room, its past reservations are kept
+func (m *PostgresDBRepo) ArchiveRoom(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `update rooms set archived_at = $1, updated_at = $1 where id = $2 and archived_at is null`
+
+	_, err := m.conn().ExecContext(ctx, query, time.Now(), id)
+
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func (m *PostgresDBRepo) DeleteBlockByID(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// UpdateRoomCalendarToken replaces the token of a room's calendar feed, which stops the old link from working
+func (m *PostgresDBRepo) UpdateRoomCalendarToken(ctx context.Context, id int, token string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
 	defer cancel()
 
-	query := "delete from room_restrictions where id = $1"
+	query := `update rooms set calendar_token = $1, updated_at = $2 where id = $3`
 
-	_, err := m.DB.ExecContext(ctx, query, id)
+	_, err := m.conn().ExecContext(ctx, query, token, time.Now(), id)
 
 	if err != nil {
 		return err
 	}
 
 	return nil
+}
+
+// roomRestrictionQuery selects room restrictions together with their type and the guest of reservations
+const roomRestrictionQuery = `select rr.id, rr.room_id, coalesce (rr.reservation_id, 0), rr.restriction_id, rr.start_date, 
+				rr.end_date, rr.reason, rr.created_at, rr.updated_at, coalesce(res.first_name, ''), 
+				coalesce(res.last_name, ''), coalesce(res.confirmation_code, ''), ` + restrictionColumns + `
+				from room_restrictions rr
+				join restrictions t on (t.id = rr.restriction_id)
+				left join reservation res on (res.id = rr.reservation_id)`
+
+// queryRoomRestrictions runs roomRestrictionQuery followed by where
+func (m *PostgresDBRepo) queryRoomRestrictions(ctx context.Context, where string, args ...interface{}) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var restriction []models.RoomRestriction
+
+	rows, err := m.conn().QueryContext(ctx, roomRestrictionQuery+" "+where, args...)
+	if err != nil {
+		return restriction, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.RoomRestriction
+		err = rows.Scan(
+			&i.ID,
+			&i.RoomId,
+			&i.ReservationId,
+			&i.RestrictionId,
+			&i.StartDate,
+			&i.EndDate,
+			&i.Reason,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Reservation.FirstName,
+			&i.Reservation.LastName,
+			&i.Reservation.ConfirmationCode,
+			&i.Restriction.ID,
+			&i.Restriction.Code,
+			&i.Restriction.Name,
+			&i.Restriction.Colour,
+			&i.Restriction.BlocksAvailability,
+			&i.Restriction.GuestVisible,
+			&i.Restriction.CreatedAt,
+			&i.Restriction.UpdatedAt,
+		)
+
+		if err != nil {
+			return restriction, err
+		}
+
+		restriction = append(restriction, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return restriction, err
+	}
+
+	return restriction, nil
+}
+
+func (m *PostgresDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	return m.queryRoomRestrictions(ctx, `where rr.room_id = $1 and $2 <= rr.end_date and $3 >= rr.start_date
+				order by rr.start_date`, roomID, start, end)
+}
+
+// GetRestrictionsByDate returns the restrictions of every room overlapping start and end, ordered by room
+func (m *PostgresDBRepo) GetRestrictionsByDate(ctx context.Context, start, end time.Time) ([]models.RoomRestriction, error) {
+	return m.queryRoomRestrictions(ctx, `where $1 <= rr.end_date and $2 >= rr.start_date
+				order by rr.room_id, rr.start_date`, start, end)
+}
+
+// InsertBlockForRoom restricts a room from block.StartDate through block.EndDate, returning
+// repository.ErrRoomNotAvailable if the restriction blocks availability and any of those days is
+// reserved or blocked already
+func (m *PostgresDBRepo) InsertBlockForRoom(ctx context.Context, block models.RoomRestriction) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	stmt := `INSERT INTO room_restrictions (room_id, reservation_id, restriction_id, reason, start_date, end_date, 
+	       blocks_availability, created_at ,updated_at)
+	       VALUES
+	       ($1, $2, $3, $4, $5, $6, (select blocks_availability from restrictions where id = $3), $7, $8)`
+
+	_, err := m.conn().ExecContext(ctx, stmt,
+		block.RoomId,
+		nil,
+		block.RestrictionId,
+		block.Reason,
+		block.StartDate,
+		block.EndDate,
+		time.Now(),
+		time.Now(),
+	)
+
+	if err != nil {
+		return overlapError(err)
+	}
+	return nil
+}
+
+func (m *PostgresDBRepo) DeleteBlockByID(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	// restrictions of reservations go with the reservation
+	query := "delete from room_restrictions where id = $1 and reservation_id is null"
+
+	_, err := m.conn().ExecContext(ctx, query, id)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+
+}
+
+// GetRatesForRoomByDate returns the seasonal rates of a room overlapping start and end
+func (m *PostgresDBRepo) GetRatesForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRate, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `select id, room_id, start_date, end_date, nightly_rate, min_stay, created_at, updated_at 
+				from room_rates
+				where room_id = $1 and $2 <= end_date and $3 >= start_date
+				order by start_date`
+
+	return m.queryRoomRates(ctx, query, roomID, start, end)
+}
+
+// GetRatesForRoom returns every seasonal rate of a room
+func (m *PostgresDBRepo) GetRatesForRoom(ctx context.Context, roomID int) ([]models.RoomRate, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `select id, room_id, start_date, end_date, nightly_rate, min_stay, created_at, updated_at 
+				from room_rates
+				where room_id = $1
+				order by start_date`
+
+	return m.queryRoomRates(ctx, query, roomID)
+}
+
+func (m *PostgresDBRepo) queryRoomRates(ctx context.Context, query string, args ...interface{}) ([]models.RoomRate, error) {
+	var rates []models.RoomRate
+
+	rows, err := m.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return rates, err
+	}
+
+	for rows.Next() {
+		var i models.RoomRate
+		err = rows.Scan(
+			&i.ID,
+			&i.RoomID,
+			&i.StartDate,
+			&i.EndDate,
+			&i.NightlyRate,
+			&i.MinStay,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		)
+
+		if err != nil {
+			return rates, err
+		}
+
+		rates = append(rates, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rates, err
+	}
+
+	return rates, nil
+}
+
+func (m *PostgresDBRepo) InsertRoomRate(ctx context.Context, rate models.RoomRate) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	stmt := `INSERT INTO room_rates (room_id, start_date, end_date, nightly_rate, min_stay, created_at, updated_at)
+	       VALUES
+	       ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := m.conn().ExecContext(ctx, stmt,
+		rate.RoomID,
+		rate.StartDate,
+		rate.EndDate,
+		rate.NightlyRate,
+		rate.MinStay,
+		time.Now(),
+		time.Now(),
+	)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *PostgresDBRepo) DeleteRoomRate(ctx context.Context, roomID, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := "delete from room_rates where id = $1 and room_id = $2"
+
+	_, err := m.conn().ExecContext(ctx, query, id, roomID)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *PostgresDBRepo) InsertLoginAttempt(ctx context.Context, attempt models.LoginAttempt) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	stmt := `insert into login_attempts (email, ip_address, event, created_at, updated_at)
+			values ($1, $2, $3, $4, $5)`
+
+	_, err := m.conn().ExecContext(ctx, stmt,
+		strings.ToLower(attempt.Email),
+		attempt.IPAddress,
+		attempt.Event,
+		time.Now(),
+		time.Now(),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// failuresSinceReset only counts an account's failures after its last successful login or unlock
+const failuresSinceReset = `a.created_at > coalesce((
+					select max(b.created_at) from login_attempts b 
+					where b.email = a.email and b.event in ('success', 'unlock')
+				), '-infinity')`
+
+// LoginFailuresByEmail counts the failed logins for email since since, ignoring those before its last
+// successful login or unlock
+func (m *PostgresDBRepo) LoginFailuresByEmail(ctx context.Context, email string, since time.Time) (models.LoginFailures, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `
+			select count(a.id), max(a.created_at)
+			from login_attempts a
+			where a.email = $1 and a.event = 'failure' and a.created_at > $2
+			and ` + failuresSinceReset
+
+	email = strings.ToLower(email)
+	return scanLoginFailures(email, m.conn().QueryRowContext(ctx, query, email, since))
+}
+
+// LoginFailuresByIP counts the failed logins from ip since since, a successful login doesn't reset them
+// as anyone can log in to their own account
+func (m *PostgresDBRepo) LoginFailuresByIP(ctx context.Context, ip string, since time.Time) (models.LoginFailures, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `
+			select count(a.id), max(a.created_at)
+			from login_attempts a
+			where a.ip_address = $1 and a.event = 'failure' and a.created_at > $2`
+
+	return scanLoginFailures(ip, m.conn().QueryRowContext(ctx, query, ip, since))
+}
+
+func scanLoginFailures(key string, row rowScanner) (models.LoginFailures, error) {
+	f := models.LoginFailures{Key: key}
+	var last sql.NullTime
+
+	err := row.Scan(&f.Count, &last)
+	f.Last = last.Time
+
+	return f, err
+}
+
+// AllLoginFailures returns the failures of every account that failed to log in since since
+func (m *PostgresDBRepo) AllLoginFailures(ctx context.Context, since time.Time) ([]models.LoginFailures, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var failures []models.LoginFailures
+
+	query := `
+			select a.email, count(a.id), max(a.created_at)
+			from login_attempts a
+			where a.event = 'failure' and a.created_at > $1
+			and ` + failuresSinceReset + `
+			group by a.email
+			order by max(a.created_at) desc`
+
+	rows, err := m.conn().QueryContext(ctx, query, since)
+	if err != nil {
+		return failures, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var f models.LoginFailures
+		err := rows.Scan(&f.Key, &f.Count, &f.Last)
+		if err != nil {
+			return failures, err
+		}
+		failures = append(failures, f)
+	}
+
+	if err = rows.Err(); err != nil {
+		return failures, err
+	}
+
+	return failures, nil
+}
+
+// RecentLoginAttempts returns the latest limit entries of the login audit log
+func (m *PostgresDBRepo) RecentLoginAttempts(ctx context.Context, limit int) ([]models.LoginAttempt, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var attempts []models.LoginAttempt
+
+	query := `
+			select id, email, ip_address, event, created_at
+			from login_attempts
+			order by created_at desc
+			limit $1`
+
+	rows, err := m.conn().QueryContext(ctx, query, limit)
+	if err != nil {
+		return attempts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a models.LoginAttempt
+		err := rows.Scan(&a.ID, &a.Email, &a.IPAddress, &a.Event, &a.CreatedAt)
+		if err != nil {
+			return attempts, err
+		}
+		attempts = append(attempts, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return attempts, err
+	}
+
+	return attempts, nil
+}
+
+// InsertAPIKey stores a new API key, keyHash is the only way to find it again
+func (m *PostgresDBRepo) InsertAPIKey(ctx context.Context, key models.APIKey, keyHash string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var scopes pgtype.TextArray
+	if err := scopes.Set(append([]string{}, key.Scopes...)); err != nil {
+		return 0, err
+	}
+
+	var newId int
+
+	stmt := `insert into api_keys (name, prefix, key_hash, scopes, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6) returning id`
+
+	err := m.conn().QueryRowContext(ctx, stmt,
+		key.Name,
+		key.Prefix,
+		keyHash,
+		scopes,
+		time.Now(),
+		time.Now(),
+	).Scan(&newId)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+// apiKeyColumns lists the api key columns read by scanAPIKey
+const apiKeyColumns = `id, name, prefix, scopes, last_used_at, revoked_at is not null, created_at, updated_at`
+
+// scanAPIKey reads a row selected with apiKeyColumns
+func scanAPIKey(row rowScanner) (models.APIKey, error) {
+	var key models.APIKey
+	var scopes pgtype.TextArray
+	var lastUsed sql.NullTime
+
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, &scopes, &lastUsed, &key.Revoked, &key.CreatedAt, &key.UpdatedAt)
+	if err != nil {
+		return key, err
+	}
+
+	key.LastUsedAt = lastUsed.Time
+
+	err = scopes.AssignTo(&key.Scopes)
+	return key, err
+}
+
+// GetAPIKeyByHash returns the key stored as keyHash, revoked keys included
+func (m *PostgresDBRepo) GetAPIKeyByHash(ctx context.Context, keyHash string) (models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `select ` + apiKeyColumns + ` from api_keys where key_hash = $1`
+
+	return scanAPIKey(m.conn().QueryRowContext(ctx, query, keyHash))
+}
+
+// AllAPIKeys returns every key, the ones still in use first
+func (m *PostgresDBRepo) AllAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var keys []models.APIKey
+
+	query := `select ` + apiKeyColumns + ` from api_keys order by revoked_at is not null, created_at desc`
+
+	rows, err := m.conn().QueryContext(ctx, query)
+	if err != nil {
+		return keys, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return keys, err
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey stops a key from working, the record is kept to show when it was last used
+func (m *PostgresDBRepo) RevokeAPIKey(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := "update api_keys set revoked_at = $1, updated_at = $1 where id = $2 and revoked_at is null"
+
+	_, err := m.conn().ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateAPIKeyLastUsed records that a key has just been used
+func (m *PostgresDBRepo) UpdateAPIKeyLastUsed(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	_, err := m.conn().ExecContext(ctx, "update api_keys set last_used_at = $1 where id = $2", time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// calendarSourceColumns lists the columns read by scanCalendarSource, calendar_sources must be aliased as
+// cs and joined with rooms as r
+const calendarSourceColumns = `cs.id, cs.room_id, cs.name, cs.url, cs.last_synced_at, cs.last_error, cs.created_at, 
+				cs.updated_at, r.title`
+
+// scanCalendarSource reads a row selected with calendarSourceColumns
+func scanCalendarSource(row rowScanner) (models.CalendarSource, error) {
+	var source models.CalendarSource
+	var lastSynced sql.NullTime
+
+	err := row.Scan(
+		&source.ID,
+		&source.RoomID,
+		&source.Name,
+		&source.URL,
+		&lastSynced,
+		&source.LastError,
+		&source.CreatedAt,
+		&source.UpdatedAt,
+		&source.Room.Title,
+	)
+	if err != nil {
+		return source, err
+	}
+
+	source.LastSyncedAt = lastSynced.Time
+	source.Room.ID = source.RoomID
+
+	return source, nil
+}
+
+func (m *PostgresDBRepo) queryCalendarSources(ctx context.Context, query string, args ...interface{}) ([]models.CalendarSource, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var sources []models.CalendarSource
+
+	rows, err := m.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return sources, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		source, err := scanCalendarSource(rows)
+		if err != nil {
+			return sources, err
+		}
+		sources = append(sources, source)
+	}
+
+	if err = rows.Err(); err != nil {
+		return sources, err
+	}
+
+	return sources, nil
+}
+
+// AllCalendarSources returns the calendar sources of every room in service
+func (m *PostgresDBRepo) AllCalendarSources(ctx context.Context) ([]models.CalendarSource, error) {
+	query := `select ` + calendarSourceColumns + `
+			from calendar_sources cs
+			left join rooms r on (r.id = cs.room_id)
+			where r.archived_at is null
+			order by cs.id`
+
+	return m.queryCalendarSources(ctx, query)
+}
+
+// GetCalendarSourcesForRoom returns the calendar sources of a room
+func (m *PostgresDBRepo) GetCalendarSourcesForRoom(ctx context.Context, roomID int) ([]models.CalendarSource, error) {
+	query := `select ` + calendarSourceColumns + `
+			from calendar_sources cs
+			left join rooms r on (r.id = cs.room_id)
+			where cs.room_id = $1
+			order by cs.name`
+
+	return m.queryCalendarSources(ctx, query, roomID)
+}
+
+// GetCalendarSourceByID returns a calendar source of a room
+func (m *PostgresDBRepo) GetCalendarSourceByID(ctx context.Context, roomID, id int) (models.CalendarSource, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `select ` + calendarSourceColumns + `
+			from calendar_sources cs
+			left join rooms r on (r.id = cs.room_id)
+			where cs.id = $1 and cs.room_id = $2`
+
+	return scanCalendarSource(m.conn().QueryRowContext(ctx, query, id, roomID))
+}
+
+// InsertCalendarSource adds a calendar to import blocks from and returns its id
+func (m *PostgresDBRepo) InsertCalendarSource(ctx context.Context, source models.CalendarSource) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into calendar_sources (room_id, name, url, created_at, updated_at)
+			values ($1, $2, $3, $4, $5) returning id`
+
+	err := m.conn().QueryRowContext(ctx, stmt,
+		source.RoomID,
+		source.Name,
+		source.URL,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteCalendarSource removes a calendar source of a room together with the blocks imported from it
+func (m *PostgresDBRepo) DeleteCalendarSource(ctx context.Context, roomID, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `delete from calendar_sources where id = $1 and room_id = $2`
+
+	result, err := m.conn().ExecContext(ctx, query, id, roomID)
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// UpdateCalendarSourceSync records the outcome of a sync, syncErr is empty if it went fine
+func (m *PostgresDBRepo) UpdateCalendarSourceSync(ctx context.Context, id int, syncedAt time.Time, syncErr string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `update calendar_sources set last_synced_at = $1, last_error = $2, updated_at = $3 where id = $4`
+
+	_, err := m.conn().ExecContext(ctx, query, syncedAt, syncErr, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetBlocksForCalendarSource returns the blocks imported from a calendar source that end on or after from
+func (m *PostgresDBRepo) GetBlocksForCalendarSource(ctx context.Context, sourceID int, from time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var blocks []models.RoomRestriction
+
+	query := `select id, room_id, restriction_id, calendar_source_id, external_uid, start_date, end_date, 
+				created_at, updated_at
+			from room_restrictions
+			where calendar_source_id = $1 and end_date >= $2
+			order by start_date`
+
+	rows, err := m.conn().QueryContext(ctx, query, sourceID, from)
+	if err != nil {
+		return blocks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b models.RoomRestriction
+		err = rows.Scan(
+			&b.ID,
+			&b.RoomId,
+			&b.RestrictionId,
+			&b.CalendarSourceID,
+			&b.ExternalUID,
+			&b.StartDate,
+			&b.EndDate,
+			&b.CreatedAt,
+			&b.UpdatedAt,
+		)
+		if err != nil {
+			return blocks, err
+		}
+		blocks = append(blocks, b)
+	}
+
+	if err = rows.Err(); err != nil {
+		return blocks, err
+	}
+
+	return blocks, nil
+}
+
+// InsertExternalBlock blocks a room for an event imported from a calendar source, returning
+// repository.ErrRoomNotAvailable if the room is already taken on any of its days
+func (m *PostgresDBRepo) InsertExternalBlock(ctx context.Context, r models.RoomRestriction) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	stmt := `insert into room_restrictions (room_id, restriction_id, calendar_source_id, external_uid, 
+				start_date, end_date, blocks_availability, created_at, updated_at)
+			values ($1, (select id from restrictions where code = $2), $3, $4, $5, $6, 
+				(select blocks_availability from restrictions where code = $2), $7, $8)`
+
+	_, err := m.conn().ExecContext(ctx, stmt,
+		r.RoomId,
+		models.RestrictionExternalCalendar,
+		r.CalendarSourceID,
+		r.ExternalUID,
+		r.StartDate,
+		r.EndDate,
+		time.Now(),
+		time.Now(),
+	)
+
+	if err != nil {
+		return overlapError(err)
+	}
+
+	return nil
+}
+
+// UpdateExternalBlock moves an imported block to the new dates of its event, returning
+// repository.ErrRoomNotAvailable if the room is already taken on any of them
+func (m *PostgresDBRepo) UpdateExternalBlock(ctx context.Context, id int, start, end time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `update room_restrictions set start_date = $1, end_date = $2, updated_at = $3 
+			where id = $4 and calendar_source_id is not null`
+
+	_, err := m.conn().ExecContext(ctx, query, start, end, time.Now(), id)
+	if err != nil {
+		return overlapError(err)
+	}
+
+	return nil
+}
+
+const restrictionColumns = `t.id, t.code, t.restriction_name, t.colour, t.blocks_availability, t.guest_visible, 
+				t.created_at, t.updated_at`
+
+// scanRestriction reads a row selected with restrictionColumns
+func scanRestriction(row rowScanner) (models.Restriction, error) {
+	var restriction models.Restriction
+
+	err := row.Scan(
+		&restriction.ID,
+		&restriction.Code,
+		&restriction.Name,
+		&restriction.Colour,
+		&restriction.BlocksAvailability,
+		&restriction.GuestVisible,
+		&restriction.CreatedAt,
+		&restriction.UpdatedAt,
+	)
+
+	return restriction, err
+}
+
+// AllRestrictions returns every type of restriction
+func (m *PostgresDBRepo) AllRestrictions(ctx context.Context) ([]models.Restriction, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var restrictions []models.Restriction
+
+	query := `select ` + restrictionColumns + ` from restrictions t order by t.id`
+
+	rows, err := m.conn().QueryContext(ctx, query)
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		restriction, err := scanRestriction(rows)
+		if err != nil {
+			return restrictions, err
+		}
+		restrictions = append(restrictions, restriction)
+	}
+
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+
+	return restrictions, nil
+}
+
+// GetRestrictionByID returns a type of restriction
+func (m *PostgresDBRepo) GetRestrictionByID(ctx context.Context, id int) (models.Restriction, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `select ` + restrictionColumns + ` from restrictions t where t.id = $1`
+
+	return scanRestriction(m.conn().QueryRowContext(ctx, query, id))
+}
+
+// GetRestrictionByCode returns the type of restriction with the given code
+func (m *PostgresDBRepo) GetRestrictionByCode(ctx context.Context, code string) (models.Restriction, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `select ` + restrictionColumns + ` from restrictions t where t.code = $1`
+
+	return scanRestriction(m.conn().QueryRowContext(ctx, query, code))
+}
+
+// InsertRestriction adds a type of restriction and returns its id
+func (m *PostgresDBRepo) InsertRestriction(ctx context.Context, r models.Restriction) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	stmt := `insert into restrictions (code, restriction_name, colour, blocks_availability, guest_visible, 
+				created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	var id int
+	err := m.conn().QueryRowContext(ctx, stmt,
+		r.Code,
+		r.Name,
+		r.Colour,
+		r.BlocksAvailability,
+		r.GuestVisible,
+		time.Now(),
+		time.Now(),
+	).Scan(&id)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// UpdateRestriction saves a type of restriction, when it starts blocking availability and some of its
+// room restrictions overlap others repository.ErrRoomNotAvailable is returned and nothing is changed
+func (m *PostgresDBRepo) UpdateRestriction(ctx context.Context, r models.Restriction) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	err := m.withTx(ctx, func(tx *PostgresDBRepo) error {
+		query := `update restrictions set code = $1, restriction_name = $2, colour = $3, blocks_availability = $4, 
+				guest_visible = $5, updated_at = $6 where id = $7`
+
+		_, err := tx.conn().ExecContext(ctx, query,
+			r.Code,
+			r.Name,
+			r.Colour,
+			r.BlocksAvailability,
+			r.GuestVisible,
+			time.Now(),
+			r.ID,
+		)
+		if err != nil {
+			return err
+		}
+
+		// the overlap constraint only sees the copy on each room restriction
+		query = `update room_restrictions set blocks_availability = $1 where restriction_id = $2 
+				and blocks_availability <> $1`
+
+		_, err = tx.conn().ExecContext(ctx, query, r.BlocksAvailability, r.ID)
+		return err
+	})
+
+	if err != nil {
+		return overlapError(err)
+	}
+
+	return nil
+}
+
+// DeleteRestriction removes a type of restriction, returning repository.ErrRestrictionInUse while rooms
+// are restricted with it and sql.ErrNoRows if there is no restriction with id
+func (m *PostgresDBRepo) DeleteRestriction(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	query := `delete from restrictions where id = $1
+			and not exists (select 1 from room_restrictions where restriction_id = $1)`
+
+	result, err := m.conn().ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		return nil
+	}
+
+	var exists bool
+	err = m.conn().QueryRowContext(ctx, "select exists(select 1 from restrictions where id = $1)", id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
 
+	return repository.ErrRestrictionInUse
 }
@@ -1,20 +1,53 @@
 package dbrepo
 
 import (
+	"context"
+	"database/sql"
 	"errors"
+	"github.com/amiranbari/bookings/internal/apikeys"
+	"github.com/amiranbari/bookings/internal/repository"
+	"github.com/amiranbari/bookings/internal/totp"
 	"github.com/amiranbari/bookings/pkg/models"
+	"strings"
 	"time"
 )
 
-func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
+func (m *testDBRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	//a cancelled request never starts the transaction
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return fn(m)
+}
+
+func (m *testDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	//return error if room id eq 2
+	if res.RoomId == 2 {
+		return 0, errors.New("Some error!")
+	}
+	//return unavailable for stays starting on 2060-01-01
+	if res.StartDate.Format("2006-01-02") == "2060-01-01" {
+		return 0, repository.ErrRoomNotAvailable
+	}
+	return 1, nil
+}
+
+func (m *testDBRepo) ImportReservation(ctx context.Context, res models.Reservation) (int, error) {
 	//return error if room id eq 2
 	if res.RoomId == 2 {
 		return 0, errors.New("Some error!")
 	}
+	//return unavailable for stays starting on 2060-01-01
+	if res.StartDate.Format("2006-01-02") == "2060-01-01" {
+		return 0, repository.ErrRoomNotAvailable
+	}
 	return 1, nil
 }
 
-func (m *testDBRepo) InsertRoomRestriction(res models.RoomRestriction) error {
+func (m *testDBRepo) InsertRoomRestriction(ctx context.Context, res models.RoomRestriction) error {
 	//return error if room id eq 100
 	if res.RoomId == 100 {
 		return errors.New("Some error!")
@@ -22,95 +55,555 @@ func (m *testDBRepo) InsertRoomRestriction(res models.RoomRestriction) error {
 	return nil
 }
 
-func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
-	return false, nil
+func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
+	//stays starting on 2060-01-01 are taken, see InsertReservation
+	if start.Format("2006-01-02") == "2060-01-01" {
+		return false, nil
+	}
+	return true, nil
 }
 
-func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+func (m *testDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time, guests int, amenities []string) ([]models.Room, error) {
 	var rooms []models.Room
 
+	if err := ctx.Err(); err != nil {
+		return rooms, err
+	}
+
 	if start.Format("2006-01-02") == "2040-01-01" {
 		return rooms, errors.New("Some error!")
 	}
 
 	if start.Format("2006-01-02") == "2040-02-01" {
-		rooms = append(rooms, models.Room{})
+		room := models.Room{MaxOccupancy: 2, Amenities: []string{"wifi"}}
+		if guests > room.MaxOccupancy {
+			return rooms, nil
+		}
+		for _, a := range amenities {
+			if a != "wifi" {
+				return rooms, nil
+			}
+		}
+		rooms = append(rooms, room)
 		return rooms, nil
 	}
 
 	return rooms, nil
 }
 
-func (m *testDBRepo) GetRoomById(id int) (models.Room, error) {
+func (m *testDBRepo) AllAmenities(ctx context.Context) ([]string, error) {
+	return []string{"wifi"}, nil
+}
+
+// TestCalendarToken is the token of room 1's calendar feed, room 2 has no feed
+const TestCalendarToken = "calendar-token"
+
+func (m *testDBRepo) GetRoomById(ctx context.Context, id int) (models.Room, error) {
 	var room models.Room
 	if id > 2 {
 		return room, errors.New("Some error!")
 	}
+	if id == 1 {
+		room.ID = 1
+		room.Title = "General's Quarters"
+		room.CalendarToken = TestCalendarToken
+	}
 	return room, nil
 }
 
-func (m *testDBRepo) GetUserByID(id int) (models.User, error) {
+func (m *testDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
 	var user models.User
+	//user 1 is the owner, user 2 is staff, user 3 was just invited by InsertUser, user 4 uses two-factor
+	//authentication, user 6 was stored with a mixed-case email and the rest don't exist
+	switch id {
+	case 1:
+		user = models.User{ID: 1, Email: "admin@gmail.com", Password: "owner-hash", AccessLevel: models.AccessLevelOwner}
+	case 2:
+		user = models.User{ID: 2, Email: "staff@gmail.com", Password: "staff-hash", AccessLevel: models.AccessLevelStaff}
+	case 3:
+		user = models.User{ID: 3, Email: "jane@gmail.com", Password: "invited-hash", AccessLevel: models.AccessLevelStaff}
+	case 4:
+		user = models.User{ID: 4, Email: "totp@gmail.com", Password: "totp-hash", AccessLevel: models.AccessLevelStaff,
+			TOTPSecret: TestTOTPSecret, TOTPEnabled: true}
+	case 6:
+		user = models.User{ID: 6, Email: "Mixed.Case@Gmail.com", Password: "mixed-hash", AccessLevel: models.AccessLevelStaff}
+	default:
+		return user, errors.New("user not found!")
+	}
 	return user, nil
 }
 
-func (m *testDBRepo) Authenticate(email, password string) (int, string, error) {
+func (m *testDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	switch email {
+	case "admin@gmail.com":
+		return m.GetUserByID(ctx, 1)
+	case "staff@gmail.com":
+		return m.GetUserByID(ctx, 2)
+	case "totp@gmail.com":
+		return m.GetUserByID(ctx, 4)
+	}
+	return models.User{}, errors.New("user not found!")
+}
+
+func (m *testDBRepo) AllUsers(ctx context.Context) ([]models.User, error) {
+	owner, _ := m.GetUserByID(ctx, 1)
+	staff, _ := m.GetUserByID(ctx, 2)
+	return []models.User{owner, staff}, nil
+}
+
+func (m *testDBRepo) InsertUser(ctx context.Context, u models.User) (int, error) {
+	//return error if the email is taken
+	if u.Email == "staff@gmail.com" {
+		return 0, errors.New("duplicate email!")
+	}
+	return 3, nil
+}
+
+func (m *testDBRepo) UpdatePassword(ctx context.Context, id int, password string) error {
+	return nil
+}
+
+// TestTOTPSecret is the two-factor secret of user 4, TestRecoveryCode is its only unused recovery code
+const (
+	TestTOTPSecret   = "JBSWY3DPEHPK3PXP"
+	TestRecoveryCode = "AAAAA-BBBBB"
+)
+
+func (m *testDBRepo) EnableTOTP(ctx context.Context, userID int, secret string, codeHashes []string) error {
+	return nil
+}
+
+func (m *testDBRepo) DisableTOTP(ctx context.Context, userID int) error {
+	return nil
+}
+
+func (m *testDBRepo) UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error) {
+	return userID == 4 && codeHash == totp.HashRecoveryCode(TestRecoveryCode), nil
+}
+
+func (m *testDBRepo) RemainingRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) Authenticate(ctx context.Context, email, password string) (int, string, error) {
+	//every password but "wrong" is accepted
+	if password == "wrong" {
+		return 0, "", errors.New("incorrect password")
+	}
 	if email == "admin@gmail.com" {
 		return 1, "", nil
 	}
+	if email == "staff@gmail.com" {
+		return 2, "", nil
+	}
+	if email == "totp@gmail.com" {
+		return 4, "", nil
+	}
+	//emails match whatever their case, like the stored Mixed.Case@Gmail.com
+	if strings.EqualFold(email, "Mixed.Case@Gmail.com") {
+		return 6, "", nil
+	}
 	return 0, "", errors.New("some error!")
 }
 
-func (m *testDBRepo) AllReservations() ([]models.Reservation, error) {
-	var reservations []models.Reservation
-	return reservations, nil
-}
-
-func (m *testDBRepo) AllNewReservations() ([]models.Reservation, error) {
+func (m *testDBRepo) SearchReservations(ctx context.Context, filter models.ReservationFilter) ([]models.Reservation, int, error) {
 	var reservations []models.Reservation
-	return reservations, nil
+	if err := ctx.Err(); err != nil {
+		return reservations, 0, err
+	}
+	//return error when searching for "error"
+	if filter.Search == "error" {
+		return reservations, 0, errors.New("some error!")
+	}
+	//60 reservations match, the page holds the first two of them
+	reservation, _ := m.GetReservationByCode(ctx, "ABC123", "john@smith.com")
+	reservation.FirstName = "John"
+	reservation.LastName = "Smith"
+	reservation.Room.Title = "General's Quarters"
+	reservations = append(reservations, reservation, models.Reservation{ID: 3, FirstName: "Jane", LastName: "Doe",
+		Status: models.ReservationCancelled, RoomId: 2})
+	return reservations, 60, nil
 }
 
-func (m *testDBRepo) GetReservationByID(id int) (models.Reservation, error) {
+func (m *testDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
 	var reservation models.Reservation
+	if err := ctx.Err(); err != nil {
+		return reservation, err
+	}
 	if id == 2 {
 		return reservation, errors.New("some error!")
 	}
+	//reservation 1 is the upcoming stay found by GetReservationByCode
+	if id == 1 {
+		return m.GetReservationByCode(ctx, "ABC123", "john@smith.com")
+	}
+	//reservation 3 has already been cancelled
+	reservation.ID = id
+	reservation.Status = models.ReservationPending
+	if id == 3 {
+		reservation.Status = models.ReservationCancelled
+	}
 	return reservation, nil
 }
 
-func (m *testDBRepo) UpdateReservation(r models.Reservation) error {
+func (m *testDBRepo) GetReservationByCode(ctx context.Context, code, email string) (models.Reservation, error) {
+	var reservation models.Reservation
+	if err := ctx.Err(); err != nil {
+		return reservation, err
+	}
+	//only ABC123 and the past stay OLD123 with john@smith.com exist
+	if (code != "ABC123" && code != "OLD123") || email != "john@smith.com" {
+		return reservation, errors.New("reservation not found!")
+	}
+	reservation.ID = 1
+	reservation.RoomId = 1
+	reservation.Email = email
+	reservation.ConfirmationCode = code
+	reservation.Status = models.ReservationConfirmed
+	reservation.StartDate = time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	reservation.EndDate = time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC)
+	if code == "OLD123" {
+		reservation.ID = 4
+		reservation.Status = models.ReservationCheckedOut
+		reservation.StartDate = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		reservation.EndDate = time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	}
+	return reservation, nil
+}
+
+func (m *testDBRepo) UpdateReservation(ctx context.Context, r models.Reservation) error {
 	return nil
 }
 
-func (m *testDBRepo) DeleteReservation(id int) error {
-	if id == 2 {
-		return errors.New("Reservation not found!")
+func (m *testDBRepo) UpdateReservationStay(ctx context.Context, r models.Reservation) error {
+	return nil
+}
+
+func (m *testDBRepo) MoveReservation(ctx context.Context, res models.Reservation) error {
+	//return error if room id eq 2
+	if res.RoomId == 2 {
+		return errors.New("Some error!")
+	}
+	//return unavailable for stays starting on 2060-01-01
+	if res.StartDate.Format("2006-01-02") == "2060-01-01" {
+		return repository.ErrRoomNotAvailable
 	}
 	return nil
 }
 
-func (m *testDBRepo) UpdateProcessedForReservation(id, processed int) error {
+func (m *testDBRepo) DeleteRestrictionsForReservation(ctx context.Context, reservationID int) error {
+	return nil
+}
+
+func (m *testDBRepo) UpdateReservationStatus(ctx context.Context, id int, status string) error {
 	if id == 2 {
 		return errors.New("Reservation not found!")
 	}
 	return nil
 }
 
-func (m *testDBRepo) AllRooms() ([]models.Room, error) {
+func (m *testDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	var rooms []models.Room
+	return rooms, nil
+}
+
+func (m *testDBRepo) AllRoomsWithArchived(ctx context.Context) ([]models.Room, error) {
 	var rooms []models.Room
 	return rooms, nil
 }
 
-func (m *testDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+func (m *testDBRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	//return error if title eq "error"
+	if room.Title == "error" {
+		return 0, errors.New("Some error!")
+	}
+	return 1, nil
+}
+
+func (m *testDBRepo) UpdateRoom(ctx context.Context, room models.Room) error {
+	if room.ID == 2 {
+		return errors.New("Room not found!")
+	}
+	return nil
+}
+
+func (m *testDBRepo) ArchiveRoom(ctx context.Context, id int) error {
+	if id == 2 {
+		return errors.New("Room not found!")
+	}
+	return nil
+}
+
+func (m *testDBRepo) GetRatesForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRate, error) {
+	var rates []models.RoomRate
+	//return a season with a minimum stay for stays starting on 2070-01-01
+	if start.Format("2006-01-02") == "2070-01-01" {
+		rates = append(rates, models.RoomRate{RoomID: roomID, StartDate: start, EndDate: end, NightlyRate: 15000, MinStay: 7})
+	}
+	return rates, nil
+}
+
+func (m *testDBRepo) GetRatesForRoom(ctx context.Context, roomID int) ([]models.RoomRate, error) {
+	var rates []models.RoomRate
+	return rates, nil
+}
+
+func (m *testDBRepo) InsertRoomRate(ctx context.Context, rate models.RoomRate) error {
+	return nil
+}
+
+func (m *testDBRepo) DeleteRoomRate(ctx context.Context, roomID, id int) error {
+	if id == 2 {
+		return errors.New("Rate not found!")
+	}
+	return nil
+}
+
+func (m *testDBRepo) UpdateRoomCalendarToken(ctx context.Context, id int, token string) error {
+	//return error if room id eq 2
+	if id == 2 {
+		return errors.New("Some error!")
+	}
+	return nil
+}
+
+func (m *testDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
 	var restriction []models.RoomRestriction
+	//room 1 has a reservation, a block, a stay booked on another site and a cleaning at the start of the range
+	if roomID == 1 {
+		restriction = append(restriction,
+			models.RoomRestriction{ID: 1, RoomId: 1, ReservationId: 1, RestrictionId: 1, StartDate: start, EndDate: start.AddDate(0, 0, 2),
+				Reservation: models.Reservation{ID: 1, FirstName: "John", LastName: "Smith", ConfirmationCode: "ABC123"},
+				Restriction: testRestrictions[0]},
+			models.RoomRestriction{ID: 2, RoomId: 1, RestrictionId: 2, Reason: models.BlockReasonMaintenance, StartDate: start.AddDate(0, 0, 5),
+				EndDate: start.AddDate(0, 0, 6), Restriction: testRestrictions[1]},
+			models.RoomRestriction{ID: 3, RoomId: 1, RestrictionId: 3, CalendarSourceID: 1, ExternalUID: "stay@other",
+				StartDate: start.AddDate(0, 0, 8), EndDate: start.AddDate(0, 0, 10), Restriction: testRestrictions[2]},
+			models.RoomRestriction{ID: 4, RoomId: 1, RestrictionId: 4, StartDate: start.AddDate(0, 0, 12),
+				EndDate: start.AddDate(0, 0, 12), Restriction: testRestrictions[3]},
+		)
+	}
 	return restriction, nil
 }
 
-func (m *testDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
+func (m *testDBRepo) GetRestrictionsByDate(ctx context.Context, start, end time.Time) ([]models.RoomRestriction, error) {
+	//only room 1 is restricted, see GetRestrictionsForRoomByDate
+	return m.GetRestrictionsForRoomByDate(ctx, 1, start, end)
+}
+
+func (m *testDBRepo) InsertBlockForRoom(ctx context.Context, block models.RoomRestriction) error {
+	//return error if room id eq 100
+	if block.RoomId == 100 {
+		return errors.New("Some error!")
+	}
+	//return unavailable for blocks starting on 2060-01-01
+	if block.StartDate.Format("2006-01-02") == "2060-01-01" {
+		return repository.ErrRoomNotAvailable
+	}
+	return nil
+}
+
+func (m *testDBRepo) DeleteBlockByID(ctx context.Context, id int) error {
+	//return error if id eq 100
+	if id == 100 {
+		return errors.New("Some error!")
+	}
+	return nil
+}
+
+func (m *testDBRepo) InsertLoginAttempt(ctx context.Context, attempt models.LoginAttempt) error {
+	return nil
+}
+
+func (m *testDBRepo) LoginFailuresByEmail(ctx context.Context, email string, since time.Time) (models.LoginFailures, error) {
+	//locked@gmail.com has just failed too often
+	if email == "locked@gmail.com" {
+		return models.LoginFailures{Key: email, Count: 10, Last: time.Now()}, nil
+	}
+	return models.LoginFailures{Key: email}, nil
+}
+
+func (m *testDBRepo) LoginFailuresByIP(ctx context.Context, ip string, since time.Time) (models.LoginFailures, error) {
+	//10.0.0.66 has just failed too often
+	if ip == "10.0.0.66" {
+		return models.LoginFailures{Key: ip, Count: 100, Last: time.Now()}, nil
+	}
+	return models.LoginFailures{Key: ip}, nil
+}
+
+func (m *testDBRepo) AllLoginFailures(ctx context.Context, since time.Time) ([]models.LoginFailures, error) {
+	locked, _ := m.LoginFailuresByEmail(ctx, "locked@gmail.com", since)
+	return []models.LoginFailures{locked, {Key: "typo@gmail.com", Count: 1, Last: time.Now()}}, nil
+}
+
+func (m *testDBRepo) RecentLoginAttempts(ctx context.Context, limit int) ([]models.LoginAttempt, error) {
+	var attempts []models.LoginAttempt
+	attempts = append(attempts, models.LoginAttempt{ID: 1, Email: "locked@gmail.com", IPAddress: "10.0.0.1", Event: models.LoginFailure, CreatedAt: time.Now()})
+	return attempts, nil
+}
+
+// Test API keys, the first may read rooms, the second has been revoked
+const (
+	TestAPIKey        = "bk_rooms-reader"
+	TestRevokedAPIKey = "bk_revoked"
+)
+
+func (m *testDBRepo) InsertAPIKey(ctx context.Context, key models.APIKey, keyHash string) (int, error) {
+	//return error if name eq "error"
+	if key.Name == "error" {
+		return 0, errors.New("Some error!")
+	}
+	return 2, nil
+}
+
+func (m *testDBRepo) GetAPIKeyByHash(ctx context.Context, keyHash string) (models.APIKey, error) {
+	switch keyHash {
+	case apikeys.Hash(TestAPIKey):
+		return models.APIKey{ID: 1, Name: "channel manager", Prefix: TestAPIKey[:8], Scopes: []string{models.APIScopeRoomsRead}}, nil
+	case apikeys.Hash(TestRevokedAPIKey):
+		return models.APIKey{ID: 3, Name: "old tablet", Prefix: TestRevokedAPIKey[:8], Scopes: models.APIScopes, Revoked: true}, nil
+	}
+	//a broken database is told apart from a key that doesn't exist
+	if keyHash == apikeys.Hash("bk_db-error") {
+		return models.APIKey{}, errors.New("Some error!")
+	}
+	return models.APIKey{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) AllAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	key, _ := m.GetAPIKeyByHash(ctx, apikeys.Hash(TestAPIKey))
+	revoked, _ := m.GetAPIKeyByHash(ctx, apikeys.Hash(TestRevokedAPIKey))
+	key.LastUsedAt = time.Now()
+	return []models.APIKey{key, revoked}, nil
+}
+
+func (m *testDBRepo) RevokeAPIKey(ctx context.Context, id int) error {
+	if id == 2 {
+		return errors.New("key not found!")
+	}
+	return nil
+}
+
+func (m *testDBRepo) UpdateAPIKeyLastUsed(ctx context.Context, id int) error {
+	return nil
+}
+
+// testCalendarSource is the only calendar source, it belongs to room 1 and can't be reached
+var testCalendarSource = models.CalendarSource{
+	ID:     1,
+	RoomID: 1,
+	Name:   "Other site",
+	URL:    "http://127.0.0.1:0/room-1.ics",
+	Room:   models.Room{ID: 1, Title: "General's Quarters"},
+}
+
+func (m *testDBRepo) AllCalendarSources(ctx context.Context) ([]models.CalendarSource, error) {
+	return []models.CalendarSource{testCalendarSource}, nil
+}
+
+func (m *testDBRepo) GetCalendarSourcesForRoom(ctx context.Context, roomID int) ([]models.CalendarSource, error) {
+	var sources []models.CalendarSource
+	if roomID == testCalendarSource.RoomID {
+		sources = append(sources, testCalendarSource)
+	}
+	return sources, nil
+}
+
+func (m *testDBRepo) GetCalendarSourceByID(ctx context.Context, roomID, id int) (models.CalendarSource, error) {
+	if roomID != testCalendarSource.RoomID || id != testCalendarSource.ID {
+		return models.CalendarSource{}, sql.ErrNoRows
+	}
+	return testCalendarSource, nil
+}
+
+func (m *testDBRepo) InsertCalendarSource(ctx context.Context, source models.CalendarSource) (int, error) {
+	//return error if name eq "error"
+	if source.Name == "error" {
+		return 0, errors.New("Some error!")
+	}
+	return 2, nil
+}
+
+func (m *testDBRepo) DeleteCalendarSource(ctx context.Context, roomID, id int) error {
+	if roomID != testCalendarSource.RoomID || id != testCalendarSource.ID {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (m *testDBRepo) UpdateCalendarSourceSync(ctx context.Context, id int, syncedAt time.Time, syncErr string) error {
+	return nil
+}
+
+func (m *testDBRepo) GetBlocksForCalendarSource(ctx context.Context, sourceID int, from time.Time) ([]models.RoomRestriction, error) {
+	var blocks []models.RoomRestriction
+	return blocks, nil
+}
+
+func (m *testDBRepo) InsertExternalBlock(ctx context.Context, r models.RoomRestriction) error {
+	return nil
+}
+
+func (m *testDBRepo) UpdateExternalBlock(ctx context.Context, id int, start, end time.Time) error {
+	return nil
+}
+
+// testRestrictions are the types of restriction, the built in ones followed by a cleaning that is in use
+// and a late checkout that isn't
+var testRestrictions = []models.Restriction{
+	{ID: 1, Code: models.RestrictionReservation, Name: "Reservation", Colour: "#dc3545", BlocksAvailability: true, GuestVisible: true},
+	{ID: 2, Code: models.RestrictionOwnerBlock, Name: "Owner block", Colour: "#6c757d", BlocksAvailability: true, GuestVisible: true},
+	{ID: 3, Code: models.RestrictionExternalCalendar, Name: "External calendar", Colour: "#17a2b8", BlocksAvailability: true, GuestVisible: true},
+	{ID: 4, Code: "cleaning", Name: "Cleaning", Colour: "#ffc107", BlocksAvailability: true},
+	{ID: 5, Code: "late-checkout", Name: "Late checkout", Colour: "#fd7e14", GuestVisible: true},
+}
+
+func (m *testDBRepo) AllRestrictions(ctx context.Context) ([]models.Restriction, error) {
+	return append([]models.Restriction{}, testRestrictions...), nil
+}
+
+func (m *testDBRepo) GetRestrictionByID(ctx context.Context, id int) (models.Restriction, error) {
+	for _, r := range testRestrictions {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+	return models.Restriction{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) GetRestrictionByCode(ctx context.Context, code string) (models.Restriction, error) {
+	for _, r := range testRestrictions {
+		if r.Code == code {
+			return r, nil
+		}
+	}
+	return models.Restriction{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) InsertRestriction(ctx context.Context, r models.Restriction) (int, error) {
+	//return error if name eq "error"
+	if r.Name == "error" {
+		return 0, errors.New("Some error!")
+	}
+	return len(testRestrictions) + 1, nil
+}
+
+func (m *testDBRepo) UpdateRestriction(ctx context.Context, r models.Restriction) error {
+	//return error if name eq "error"
+	if r.Name == "error" {
+		return errors.New("Some error!")
+	}
+	//late checkouts overlap other restrictions, so they can't start blocking availability
+	if r.ID == 5 && r.BlocksAvailability {
+		return repository.ErrRoomNotAvailable
+	}
 	return nil
 }
 
-func (m *testDBRepo) DeleteBlockByID(id int) error {
+func (m *testDBRepo) DeleteRestriction(ctx context.Context, id int) error {
+	//only the late checkout isn't in use
+	if id != 5 {
+		return repository.ErrRestrictionInUse
+	}
 	return nil
 }
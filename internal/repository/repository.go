@@ -1,28 +1,90 @@
 package repository
 
 import (
+	"context"
+	"errors"
 	"github.com/amiranbari/bookings/pkg/models"
 	"time"
 )
 
+// ErrRoomNotAvailable is returned when a room has been taken for the requested dates
+var ErrRoomNotAvailable = errors.New("room is no longer available for the selected dates")
+
+// ErrRestrictionInUse is returned when a type of restriction can't be deleted because rooms are restricted with it
+var ErrRestrictionInUse = errors.New("restriction is in use")
+
 type DatabaseRepo interface {
-	InsertReservation(res models.Reservation) (int, error)
-	InsertRoomRestriction(r models.RoomRestriction) error
-	SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error)
-	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
-
-	GetRoomById(id int) (models.Room, error)
-	GetUserByID(id int) (models.User, error)
-	Authenticate(email, password string) (int, string, error)
-
-	AllReservations() ([]models.Reservation, error)
-	AllNewReservations() ([]models.Reservation, error)
-	GetReservationByID(id int) (models.Reservation, error)
-	UpdateReservation(r models.Reservation) error
-	DeleteReservation(id int) error
-	UpdateProcessedForReservation(id, processed int) error
-	AllRooms() ([]models.Room, error)
-	GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error)
-	InsertBlockForRoom(id int, startDate time.Time) error
-	DeleteBlockByID(id int) error
+	// WithTx runs fn against a repository bound to a single transaction, committing when fn
+	// returns nil and rolling back otherwise
+	WithTx(ctx context.Context, fn func(repo DatabaseRepo) error) error
+
+	InsertReservation(ctx context.Context, res models.Reservation) (int, error)
+	ImportReservation(ctx context.Context, res models.Reservation) (int, error)
+	InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error
+	SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error)
+	SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time, guests int, amenities []string) ([]models.Room, error)
+	AllAmenities(ctx context.Context) ([]string, error)
+
+	GetRoomById(ctx context.Context, id int) (models.Room, error)
+	GetUserByID(ctx context.Context, id int) (models.User, error)
+	Authenticate(ctx context.Context, email, password string) (int, string, error)
+	GetUserByEmail(ctx context.Context, email string) (models.User, error)
+	AllUsers(ctx context.Context) ([]models.User, error)
+	InsertUser(ctx context.Context, u models.User) (int, error)
+	UpdatePassword(ctx context.Context, id int, password string) error
+	EnableTOTP(ctx context.Context, userID int, secret string, codeHashes []string) error
+	DisableTOTP(ctx context.Context, userID int) error
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error)
+	RemainingRecoveryCodes(ctx context.Context, userID int) (int, error)
+	InsertLoginAttempt(ctx context.Context, attempt models.LoginAttempt) error
+	LoginFailuresByEmail(ctx context.Context, email string, since time.Time) (models.LoginFailures, error)
+	LoginFailuresByIP(ctx context.Context, ip string, since time.Time) (models.LoginFailures, error)
+	AllLoginFailures(ctx context.Context, since time.Time) ([]models.LoginFailures, error)
+	RecentLoginAttempts(ctx context.Context, limit int) ([]models.LoginAttempt, error)
+	InsertAPIKey(ctx context.Context, key models.APIKey, keyHash string) (int, error)
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (models.APIKey, error)
+	AllAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int) error
+	UpdateAPIKeyLastUsed(ctx context.Context, id int) error
+
+	// SearchReservations returns the reservations matching filter together with how many match in total
+	SearchReservations(ctx context.Context, filter models.ReservationFilter) ([]models.Reservation, int, error)
+	GetReservationByID(ctx context.Context, id int) (models.Reservation, error)
+	GetReservationByCode(ctx context.Context, code, email string) (models.Reservation, error)
+	UpdateReservation(ctx context.Context, r models.Reservation) error
+	UpdateReservationStay(ctx context.Context, r models.Reservation) error
+	MoveReservation(ctx context.Context, res models.Reservation) error
+	DeleteRestrictionsForReservation(ctx context.Context, reservationID int) error
+	UpdateReservationStatus(ctx context.Context, id int, status string) error
+	AllRooms(ctx context.Context) ([]models.Room, error)
+	AllRoomsWithArchived(ctx context.Context) ([]models.Room, error)
+	InsertRoom(ctx context.Context, room models.Room) (int, error)
+	UpdateRoom(ctx context.Context, room models.Room) error
+	ArchiveRoom(ctx context.Context, id int) error
+	GetRatesForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRate, error)
+	GetRatesForRoom(ctx context.Context, roomID int) ([]models.RoomRate, error)
+	InsertRoomRate(ctx context.Context, rate models.RoomRate) error
+	DeleteRoomRate(ctx context.Context, roomID, id int) error
+	UpdateRoomCalendarToken(ctx context.Context, id int, token string) error
+	GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error)
+	GetRestrictionsByDate(ctx context.Context, start, end time.Time) ([]models.RoomRestriction, error)
+	InsertBlockForRoom(ctx context.Context, block models.RoomRestriction) error
+	DeleteBlockByID(ctx context.Context, id int) error
+
+	AllCalendarSources(ctx context.Context) ([]models.CalendarSource, error)
+	GetCalendarSourcesForRoom(ctx context.Context, roomID int) ([]models.CalendarSource, error)
+	GetCalendarSourceByID(ctx context.Context, roomID, id int) (models.CalendarSource, error)
+	InsertCalendarSource(ctx context.Context, source models.CalendarSource) (int, error)
+	DeleteCalendarSource(ctx context.Context, roomID, id int) error
+	UpdateCalendarSourceSync(ctx context.Context, id int, syncedAt time.Time, syncErr string) error
+	GetBlocksForCalendarSource(ctx context.Context, sourceID int, from time.Time) ([]models.RoomRestriction, error)
+	InsertExternalBlock(ctx context.Context, r models.RoomRestriction) error
+	UpdateExternalBlock(ctx context.Context, id int, start, end time.Time) error
+
+	AllRestrictions(ctx context.Context) ([]models.Restriction, error)
+	GetRestrictionByID(ctx context.Context, id int) (models.Restriction, error)
+	GetRestrictionByCode(ctx context.Context, code string) (models.Restriction, error)
+	InsertRestriction(ctx context.Context, r models.Restriction) (int, error)
+	UpdateRestriction(ctx context.Context, r models.Restriction) error
+	DeleteRestriction(ctx context.Context, id int) error
 }
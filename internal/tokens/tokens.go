@@ -0,0 +1,73 @@
+package tokens
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned for tokens that are malformed or weren't signed with the secret
+var ErrInvalidToken = errors.New("invalid token")
+
+// ErrExpiredToken is returned for correctly signed tokens past their expiry
+var ErrExpiredToken = errors.New("token has expired")
+
+// Sign returns a url safe token for userID that is valid until expires. The token is bound to the
+// user's current password hash, so it stops working as soon as the password is changed.
+func Sign(secret []byte, userID int, passwordHash string, expires time.Time) string {
+	payload := fmt.Sprintf("%d.%d", userID, expires.Unix())
+	return payload + "." + signature(secret, payload, passwordHash)
+}
+
+// UserID returns the user a token was issued for, without verifying it
+func UserID(token string) (int, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, ErrInvalidToken
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+
+	return id, nil
+}
+
+// Verify checks that token was signed with secret for the user's current password hash and hasn't expired at now
+func Verify(secret []byte, token, passwordHash string, now time.Time) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrInvalidToken
+	}
+
+	payload := parts[0] + "." + parts[1]
+	expected := signature(secret, payload, passwordHash)
+	if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+		return ErrInvalidToken
+	}
+
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	if now.After(time.Unix(expires, 0)) {
+		return ErrExpiredToken
+	}
+
+	return nil
+}
+
+func signature(secret []byte, payload, passwordHash string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	mac.Write([]byte{0})
+	mac.Write([]byte(passwordHash))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
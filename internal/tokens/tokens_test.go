@@ -0,0 +1,50 @@
+package tokens
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var secret = []byte("test-secret")
+
+func TestVerify(t *testing.T) {
+	now := time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)
+	token := Sign(secret, 7, "hash", now.Add(time.Hour))
+
+	tests := []struct {
+		name     string
+		secret   []byte
+		token    string
+		hash     string
+		now      time.Time
+		expected error
+	}{
+		{"valid", secret, token, "hash", now, nil},
+		{"expired", secret, token, "hash", now.Add(2 * time.Hour), ErrExpiredToken},
+		{"password-changed", secret, token, "new-hash", now, ErrInvalidToken},
+		{"other-secret", []byte("other"), token, "hash", now, ErrInvalidToken},
+		{"tampered-user", secret, "8" + token[1:], "hash", now, ErrInvalidToken},
+		{"malformed", secret, "not-a-token", "hash", now, ErrInvalidToken},
+	}
+
+	for _, e := range tests {
+		err := Verify(e.secret, e.token, e.hash, e.now)
+		if !errors.Is(err, e.expected) {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expected, err)
+		}
+	}
+}
+
+func TestUserID(t *testing.T) {
+	token := Sign(secret, 7, "hash", time.Now().Add(time.Hour))
+
+	id, err := UserID(token)
+	if err != nil || id != 7 {
+		t.Errorf("expected user 7 but got %d, %v", id, err)
+	}
+
+	if _, err := UserID("x.y"); err == nil {
+		t.Error("expected error for malformed token")
+	}
+}
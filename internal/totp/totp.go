@@ -0,0 +1,130 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/amiranbari/bookings/internal/helpers"
+)
+
+const (
+	// Period is how long each code is valid for
+	Period = 30 * time.Second
+	// Digits is the length of a code
+	Digits = 6
+	// skew is how many periods before and after now are accepted, to allow for clock drift
+	skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret returns a random base32 encoded secret to share with an authenticator app
+func NewSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// ProvisioningURI returns the otpauth:// URI authenticator apps read from a QR code
+func ProvisioningURI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period.Seconds())))
+
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// Code returns the code for secret at t
+func Code(secret string, t time.Time) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+	return code(key, counter(t)), nil
+}
+
+// Validate reports whether code is valid for secret at now, accepting the neighbouring periods
+func Validate(secret, code string, now time.Time) bool {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != Digits {
+		return false
+	}
+
+	for i := -skew; i <= skew; i++ {
+		expected, err := Code(secret, now.Add(time.Duration(i)*Period))
+		if err != nil {
+			return false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func counter(t time.Time) uint64 {
+	return uint64(t.Unix() / int64(Period.Seconds()))
+}
+
+// code implements the HOTP algorithm from RFC 4226
+func code(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", Digits, value%mod)
+}
+
+// NewRecoveryCodes returns n single use codes like ABCDE-FGHJK for when the authenticator is lost
+func NewRecoveryCodes(n int) ([]string, error) {
+	max := big.NewInt(int64(len(helpers.CodeAlphabet)))
+
+	codes := make([]string, n)
+	for i := range codes {
+		b := make([]byte, 10)
+		for j := range b {
+			x, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return nil, err
+			}
+			b[j] = helpers.CodeAlphabet[x.Int64()]
+		}
+		codes[i] = string(b[:5]) + "-" + string(b[5:])
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode returns the hash a recovery code is stored as, ignoring case and dashes
+func HashRecoveryCode(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,99 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key from the RFC 6238 test vectors
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+// the RFC lists 8 digit codes, these are their last 6 digits
+var codeTests = []struct {
+	unix     int64
+	expected string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestCode(t *testing.T) {
+	for _, e := range codeTests {
+		got, err := Code(rfcSecret, time.Unix(e.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != e.expected {
+			t.Errorf("at %d expected %s but got %s", e.unix, e.expected, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	if !Validate(rfcSecret, "050471", now) {
+		t.Error("current code is not valid")
+	}
+
+	if !Validate(rfcSecret, "050471", now.Add(Period)) {
+		t.Error("previous code is not accepted for clock drift")
+	}
+
+	if Validate(rfcSecret, "050471", now.Add(3*Period)) {
+		t.Error("old code is still valid")
+	}
+
+	if Validate(rfcSecret, "12345", now) {
+		t.Error("short code is valid")
+	}
+
+	if Validate("not base32!", "050471", now) {
+		t.Error("code is valid for a broken secret")
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := Code(secret, time.Now())
+	if err != nil || !Validate(secret, code, time.Now()) {
+		t.Errorf("code %s for new secret %s is not valid: %v", code, secret, err)
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("Bookings", "admin@gmail.com", "JBSWY3DPEHPK3PXP")
+
+	if !strings.HasPrefix(uri, "otpauth://totp/Bookings:admin@gmail.com?") {
+		t.Errorf("unexpected uri %s", uri)
+	}
+
+	if !strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") || !strings.Contains(uri, "issuer=Bookings") {
+		t.Errorf("uri %s is missing the secret or issuer", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := NewRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(codes) != 10 || len(codes[0]) != 11 || codes[0][5] != '-' {
+		t.Errorf("unexpected codes %v", codes)
+	}
+
+	if HashRecoveryCode(codes[0]) != HashRecoveryCode(strings.ToLower(strings.Replace(codes[0], "-", "", 1))) {
+		t.Error("hash depends on case or dashes")
+	}
+}
@@ -0,0 +1,129 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, "Reservations: 2050/01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := [][]interface{}{
+		{"Name", "Nights", "Amount", "Arrival", "Notes"},
+		{"Smith, John & <Jane>", 2, 123.45, time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), nil},
+	}
+	for _, row := range rows {
+		if err := w.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		parts[f.Name] = string(content)
+
+		// every part has to be well formed
+		d := xml.NewDecoder(bytes.NewReader(content))
+		for {
+			_, err := d.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s isn't well formed: %s", f.Name, err)
+			}
+		}
+	}
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml", "xl/worksheets/sheet1.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("missing part %s", name)
+		}
+	}
+
+	if !strings.Contains(parts["xl/workbook.xml"], `<sheet name="Reservations 205001"`) {
+		t.Errorf("expected the sheet name without forbidden characters in %s", parts["xl/workbook.xml"])
+	}
+
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	for _, cell := range []string{
+		`<c r="A1" t="inlineStr"><is><t xml:space="preserve">Name</t></is></c>`,
+		`<c r="A2" t="inlineStr"><is><t xml:space="preserve">Smith, John &amp; &lt;Jane&gt;</t></is></c>`,
+		`<c r="B2"><v>2</v></c>`,
+		`<c r="C2"><v>123.45</v></c>`,
+		`<c r="D2" s="1"><v>54789</v></c>`,
+	} {
+		if !strings.Contains(sheet, cell) {
+			t.Errorf("missing cell %s in %s", cell, sheet)
+		}
+	}
+	if strings.Contains(sheet, `r="E2"`) {
+		t.Errorf("expected nil to leave the cell empty in %s", sheet)
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	tests := map[int]string{0: "A", 1: "B", 25: "Z", 26: "AA", 27: "AB", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"}
+
+	for i, expected := range tests {
+		if name := columnName(i); name != expected {
+			t.Errorf("for column %d, expected %s but got %s", i, expected, name)
+		}
+	}
+}
+
+func TestSerial(t *testing.T) {
+	tests := []struct {
+		date     time.Time
+		expected float64
+	}{
+		{time.Date(1900, 3, 1, 0, 0, 0, 0, time.UTC), 61},
+		{time.Date(2022, 10, 18, 0, 0, 0, 0, time.UTC), 44852},
+		{time.Date(2022, 10, 18, 12, 0, 0, 0, time.FixedZone("CET", 3600)), 44852.5},
+	}
+
+	for _, e := range tests {
+		if s := serial(e.date); s != e.expected {
+			t.Errorf("for %s, expected %v but got %v", e.date, e.expected, s)
+		}
+	}
+}
+
+func TestSheetName(t *testing.T) {
+	tests := map[string]string{
+		"Reservations":                         "Reservations",
+		"a/b\\c[d]e:f*g?h":                     "abcdefgh",
+		"":                                     "Sheet1",
+		"[]":                                   "Sheet1",
+		"Reservations from 2050-01-01 onwards": "Reservations from 2050-01-01 on",
+	}
+
+	for name, expected := range tests {
+		if got := sheetName(name); got != expected {
+			t.Errorf("for %q, expected %q but got %q", name, expected, got)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/amiranbari/bookings/pkg/models"
 	"html/template"
 	"log"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
 )
@@ -18,4 +19,13 @@ type AppConfig struct {
 	InfoLog       *log.Logger
 	ErrorLog      *log.Logger
 	MailChan      chan models.MailData
+	DBTimeout     time.Duration
+	SecretKey     []byte // signs password reset links
+	BaseURL       string // absolute links in emails, e.g. https://bookings.example.com
+	// TwoFactorLevel is the lowest access level that must use two-factor authentication, 0 leaves it optional
+	TwoFactorLevel int
+	// CalendarImportDir is the only directory calendar files may be imported from, empty allows URLs only
+	CalendarImportDir string
+	// CalendarSyncInterval is how often external calendars are imported, 0 turns the sync job off
+	CalendarSyncInterval time.Duration
 }
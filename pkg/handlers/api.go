@@ -0,0 +1,413 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amiranbari/bookings/internal/forms"
+	"github.com/amiranbari/bookings/internal/pricing"
+	"github.com/amiranbari/bookings/internal/repository"
+	"github.com/amiranbari/bookings/pkg/models"
+)
+
+// apiRoom is a room as returned by the API
+type apiRoom struct {
+	ID            int      `json:"id"`
+	Title         string   `json:"title"`
+	Description   string   `json:"description"`
+	MaxOccupancy  int      `json:"max_occupancy"`
+	Amenities     []string `json:"amenities"`
+	NightlyRate   int      `json:"nightly_rate"` // in cents
+	WeekendUplift int      `json:"weekend_uplift"`
+	MinStay       int      `json:"min_stay"`
+}
+
+func newAPIRoom(room models.Room) apiRoom {
+	amenities := room.Amenities
+	if amenities == nil {
+		amenities = []string{}
+	}
+
+	return apiRoom{
+		ID:            room.ID,
+		Title:         room.Title,
+		Description:   room.Description,
+		MaxOccupancy:  room.MaxOccupancy,
+		Amenities:     amenities,
+		NightlyRate:   room.NightlyRate,
+		WeekendUplift: room.WeekendUplift,
+		MinStay:       room.MinStay,
+	}
+}
+
+// apiAvailableRoom is a room that can be booked for the searched dates, with the price of the stay
+type apiAvailableRoom struct {
+	apiRoom
+	Nights int `json:"nights"`
+	Total  int `json:"total"` // in cents
+}
+
+// apiReservation is a reservation as returned to the guest who made it
+type apiReservation struct {
+	ConfirmationCode string `json:"confirmation_code"`
+	Status           string `json:"status"`
+	FirstName        string `json:"firstname"`
+	LastName         string `json:"lastname"`
+	Email            string `json:"email"`
+	Phone            string `json:"phone"`
+	RoomID           int    `json:"room_id"`
+	RoomTitle        string `json:"room_title"`
+	StartDate        string `json:"start_date"`
+	EndDate          string `json:"end_date"`
+	Amount           int    `json:"amount"` // in cents
+}
+
+func newAPIReservation(res models.Reservation) apiReservation {
+	return apiReservation{
+		ConfirmationCode: res.ConfirmationCode,
+		Status:           res.Status,
+		FirstName:        res.FirstName,
+		LastName:         res.LastName,
+		Email:            res.Email,
+		Phone:            res.Phone,
+		RoomID:           res.RoomId,
+		RoomTitle:        res.Room.Title,
+		StartDate:        res.StartDate.Format(forms.DateLayout),
+		EndDate:          res.EndDate.Format(forms.DateLayout),
+		Amount:           res.Amount,
+	}
+}
+
+// apiNewReservation is the body of a request to book a room, the fields are named like the
+// reservation form so both are validated the same way
+type apiNewReservation struct {
+	FirstName string `json:"firstname"`
+	LastName  string `json:"lastname"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+	RoomID    int    `json:"room_id"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+}
+
+// apiGuest is the body of requests that act on a reservation, the email proves it is the guest's
+type apiGuest struct {
+	Email string `json:"email"`
+}
+
+// apiError is the body of every unsuccessful API response
+type apiError struct {
+	Error apiErrorDetail `json:"error"`
+}
+
+type apiErrorDetail struct {
+	// Code is meant for programs and doesn't change, Message is meant for people
+	Code    string              `json:"code"`
+	Message string              `json:"message"`
+	Fields  map[string][]string `json:"fields,omitempty"`
+}
+
+// API error codes
+const (
+	apiErrBadRequest       = "bad_request"
+	apiErrValidation       = "validation_failed"
+	apiErrNotFound         = "not_found"
+	apiErrRoomNotAvailable = "room_not_available"
+	apiErrMinimumStay      = "minimum_stay"
+	apiErrNotChangeable    = "not_changeable"
+	apiErrInternal         = "internal_error"
+
+	// APIErrUnauthorized means the API key is missing, unknown or revoked
+	APIErrUnauthorized = "unauthorized"
+	// APIErrForbidden means the API key lacks the scope for the route
+	APIErrForbidden = "forbidden"
+)
+
+func (m *Repository) writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		m.WriteAPIServerError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	rw.Write(out)
+}
+
+// WriteAPIError sends an error body with code, one of the API error codes, and a message for people
+func (m *Repository) WriteAPIError(rw http.ResponseWriter, status int, code, message string) {
+	m.writeJSON(rw, status, apiError{apiErrorDetail{Code: code, Message: message}})
+}
+
+// writeAPIValidationError reports every invalid field of form
+func (m *Repository) writeAPIValidationError(rw http.ResponseWriter, form *forms.Form) {
+	m.writeJSON(rw, http.StatusUnprocessableEntity, apiError{apiErrorDetail{
+		Code:    apiErrValidation,
+		Message: "some fields are not valid",
+		Fields:  map[string][]string(form.Errors),
+	}})
+}
+
+// WriteAPIServerError logs err and hides the details from the client
+func (m *Repository) WriteAPIServerError(rw http.ResponseWriter, err error) {
+	m.App.ErrorLog.Println(err)
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(rw, `{"error": {"code": %q, "message": "something went wrong"}}`, apiErrInternal)
+}
+
+// writeAPIQuoteError explains why a stay can't be booked
+func (m *Repository) writeAPIQuoteError(rw http.ResponseWriter, err error) {
+	var minStay *pricing.MinimumStayError
+	if errors.As(err, &minStay) {
+		m.WriteAPIError(rw, http.StatusUnprocessableEntity, apiErrMinimumStay,
+			fmt.Sprintf("this room requires a minimum stay of %d nights for those dates", minStay.Nights))
+		return
+	}
+	m.WriteAPIError(rw, http.StatusUnprocessableEntity, apiErrValidation, "end_date can't be before start_date")
+}
+
+// decodeJSON reads the request body into v, writing an error response if it can't
+func (m *Repository) decodeJSON(rw http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(rw, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		m.WriteAPIError(rw, http.StatusBadRequest, apiErrBadRequest, fmt.Sprintf("can't read the request body: %s", err))
+		return false
+	}
+	return true
+}
+
+// apiPathParam returns the i-th segment of the request path, e.g. 4 is {code} in /api/v1/reservations/{code}
+func apiPathParam(r *http.Request, i int) string {
+	exploded := strings.Split(r.URL.Path, "/")
+	if i >= len(exploded) {
+		return ""
+	}
+	return exploded[i]
+}
+
+// APIAvailability lists the rooms that can be booked between start_date and end_date, with the price of the stay
+func (m *Repository) APIAvailability(rw http.ResponseWriter, r *http.Request) {
+	form := forms.New(r.URL.Query())
+	form.Required("start_date", "end_date")
+	form.IsDate("start_date")
+	form.IsDate("end_date")
+	if form.Get("guests") != "" {
+		form.IsInt("guests", 1)
+	}
+
+	if !form.Valid() {
+		m.writeAPIValidationError(rw, form)
+		return
+	}
+
+	startDate, _ := time.Parse(forms.DateLayout, form.Get("start_date"))
+	endDate, _ := time.Parse(forms.DateLayout, form.Get("end_date"))
+	if endDate.Before(startDate) {
+		m.writeAPIQuoteError(rw, pricing.ErrInvalidStay)
+		return
+	}
+
+	guests := 1
+	if form.Get("guests") != "" {
+		guests, _ = strconv.Atoi(form.Get("guests"))
+	}
+
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(r.Context(), startDate, endDate, guests, form.Values["amenities"])
+	if err != nil {
+		m.WriteAPIServerError(rw, err)
+		return
+	}
+
+	available := []apiAvailableRoom{}
+	for _, room := range rooms {
+		quote, err := m.quoteStay(r.Context(), room, startDate, endDate)
+		if isQuoteError(err) {
+			// e.g. the stay is shorter than the room's minimum for those dates
+			continue
+		}
+		if err != nil {
+			m.WriteAPIServerError(rw, err)
+			return
+		}
+
+		available = append(available, apiAvailableRoom{
+			apiRoom: newAPIRoom(room),
+			Nights:  len(quote.Nights),
+			Total:   quote.Total,
+		})
+	}
+
+	m.writeJSON(rw, http.StatusOK, map[string]interface{}{
+		"start_date": form.Get("start_date"),
+		"end_date":   form.Get("end_date"),
+		"rooms":      available,
+	})
+}
+
+// APIRooms lists every room that can be booked
+func (m *Repository) APIRooms(rw http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		m.WriteAPIServerError(rw, err)
+		return
+	}
+
+	out := []apiRoom{}
+	for _, room := range rooms {
+		out = append(out, newAPIRoom(room))
+	}
+
+	m.writeJSON(rw, http.StatusOK, map[string]interface{}{
+		"rooms": out,
+	})
+}
+
+// APIRoom returns a single room, archived rooms are not found
+func (m *Repository) APIRoom(rw http.ResponseWriter, r *http.Request) {
+	roomID, err := strconv.Atoi(apiPathParam(r, 4))
+	if err != nil {
+		m.WriteAPIError(rw, http.StatusNotFound, apiErrNotFound, "room not found")
+		return
+	}
+
+	room, err := m.DB.GetRoomById(r.Context(), roomID)
+	if err != nil || room.Archived {
+		m.WriteAPIError(rw, http.StatusNotFound, apiErrNotFound, "room not found")
+		return
+	}
+
+	m.writeJSON(rw, http.StatusOK, newAPIRoom(room))
+}
+
+// APIPostReservation books a room, the response holds the confirmation code the guest needs for
+// everything else
+func (m *Repository) APIPostReservation(rw http.ResponseWriter, r *http.Request) {
+	var body apiNewReservation
+	if !m.decodeJSON(rw, r, &body) {
+		return
+	}
+
+	form := forms.New(url.Values{
+		"firstname":  {body.FirstName},
+		"lastname":   {body.LastName},
+		"email":      {body.Email},
+		"phone":      {body.Phone},
+		"room_id":    {strconv.Itoa(body.RoomID)},
+		"start_date": {body.StartDate},
+		"end_date":   {body.EndDate},
+	})
+	validateGuestForm(form)
+	form.IsInt("room_id", 1)
+	form.IsDate("start_date")
+	form.IsDate("end_date")
+
+	if !form.Valid() {
+		m.writeAPIValidationError(rw, form)
+		return
+	}
+
+	startDate, _ := time.Parse(forms.DateLayout, body.StartDate)
+	endDate, _ := time.Parse(forms.DateLayout, body.EndDate)
+
+	res, _, err := m.bookReservation(r.Context(), models.Reservation{
+		FirstName: strings.TrimSpace(body.FirstName),
+		LastName:  strings.TrimSpace(body.LastName),
+		Email:     strings.TrimSpace(body.Email),
+		Phone:     strings.TrimSpace(body.Phone),
+		StartDate: startDate,
+		EndDate:   endDate,
+		RoomId:    body.RoomID,
+	})
+
+	switch {
+	case errors.Is(err, errRoomNotFound):
+		form.Errors.Add("room_id", "This room doesn't exist.")
+		m.writeAPIValidationError(rw, form)
+		return
+	case isQuoteError(err):
+		m.writeAPIQuoteError(rw, err)
+		return
+	case errors.Is(err, repository.ErrRoomNotAvailable):
+		m.WriteAPIError(rw, http.StatusConflict, apiErrRoomNotAvailable, "the room is not available for those dates")
+		return
+	case err != nil:
+		m.WriteAPIServerError(rw, err)
+		return
+	}
+
+	res.Status = models.ReservationPending
+
+	rw.Header().Set("Location", "/api/v1/reservations/"+res.ConfirmationCode)
+	m.writeJSON(rw, http.StatusCreated, newAPIReservation(res))
+}
+
+// apiGuestReservation loads the reservation with the code in the path if it belongs to email, writing
+// an error response if there is none
+func (m *Repository) apiGuestReservation(rw http.ResponseWriter, r *http.Request, email string) (models.Reservation, bool) {
+	form := forms.New(url.Values{"email": {email}})
+	form.Required("email")
+	form.IsEmail("email")
+	if !form.Valid() {
+		m.writeAPIValidationError(rw, form)
+		return models.Reservation{}, false
+	}
+
+	res, err := m.DB.GetReservationByCode(r.Context(), apiPathParam(r, 4), strings.TrimSpace(email))
+	if err != nil {
+		m.WriteAPIError(rw, http.StatusNotFound, apiErrNotFound, "no reservation with that code and email")
+		return models.Reservation{}, false
+	}
+
+	return res, true
+}
+
+// APIReservation returns the reservation with the code in the path, the guest's email is passed as
+// the email query parameter
+func (m *Repository) APIReservation(rw http.ResponseWriter, r *http.Request) {
+	res, ok := m.apiGuestReservation(rw, r, r.URL.Query().Get("email"))
+	if !ok {
+		return
+	}
+
+	m.writeJSON(rw, http.StatusOK, newAPIReservation(res))
+}
+
+// APIPostReservationCancel cancels the reservation with the code in the path and releases the room
+func (m *Repository) APIPostReservationCancel(rw http.ResponseWriter, r *http.Request) {
+	var body apiGuest
+	if !m.decodeJSON(rw, r, &body) {
+		return
+	}
+
+	res, ok := m.apiGuestReservation(rw, r, body.Email)
+	if !ok {
+		return
+	}
+
+	if !guestCanChange(res) {
+		m.WriteAPIError(rw, http.StatusConflict, apiErrNotChangeable, "this reservation can no longer be cancelled online")
+		return
+	}
+
+	err := m.cancelReservation(r.Context(), res.ID)
+	if err != nil {
+		m.WriteAPIServerError(rw, err)
+		return
+	}
+
+	m.sendCancellationMail(res)
+
+	res.Status = models.ReservationCancelled
+	m.writeJSON(rw, http.StatusOK, newAPIReservation(res))
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/amiranbari/bookings/internal/apikeys"
+	"github.com/amiranbari/bookings/internal/forms"
+	"github.com/amiranbari/bookings/internal/helpers"
+	"github.com/amiranbari/bookings/pkg/models"
+	"github.com/amiranbari/bookings/pkg/renders"
+)
+
+// AdminAPIKeys lists the API keys with when they were last used, together with the form to issue a new one
+func (m *Repository) AdminAPIKeys(rw http.ResponseWriter, r *http.Request) {
+	m.renderAdminAPIKeys(rw, r, forms.New(nil), "")
+}
+
+// renderAdminAPIKeys shows the keys page, newKey is only set right after a key was issued because it
+// can't be shown again
+func (m *Repository) renderAdminAPIKeys(rw http.ResponseWriter, r *http.Request, form *forms.Form, newKey string) {
+	keys, err := m.DB.AllAPIKeys(r.Context())
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	selected := make(map[string]bool)
+	for _, scope := range form.Values["scopes"] {
+		selected[scope] = true
+	}
+
+	data := make(map[string]interface{})
+	data["keys"] = keys
+	data["scopes"] = models.APIScopes
+	data["selected_scopes"] = selected
+
+	stringMap := make(map[string]string)
+	stringMap["new_key"] = newKey
+
+	renders.Template(rw, r, "admin-api-keys.page.html", &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// AdminPostNewAPIKey issues a key with the chosen scopes and shows it once
+func (m *Repository) AdminPostNewAPIKey(rw http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name")
+	form.MaxLength("name", 255)
+
+	scopes := form.Values["scopes"]
+	if len(scopes) == 0 {
+		form.Errors.Add("scopes", "choose at least one scope")
+	}
+	for _, scope := range scopes {
+		if !validAPIScope(scope) {
+			form.Errors.Add("scopes", fmt.Sprintf("unknown scope %s", scope))
+		}
+	}
+
+	if !form.Valid() {
+		m.renderAdminAPIKeys(rw, r, form, "")
+		return
+	}
+
+	key, prefix, err := apikeys.New()
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	_, err = m.DB.InsertAPIKey(r.Context(), models.APIKey{
+		Name:   strings.TrimSpace(form.Get("name")),
+		Prefix: prefix,
+		Scopes: scopes,
+	}, apikeys.Hash(key))
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	// rendered instead of redirected so the key never ends up in the session
+	m.App.Session.Put(r.Context(), "flash", "API key created, copy it now as it won't be shown again.")
+	m.renderAdminAPIKeys(rw, r, forms.New(nil), key)
+}
+
+// AdminPostRevokeAPIKey stops a key from working
+func (m *Repository) AdminPostRevokeAPIKey(rw http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "missing url parameter")
+		http.Redirect(rw, r, "/admin/api-keys", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.RevokeAPIKey(r.Context(), id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't revoke API key!")
+		http.Redirect(rw, r, "/admin/api-keys", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "API key revoked.")
+	http.Redirect(rw, r, "/admin/api-keys", http.StatusSeeOther)
+}
+
+func validAPIScope(scope string) bool {
+	for _, s := range models.APIScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amiranbari/bookings/internal/calsync"
+	"github.com/amiranbari/bookings/internal/forms"
+	"github.com/amiranbari/bookings/internal/helpers"
+	"github.com/amiranbari/bookings/internal/ical"
+	"github.com/amiranbari/bookings/pkg/models"
+)
+
+const (
+	// calendarFeedPast and calendarFeedFuture bound the restrictions exported in a room's calendar feed
+	calendarFeedPast   = 90 * 24 * time.Hour
+	calendarFeedFuture = 365 * 24 * time.Hour
+	// calendarProdID identifies us as the producer of calendar feeds
+	calendarProdID = "-//Bookings//Room calendar//EN"
+)
+
+// RoomCalendar serves a room's reservations and blocks as an iCalendar feed. Calendar apps can't log
+// in, so the link carries the room's calendar token instead.
+func (m *Repository) RoomCalendar(rw http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[2])
+	if err != nil {
+		http.NotFound(rw, r)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(r.Context(), id)
+	if err != nil {
+		http.NotFound(rw, r)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if room.CalendarToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(room.CalendarToken)) != 1 {
+		http.NotFound(rw, r)
+		return
+	}
+
+	now := time.Now()
+	restrictions, err := m.DB.GetRestrictionsForRoomByDate(r.Context(), room.ID, now.Add(-calendarFeedPast), now.Add(calendarFeedFuture))
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	cal := ical.Calendar{
+		ProdID: calendarProdID,
+		Name:   room.Title,
+	}
+	for _, restriction := range restrictions {
+		// other sites would take anything in the feed to mean the room is booked
+		if !restriction.Restriction.BlocksAvailability {
+			continue
+		}
+		cal.Events = append(cal.Events, m.calendarEvent(restriction))
+	}
+
+	rw.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	rw.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="room-%d.ics"`, room.ID))
+	err = cal.Write(rw)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+}
+
+// calendarEvent turns a reservation or block into an event covering the same days as on the admin calendar
+func (m *Repository) calendarEvent(restriction models.RoomRestriction) ical.Event {
+	host := "bookings"
+	if u, err := url.Parse(m.App.BaseURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+
+	event := ical.Event{
+		UID:   fmt.Sprintf("room-restriction-%d@%s", restriction.ID, host),
+		Start: restriction.StartDate,
+		// restrictions include their last day, DTEND doesn't
+		End:     restriction.EndDate.AddDate(0, 0, 1),
+		Summary: "Blocked",
+		Stamp:   restriction.UpdatedAt,
+	}
+
+	switch {
+	case !restriction.Restriction.GuestVisible:
+		// the feed ends up on other booking sites, so hidden restrictions only show the room is taken
+		event.Summary = "Unavailable"
+	case restriction.ReservationId > 0:
+		// guest names and confirmation codes stay out of the feed, the code and email are all it takes to
+		// change or cancel a stay
+		event.Summary = "Reserved"
+		event.Description = fmt.Sprintf("%s/admin/reservations/%d", m.App.BaseURL, restriction.ReservationId)
+	case restriction.Restriction.Code == models.RestrictionExternalCalendar:
+		event.Summary = "Booked on another site"
+	case restriction.Restriction.Code != models.RestrictionOwnerBlock:
+		event.Summary = restriction.Restriction.Name
+	}
+
+	if restriction.Reason != "" && restriction.Restriction.GuestVisible {
+		event.Summary = fmt.Sprintf("%s: %s", event.Summary, restriction.Reason)
+	}
+
+	return event
+}
+
+// calendarURL is the feed link of a room, empty when the room has no calendar token yet
+func (m *Repository) calendarURL(room models.Room) string {
+	if room.CalendarToken == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/rooms/%d/calendar.ics?token=%s", m.App.BaseURL, room.ID, url.QueryEscape(room.CalendarToken))
+}
+
+// AdminPostRoomCalendarToken creates the calendar feed link of a room, or replaces it so the old link
+// stops working
+func (m *Repository) AdminPostRoomCalendarToken(rw http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		http.Redirect(rw, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	roomURL := fmt.Sprintf("/admin/rooms/%d", id)
+
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	err = m.DB.UpdateRoomCalendarToken(r.Context(), id, base64.RawURLEncoding.EncodeToString(b))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't update calendar link!")
+		http.Redirect(rw, r, roomURL, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Calendar link created, links shared before no longer work.")
+	http.Redirect(rw, r, roomURL, http.StatusSeeOther)
+}
+
+// calendarSyncer returns the syncer that imports calendar sources into blocks
+func (m *Repository) calendarSyncer() *calsync.Syncer {
+	return calsync.New(m.DB, m.App.CalendarImportDir, m.App.ErrorLog)
+}
+
+// AdminPostCalendarSource adds a calendar of another booking site to a room and imports it right away
+func (m *Repository) AdminPostCalendarSource(rw http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	roomID, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		http.Redirect(rw, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	roomURL := fmt.Sprintf("/admin/rooms/%d", roomID)
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "url")
+	form.MaxLength("name", 255)
+	if !form.Valid() {
+		m.App.Session.Put(r.Context(), "error", "Calendar needs a name and a link!")
+		http.Redirect(rw, r, roomURL, http.StatusSeeOther)
+		return
+	}
+
+	source := models.CalendarSource{
+		RoomID: roomID,
+		Name:   strings.TrimSpace(form.Get("name")),
+		URL:    strings.TrimSpace(form.Get("url")),
+	}
+
+	err = calsync.CheckSource(source.URL, m.App.CalendarImportDir)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Calendar can't be imported: %s", err))
+		http.Redirect(rw, r, roomURL, http.StatusSeeOther)
+		return
+	}
+
+	source.ID, err = m.DB.InsertCalendarSource(r.Context(), source)
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	m.syncCalendarSource(r, source)
+	http.Redirect(rw, r, roomURL, http.StatusSeeOther)
+}
+
+// AdminPostSyncCalendarSource imports a calendar source without waiting for the sync job
+func (m *Repository) AdminPostSyncCalendarSource(rw http.ResponseWriter, r *http.Request) {
+	source, ok := m.adminCalendarSource(rw, r)
+	if !ok {
+		return
+	}
+
+	m.syncCalendarSource(r, source)
+	http.Redirect(rw, r, fmt.Sprintf("/admin/rooms/%d", source.RoomID), http.StatusSeeOther)
+}
+
+// AdminPostDeleteCalendarSource removes a calendar source together with the blocks imported from it
+func (m *Repository) AdminPostDeleteCalendarSource(rw http.ResponseWriter, r *http.Request) {
+	source, ok := m.adminCalendarSource(rw, r)
+	if !ok {
+		return
+	}
+
+	roomURL := fmt.Sprintf("/admin/rooms/%d", source.RoomID)
+
+	err := m.DB.DeleteCalendarSource(r.Context(), source.RoomID, source.ID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't remove calendar!")
+		http.Redirect(rw, r, roomURL, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Calendar removed together with its blocks.")
+	http.Redirect(rw, r, roomURL, http.StatusSeeOther)
+}
+
+// adminCalendarSource loads the calendar source in a /admin/rooms/{id}/calendar-sources/{sourceID}/... url,
+// redirecting if there is none
+func (m *Repository) adminCalendarSource(rw http.ResponseWriter, r *http.Request) (models.CalendarSource, bool) {
+	exploded := strings.Split(r.RequestURI, "/")
+	roomID, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		http.Redirect(rw, r, "/admin/rooms", http.StatusSeeOther)
+		return models.CalendarSource{}, false
+	}
+
+	roomURL := fmt.Sprintf("/admin/rooms/%d", roomID)
+
+	id, err := strconv.Atoi(exploded[5])
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "missing url parameter")
+		http.Redirect(rw, r, roomURL, http.StatusSeeOther)
+		return models.CalendarSource{}, false
+	}
+
+	source, err := m.DB.GetCalendarSourceByID(r.Context(), roomID, id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't find calendar!")
+		http.Redirect(rw, r, roomURL, http.StatusSeeOther)
+		return models.CalendarSource{}, false
+	}
+
+	return source, true
+}
+
+// syncCalendarSource imports source and tells the user how it went
+func (m *Repository) syncCalendarSource(r *http.Request, source models.CalendarSource) {
+	result, err := m.calendarSyncer().Sync(r.Context(), source)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Calendar %s could not be imported: %s", source.Name, err))
+		return
+	}
+
+	if len(result.Failed) > 0 {
+		m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("Calendar %s imported (%s), the dates %s overlap reservations or blocks.",
+			source.Name, result, strings.Join(result.Failed, ", ")))
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Calendar %s imported: %s.", source.Name, result))
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amiranbari/bookings/internal/forms"
+	"github.com/amiranbari/bookings/internal/helpers"
+	"github.com/amiranbari/bookings/internal/xlsx"
+	"github.com/amiranbari/bookings/pkg/models"
+)
+
+// Formats reservations can be exported in
+const (
+	exportCSV  = "csv"
+	exportXLSX = "xlsx"
+)
+
+// exportBatchSize is how many reservations are read from the database at a time while exporting
+const exportBatchSize = 500
+
+// phoneNumber matches values made only of what phone numbers are written with, spreadsheets can't run those
+// even when they start with + or -
+var phoneNumber = regexp.MustCompile(`^[+-]?[0-9 ()./-]+$`)
+
+var exportHeader = []interface{}{"ID", "Confirmation code", "First name", "Last name", "Email", "Phone", "Room",
+	"Arrival", "Departure", "Nights", "Status", "Amount"}
+
+// rowWriter writes the rows of an export
+type rowWriter interface {
+	Write(row []interface{}) error
+	Close() error
+}
+
+// AdminReservationsExport downloads the reservations arriving between the from and to parameters, only
+// those in status if it's given, as a CSV or XLSX file
+func (m *Repository) AdminReservationsExport(rw http.ResponseWriter, r *http.Request) {
+	form := forms.New(r.URL.Query())
+
+	format := form.Get("format")
+	if format == "" {
+		format = exportCSV
+	}
+	if format != exportCSV && format != exportXLSX {
+		form.Errors.Add("format", "choose CSV or XLSX")
+	}
+
+	var filter models.ReservationFilter
+	if form.Get("from") != "" && form.IsDate("from") {
+		filter.From, _ = time.Parse(forms.DateLayout, form.Get("from"))
+	}
+	if form.Get("to") != "" && form.IsDate("to") {
+		filter.To, _ = time.Parse(forms.DateLayout, form.Get("to"))
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && filter.To.Before(filter.From) {
+		form.Errors.Add("to", "the range can't end before it starts")
+	}
+
+	filter.Status = form.Get("status")
+	if filter.Status != "" && !models.ValidReservationStatus(filter.Status) {
+		form.Errors.Add("status", "unknown status")
+	}
+
+	if !form.Valid() {
+		m.App.Session.Put(r.Context(), "error", "Export is not valid!")
+		http.Redirect(rw, r, "/admin/reservations", http.StatusSeeOther)
+		return
+	}
+
+	// ordered by id so reservations made during the export don't shift the batches
+	filter.Sort = models.ReservationSortID
+	filter.Limit = exportBatchSize
+
+	// the first batch is read before anything is sent so an error can still be shown
+	reservations, total, err := m.DB.SearchReservations(r.Context(), filter)
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", exportContentType(format))
+	rw.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, exportName(filter), format))
+
+	w, err := newExportWriter(rw, format)
+	if err == nil {
+		err = w.Write(exportHeader)
+	}
+	for err == nil && len(reservations) > 0 {
+		for _, res := range reservations {
+			if err = w.Write(exportRow(res)); err != nil {
+				break
+			}
+		}
+
+		filter.Offset += len(reservations)
+		if err != nil || filter.Offset >= total {
+			break
+		}
+		reservations, _, err = m.DB.SearchReservations(r.Context(), filter)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+
+	// the file has been partly sent, all that's left is to log why it's cut short
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// newExportWriter returns the writer for format, xlsx starts writing the workbook to w right away
+func newExportWriter(w io.Writer, format string) (rowWriter, error) {
+	if format == exportXLSX {
+		return xlsx.NewWriter(w, "Reservations")
+	}
+	return &csvWriter{csv.NewWriter(w)}, nil
+}
+
+func exportContentType(format string) string {
+	if format == exportXLSX {
+		return xlsx.ContentType
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// exportName names the file after the exported range
+func exportName(filter models.ReservationFilter) string {
+	name := "reservations"
+	if !filter.From.IsZero() {
+		name += "-from-" + filter.From.Format(forms.DateLayout)
+	}
+	if !filter.To.IsZero() {
+		name += "-to-" + filter.To.Format(forms.DateLayout)
+	}
+	if filter.Status != "" {
+		name += "-" + filter.Status
+	}
+	return name
+}
+
+// exportRow returns the cells of res in the order of exportHeader, the amount is left empty when the stay
+// wasn't priced
+func exportRow(res models.Reservation) []interface{} {
+	var amount interface{}
+	if res.Amount > 0 {
+		amount = float64(res.Amount) / 100
+	}
+
+	return []interface{}{
+		res.ID,
+		res.ConfirmationCode,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		res.Room.Title,
+		res.StartDate,
+		res.EndDate,
+		int(res.EndDate.Sub(res.StartDate).Hours() / 24),
+		res.Status,
+		amount,
+	}
+}
+
+// csvWriter writes export rows as CSV, dates as 2006-01-02 and amounts with two decimals. Text starting
+// like a formula is quoted with an apostrophe so spreadsheets don't run what guests typed, phone numbers like
+// +44 20 7946 0958 are left as they are.
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) Write(row []interface{}) error {
+	record := make([]string, len(row))
+	for i, value := range row {
+		switch v := value.(type) {
+		case nil:
+		case string:
+			if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) && !phoneNumber.MatchString(v) {
+				v = "'" + v
+			}
+			record[i] = v
+		case int:
+			record[i] = strconv.Itoa(v)
+		case float64:
+			record[i] = strconv.FormatFloat(v, 'f', 2, 64)
+		case time.Time:
+			record[i] = v.Format(forms.DateLayout)
+		default:
+			record[i] = fmt.Sprint(v)
+		}
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
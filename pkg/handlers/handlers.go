@@ -1,14 +1,18 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/amiranbari/bookings/internal/driver"
 	"github.com/amiranbari/bookings/internal/helpers"
+	"github.com/amiranbari/bookings/internal/pricing"
 	"github.com/amiranbari/bookings/internal/repository"
 	"github.com/amiranbari/bookings/internal/repository/dbrepo"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -76,6 +80,10 @@ func (m *Repository) Reservation(rw http.ResponseWriter, r *http.Request) {
 	data := make(map[string]interface{})
 	data["reservation"] = reservation
 
+	if quote, ok := m.App.Session.Get(r.Context(), "quote").(pricing.Quote); ok {
+		data["quote"] = quote
+	}
+
 	sd := reservation.StartDate.Format("2006-01-02")
 	ed := reservation.EndDate.Format("2006-01-02")
 	stringMap := make(map[string]string)
@@ -111,6 +119,14 @@ func (m *Repository) Search(rw http.ResponseWriter, r *http.Request) {
 	var emptyReservation models.Reservation
 	data := make(map[string]interface{})
 	data["reservation"] = emptyReservation
+
+	amenities, err := m.DB.AllAmenities(r.Context())
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+	data["amenities"] = amenities
+
 	renders.Template(rw, r, "search.page.html", &models.TemplateData{
 		Data: data,
 		Form: forms.New(nil),
@@ -127,6 +143,9 @@ func (m *Repository) PostSearch(rw http.ResponseWriter, r *http.Request) {
 
 	form := forms.New(r.PostForm)
 	form.Required("start_date", "end_date")
+	if r.Form.Get("guests") != "" {
+		form.IsInt("guests", 1)
+	}
 
 	if !form.Valid() {
 		m.App.Session.Put(r.Context(), "error", "form is not valid!")
@@ -134,6 +153,12 @@ func (m *Repository) PostSearch(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	guests := 1
+	if r.Form.Get("guests") != "" {
+		guests, _ = strconv.Atoi(r.Form.Get("guests"))
+	}
+	amenities := r.Form["amenities"]
+
 	sd := r.Form.Get("start_date")
 	ed := r.Form.Get("end_date")
 
@@ -151,7 +176,7 @@ func (m *Repository) PostSearch(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(r.Context(), startDate, endDate, guests, amenities)
 
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Can't search in availability rooms!")
@@ -214,15 +239,23 @@ func (m *Repository) MakeReservation(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	room, err := m.DB.GetRoomById(res.RoomId)
-	if err != nil {
+	room, err := m.DB.GetRoomById(r.Context(), res.RoomId)
+	if err != nil || room.Archived {
 		m.App.Session.Put(r.Context(), "error", "can't find room!")
 		http.Redirect(rw, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
 
-	res.Room.Title = room.Title
+	quote, err := m.quoteStay(r.Context(), room, res.StartDate, res.EndDate)
+	if err != nil {
+		m.redirectInvalidQuote(rw, r, err, "/search")
+		return
+	}
+
+	res.Room = room
+	res.Amount = quote.Total
 	data["reservation"] = res
+	data["quote"] = quote
 
 	sd := res.StartDate.Format("2006-01-02")
 	ed := res.EndDate.Format("2006-01-02")
@@ -251,8 +284,7 @@ func (m *Repository) PostReservation(rw http.ResponseWriter, r *http.Request) {
 	}
 
 	form := forms.New(r.PostForm)
-	form.Required("firstname", "lastname", "email", "phone")
-	form.IsEmail("email")
+	validateGuestForm(form)
 
 	if !form.Valid() {
 		m.App.Session.Put(r.Context(), "error", "Form is not valid!")
@@ -276,44 +308,83 @@ func (m *Repository) PostReservation(rw http.ResponseWriter, r *http.Request) {
 		EndDate:   res.EndDate,
 		RoomId:    res.RoomId,
 	}
-	reservation.Room.Title = res.Room.Title
 
-	newReservationId, err := m.DB.InsertReservation(reservation)
+	reservation, quote, err := m.bookReservation(r.Context(), reservation)
 
-	if err != nil {
+	switch {
+	case errors.Is(err, errRoomNotFound):
+		m.App.Session.Put(r.Context(), "error", "can't find room!")
+		http.Redirect(rw, r, "/make-reservation", http.StatusTemporaryRedirect)
+		return
+	case isQuoteError(err):
+		m.redirectInvalidQuote(rw, r, err, "/search")
+		return
+	case errors.Is(err, repository.ErrRoomNotAvailable):
+		m.App.Session.Remove(r.Context(), "reservation")
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room has just been booked for those dates. Please search again.")
+		http.Redirect(rw, r, "/search", http.StatusSeeOther)
+		return
+	case err != nil:
 		m.App.Session.Put(r.Context(), "error", "can't insert reservation to database!")
 		http.Redirect(rw, r, "/make-reservation", http.StatusTemporaryRedirect)
 		return
 	}
 
-	restriction := models.RoomRestriction{
-		RoomId:        res.RoomId,
-		ReservationId: newReservationId,
-		RestrictionId: 1,
-		StartDate:     res.StartDate,
-		EndDate:       res.EndDate,
+	m.App.Session.Put(r.Context(), "reservation", reservation)
+	m.App.Session.Put(r.Context(), "quote", quote)
+
+	http.Redirect(rw, r, "/reservation", http.StatusSeeOther)
+
+}
+
+// validateGuestForm checks the guest details every new reservation needs
+func validateGuestForm(form *forms.Form) {
+	form.Required("firstname", "lastname", "email", "phone")
+	form.IsEmail("email")
+}
+
+// errRoomNotFound is returned by bookReservation when the room doesn't exist
+var errRoomNotFound = errors.New("room not found")
+
+// bookReservation prices res, stores it with a new confirmation code and emails the guest about it.
+// Besides database errors it returns errRoomNotFound, the quote errors from the pricing package and
+// repository.ErrRoomNotAvailable.
+func (m *Repository) bookReservation(ctx context.Context, res models.Reservation) (models.Reservation, pricing.Quote, error) {
+	room, err := m.DB.GetRoomById(ctx, res.RoomId)
+	if err != nil {
+		return res, pricing.Quote{}, errRoomNotFound
 	}
+	res.Room = room
 
-	err = m.DB.InsertRoomRestriction(restriction)
+	// price the stay again so the stored amount matches the rates at booking time
+	quote, err := m.quoteStay(ctx, room, res.StartDate, res.EndDate)
+	if err != nil {
+		return res, quote, err
+	}
+	res.Amount = quote.Total
 
+	res.ConfirmationCode, err = helpers.NewConfirmationCode()
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert restriction to database!")
-		http.Redirect(rw, r, "/make-reservation", http.StatusTemporaryRedirect)
-		return
+		return res, quote, err
 	}
 
-	m.App.Session.Put(r.Context(), "reservation", reservation)
+	res.ID, err = m.DB.InsertReservation(ctx, res)
+	if err != nil {
+		return res, quote, err
+	}
 
 	//send reservation mail
 
 	html := fmt.Sprintf(`
 		<strong>Reservation Confirmation</stronge><br>
 		Dear %s: <br>
-		This is to confirm your reservation from %s to %s.
-		`, reservation.FirstName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
+		This is to confirm your reservation from %s to %s.<br>
+		Your confirmation code is <strong>%s</strong>, use it with your email at
+		<a href="%s/my-reservation">My reservation</a> to view, change or cancel your stay.
+		`, res.FirstName, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"), res.ConfirmationCode, m.App.BaseURL)
 
 	msg := models.MailData{
-		To:      reservation.Email,
+		To:      res.Email,
 		From:    "me@here.com",
 		Subject: "Reservation confirmation",
 		Content: html,
@@ -325,10 +396,10 @@ func (m *Repository) PostReservation(rw http.ResponseWriter, r *http.Request) {
 	html = fmt.Sprintf(`
 		<strong>Reservation Notification</stronge><br>
 		A reservation has been made for %s from %s to %s.
-		`, reservation.Room.Title, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
+		`, res.Room.Title, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"))
 
 	msg = models.MailData{
-		To:      reservation.Email,
+		To:      res.Email,
 		From:    "me@here.com",
 		Subject: "Reservation confirmation",
 		Content: html,
@@ -336,329 +407,1427 @@ func (m *Repository) PostReservation(rw http.ResponseWriter, r *http.Request) {
 
 	m.App.MailChan <- msg
 
-	http.Redirect(rw, r, "/reservation", http.StatusSeeOther)
+	return res, quote, nil
+}
+
+// quoteStay prices a stay in room using its seasonal rates
+func (m *Repository) quoteStay(ctx context.Context, room models.Room, start, end time.Time) (pricing.Quote, error) {
+	rates, err := m.DB.GetRatesForRoomByDate(ctx, room.ID, start, end)
+	if err != nil {
+		return pricing.Quote{}, err
+	}
 
+	return pricing.NewQuote(room, rates, start, end)
 }
 
-// Login users
-func (m *Repository) Login(rw http.ResponseWriter, r *http.Request) {
-	renders.Template(rw, r, "login.page.html", &models.TemplateData{
+// isQuoteError reports whether err means the stay itself can't be booked, rather than something broke
+func isQuoteError(err error) bool {
+	var minStay *pricing.MinimumStayError
+	return errors.As(err, &minStay) || errors.Is(err, pricing.ErrInvalidStay)
+}
+
+// redirectInvalidQuote sends the guest back to url with a message when a stay can't be priced
+func (m *Repository) redirectInvalidQuote(rw http.ResponseWriter, r *http.Request, err error, url string) {
+	var minStay *pricing.MinimumStayError
+	switch {
+	case errors.As(err, &minStay):
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Sorry, this room requires a minimum stay of %d nights for those dates.", minStay.Nights))
+	case errors.Is(err, pricing.ErrInvalidStay):
+		m.App.Session.Put(r.Context(), "error", "Departure can't be before arrival!")
+	default:
+		m.App.Session.Put(r.Context(), "error", "can't price this stay!")
+	}
+	http.Redirect(rw, r, url, http.StatusSeeOther)
+}
+
+// MyReservation shows the form guests use to find their reservation
+func (m *Repository) MyReservation(rw http.ResponseWriter, r *http.Request) {
+	renders.Template(rw, r, "my-reservation.page.html", &models.TemplateData{
 		Form: forms.New(nil),
 	})
 }
 
-// PostLogin users
-func (m *Repository) PostLogin(rw http.ResponseWriter, r *http.Request) {
+// PostMyReservation looks up a reservation by confirmation code and email
+func (m *Repository) PostMyReservation(rw http.ResponseWriter, r *http.Request) {
 	_ = m.App.Session.RenewToken(r.Context())
 
 	err := r.ParseForm()
 	if err != nil {
-		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(rw, r, "/my-reservation", http.StatusSeeOther)
+		return
 	}
 
 	form := forms.New(r.PostForm)
-	form.Required("email", "password")
+	form.Required("code", "email")
 	form.IsEmail("email")
-	form.MinLength("password", 8)
+
 	if !form.Valid() {
-		renders.Template(rw, r, "login.page.html", &models.TemplateData{
+		renders.Template(rw, r, "my-reservation.page.html", &models.TemplateData{
 			Form: form,
 		})
 		return
 	}
 
-	email := r.Form.Get("email")
-	password := r.Form.Get("password")
-
-	id, _, err := m.DB.Authenticate(email, password)
+	res, err := m.DB.GetReservationByCode(r.Context(), strings.TrimSpace(form.Get("code")), strings.TrimSpace(form.Get("email")))
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "invalid login credentials")
-		http.Redirect(rw, r, "/login", http.StatusSeeOther)
+		m.App.Session.Put(r.Context(), "error", "We couldn't find a reservation with that code and email.")
+		http.Redirect(rw, r, "/my-reservation", http.StatusSeeOther)
 		return
 	}
 
-	m.App.Session.Put(r.Context(), "user_id", id)
-	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
-	http.Redirect(rw, r, "/", http.StatusSeeOther)
+	m.App.Session.Put(r.Context(), "guest_reservation_id", res.ID)
+	http.Redirect(rw, r, "/my-reservation/view", http.StatusSeeOther)
 }
 
-// Logout users
-func (m *Repository) Logout(rw http.ResponseWriter, r *http.Request) {
-	_ = m.App.Session.Destroy(r.Context())
-	_ = m.App.Session.RenewToken(r.Context())
+// guestReservation loads the reservation the guest looked up, redirecting to the lookup form if there is none
+func (m *Repository) guestReservation(rw http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	id, ok := m.App.Session.Get(r.Context(), "guest_reservation_id").(int)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Please enter your confirmation code and email.")
+		http.Redirect(rw, r, "/my-reservation", http.StatusSeeOther)
+		return models.Reservation{}, false
+	}
 
-	http.Redirect(rw, r, "/", http.StatusSeeOther)
-}
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		m.App.Session.Remove(r.Context(), "guest_reservation_id")
+		m.App.Session.Put(r.Context(), "error", "We couldn't find your reservation.")
+		http.Redirect(rw, r, "/my-reservation", http.StatusSeeOther)
+		return models.Reservation{}, false
+	}
 
-// Dashboard admin
-func (m *Repository) Dashboard(rw http.ResponseWriter, r *http.Request) {
-	renders.Template(rw, r, "dashboard.page.html", &models.TemplateData{
-		Form: forms.New(nil),
-	})
+	return res, true
 }
 
-func (m *Repository) AdminReservations(rw http.ResponseWriter, r *http.Request) {
-	data := make(map[string]interface{})
-	reservations, err := m.DB.AllReservations()
-	if err != nil {
-		helpers.ServerError(rw, err)
-		return
+// guestCanChange reports whether a guest may still change or cancel res online
+func guestCanChange(res models.Reservation) bool {
+	if res.Status != models.ReservationPending && res.Status != models.ReservationConfirmed {
+		return false
 	}
-	data["reservations"] = reservations
-	renders.Template(rw, r, "admin-reservations.page.html", &models.TemplateData{
-		Form: forms.New(nil),
-		Data: data,
-	})
+	today := time.Now().Truncate(24 * time.Hour)
+	return !res.StartDate.Before(today)
 }
 
-func (m *Repository) AdminNewReservations(rw http.ResponseWriter, r *http.Request) {
-	data := make(map[string]interface{})
-	reservations, err := m.DB.AllNewReservations()
-	if err != nil {
-		helpers.ServerError(rw, err)
+// MyReservationView shows the reservation the guest looked up
+func (m *Repository) MyReservationView(rw http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(rw, r)
+	if !ok {
 		return
 	}
-	data["reservations"] = reservations
-	renders.Template(rw, r, "admin-reservations.page.html", &models.TemplateData{
-		Form: forms.New(nil),
-		Data: data,
-	})
-}
 
-func (m *Repository) AdminShowReservations(rw http.ResponseWriter, r *http.Request) {
 	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["can_change"] = guestCanChange(res)
 
-	data["referer"] = r.Referer()
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = res.StartDate.Format("2006-01-02")
+	stringMap["end_date"] = res.EndDate.Format("2006-01-02")
 
-	exploded := strings.Split(r.RequestURI, "/")
-	id, err := strconv.Atoi(exploded[3])
-	if err != nil {
-		http.Redirect(rw, r, "/admin/reservations", http.StatusSeeOther)
+	renders.Template(rw, r, "my-reservation-view.page.html", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      forms.New(nil),
+	})
+}
+
+// PostMyReservationDates moves the guest's reservation to new dates if the room is free for them
+func (m *Repository) PostMyReservationDates(rw http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(rw, r)
+	if !ok {
 		return
 	}
 
-	res, err := m.DB.GetReservationByID(id)
-	if err != nil {
-		helpers.ServerError(rw, err)
+	if !guestCanChange(res) {
+		m.App.Session.Put(r.Context(), "error", "This reservation can no longer be changed online.")
+		http.Redirect(rw, r, "/my-reservation/view", http.StatusSeeOther)
 		return
 	}
 
-	data["reservation"] = res
-
-	renders.Template(rw, r, "admin-show-reservation.page.html", &models.TemplateData{
-		Form: forms.New(nil),
-		Data: data,
-	})
-}
-
-func (m *Repository) AdminPostShowReservations(rw http.ResponseWriter, r *http.Request) {
-
-	exploded := strings.Split(r.RequestURI, "/")
-	id, err := strconv.Atoi(exploded[3])
+	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(rw, err)
+		m.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(rw, r, "/my-reservation/view", http.StatusSeeOther)
 		return
 	}
 
-	res, err := m.DB.GetReservationByID(id)
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, r.Form.Get("start_date"))
 	if err != nil {
-		m.App.Session.Put(r.Context(), "warning", "Something wrong happened!")
-		http.Redirect(rw, r, "/admin/reservations", http.StatusSeeOther)
+		m.App.Session.Put(r.Context(), "error", "Arrival date is not valid!")
+		http.Redirect(rw, r, "/my-reservation/view", http.StatusSeeOther)
 		return
 	}
 
-	res.FirstName = r.Form.Get("firstname")
-	res.LastName = r.Form.Get("lastname")
-	res.Email = r.Form.Get("email")
-	res.Phone = r.Form.Get("phone")
-
-	err = m.DB.UpdateReservation(res)
+	endDate, err := time.Parse(layout, r.Form.Get("end_date"))
 	if err != nil {
-		helpers.ServerError(rw, err)
+		m.App.Session.Put(r.Context(), "error", "Departure date is not valid!")
+		http.Redirect(rw, r, "/my-reservation/view", http.StatusSeeOther)
 		return
 	}
 
-	m.App.Session.Put(r.Context(), "flash", "Reservation successfully updated.")
-	http.Redirect(rw, r, "/admin/reservations", http.StatusSeeOther)
-
-}
+	res.StartDate = startDate
+	res.EndDate = endDate
 
-func (m *Repository) AdminPutShowReservations(rw http.ResponseWriter, r *http.Request) {
+	if !guestCanChange(res) {
+		m.App.Session.Put(r.Context(), "error", "Arrival can't be in the past!")
+		http.Redirect(rw, r, "/my-reservation/view", http.StatusSeeOther)
+		return
+	}
 
-	exploded := strings.Split(r.RequestURI, "/")
-	id, err := strconv.Atoi(exploded[3])
+	room, err := m.DB.GetRoomById(r.Context(), res.RoomId)
 	if err != nil {
-		http.Redirect(rw, r, "/admin/reservations", http.StatusTemporaryRedirect)
+		m.App.Session.Put(r.Context(), "error", "can't find room!")
+		http.Redirect(rw, r, "/my-reservation/view", http.StatusSeeOther)
 		return
 	}
 
-	err = m.DB.UpdateProcessedForReservation(id, 1)
+	quote, err := m.quoteStay(r.Context(), room, startDate, endDate)
 	if err != nil {
-		http.Redirect(rw, r, "/admin/reservations", http.StatusTemporaryRedirect)
+		m.redirectInvalidQuote(rw, r, err, "/my-reservation/view")
 		return
 	}
+	res.Amount = quote.Total
 
-	m.App.Session.Put(r.Context(), "flash", "Reservation successfully processed.")
-	http.Redirect(rw, r, "/admin/reservations", http.StatusSeeOther)
-}
-
-func (m *Repository) AdminDeleteReservation(rw http.ResponseWriter, r *http.Request) {
-
-	exploded := strings.Split(r.RequestURI, "/")
-	id, err := strconv.Atoi(exploded[3])
-	if err != nil {
-		http.Redirect(rw, r, "/admin/reservations", http.StatusTemporaryRedirect)
+	// the current dates don't count against the new ones
+	err = m.DB.MoveReservation(r.Context(), res)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, the room is not available for those dates.")
+		http.Redirect(rw, r, "/my-reservation/view", http.StatusSeeOther)
 		return
 	}
 
-	err = m.DB.DeleteReservation(id)
 	if err != nil {
-		http.Redirect(rw, r, "/admin/reservations", http.StatusTemporaryRedirect)
+		m.App.Session.Put(r.Context(), "error", "Your reservation could not be changed, nothing was changed!")
+		http.Redirect(rw, r, "/my-reservation/view", http.StatusSeeOther)
 		return
 	}
 
-	m.App.Session.Put(r.Context(), "flash", "Reservation successfully deleted.")
-	http.Redirect(rw, r, "/admin/reservations", http.StatusSeeOther)
-}
-
-func (m *Repository) AdminReservationsCalender(rw http.ResponseWriter, r *http.Request) {
-	now := time.Now()
+	html := fmt.Sprintf(`
+		<strong>Reservation Changed</strong><br>
+		Dear %s: <br>
+		Your reservation %s is now from %s to %s, the new total is %s.
+		`, res.FirstName, res.ConfirmationCode, startDate.Format("2006-01-02"), endDate.Format("2006-01-02"), renders.FormatPrice(res.Amount))
 
-	if r.URL.Query().Get("y") != "" {
-		year, _ := strconv.Atoi(r.URL.Query().Get("y"))
-		month, _ := strconv.Atoi(r.URL.Query().Get("m"))
-		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	m.App.MailChan <- models.MailData{
+		To:      res.Email,
+		From:    "me@here.com",
+		Subject: "Reservation changed",
+		Content: html,
 	}
 
-	data := make(map[string]interface{})
-	data["now"] = now
-
-	next := now.AddDate(0, 1, 0)
-	last := now.AddDate(0, -1, 0)
-
-	nextMonth := next.Format("01")
-	nextMonthYear := next.Format("2006")
-
-	lastMonth := last.Format("01")
-	lastMonthYear := last.Format("2006")
-
-	stringMap := make(map[string]string)
-	stringMap["next_month"] = nextMonth
-	stringMap["next_month_year"] = nextMonthYear
-	stringMap["last_month"] = lastMonth
-	stringMap["last_month_year"] = lastMonthYear
-
-	stringMap["this_month"] = now.Format("01")
-	stringMap["this_month_year"] = now.Format("2006")
+	m.App.Session.Put(r.Context(), "flash", "Your reservation has been changed.")
+	http.Redirect(rw, r, "/my-reservation/view", http.StatusSeeOther)
+}
 
-	//get the first and last day of the month
-	currentYear, currentMonth, _ := now.Date()
-	currentLocation := now.Location()
-	firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
-	lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+// PostMyReservationCancel cancels the guest's reservation and releases the room
+func (m *Repository) PostMyReservationCancel(rw http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(rw, r)
+	if !ok {
+		return
+	}
 
-	intMap := make(map[string]int)
-	intMap["days_in_month"] = lastOfMonth.Day()
+	if !guestCanChange(res) {
+		m.App.Session.Put(r.Context(), "error", "This reservation can no longer be cancelled online.")
+		http.Redirect(rw, r, "/my-reservation/view", http.StatusSeeOther)
+		return
+	}
 
-	rooms, err := m.DB.AllRooms()
+	err := m.cancelReservation(r.Context(), res.ID)
 	if err != nil {
-		helpers.ServerError(rw, err)
+		m.App.Session.Put(r.Context(), "error", "Your reservation could not be cancelled!")
+		http.Redirect(rw, r, "/my-reservation/view", http.StatusSeeOther)
 		return
 	}
 
-	data["rooms"] = rooms
-
-	for _, x := range rooms {
-		reservationMap := make(map[string]int)
-		blockMap := make(map[string]int)
-
-		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
-			reservationMap[d.Format("2006-01-2")] = 0
-			blockMap[d.Format("2006-01-2")] = 0
-		}
-
-		restrictions, err := m.DB.GetRestrictionsForRoomByDate(x.ID, firstOfMonth, lastOfMonth)
-		if err != nil {
-			helpers.ServerError(rw, err)
-			return
-		}
+	m.App.Session.Remove(r.Context(), "guest_reservation_id")
 
-		for _, y := range restrictions {
-			if y.ReservationId > 0 {
-				// it's a reservation
-				for d := y.StartDate; d.After(y.EndDate) == false; d = d.AddDate(0, 0, 1) {
-					reservationMap[d.Format("2006-01-2")] = y.ReservationId
-				}
-			} else {
-				//it's a block
-				blockMap[y.StartDate.Format("2006-01-2")] = y.ID
-			}
-		}
+	m.sendCancellationMail(res)
 
-		data[fmt.Sprintf("reservation_map_%d", x.ID)] = reservationMap
-		data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
+	m.App.Session.Put(r.Context(), "flash", "Your reservation has been cancelled.")
+	http.Redirect(rw, r, "/", http.StatusSeeOther)
+}
 
-		//fmt.Println(blockMap)
+// sendCancellationMail tells the guest their reservation has been cancelled
+func (m *Repository) sendCancellationMail(res models.Reservation) {
+	html := fmt.Sprintf(`
+		<strong>Reservation Cancelled</strong><br>
+		Dear %s: <br>
+		Your reservation %s from %s to %s has been cancelled.
+		`, res.FirstName, res.ConfirmationCode, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"))
 
-		m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", x.ID), blockMap)
+	m.App.MailChan <- models.MailData{
+		To:      res.Email,
+		From:    "me@here.com",
+		Subject: "Reservation cancelled",
+		Content: html,
 	}
+}
 
-	renders.Template(rw, r, "admin-reservations-calender.page.html", &models.TemplateData{
-		Form:      forms.New(nil),
-		Data:      data,
-		StringMap: stringMap,
-		IntMap:    intMap,
+// Login users
+func (m *Repository) Login(rw http.ResponseWriter, r *http.Request) {
+	renders.Template(rw, r, "login.page.html", &models.TemplateData{
+		Form: forms.New(nil),
 	})
 }
 
-func (m *Repository) AdminPostReservationsCalender(rw http.ResponseWriter, r *http.Request) {
+// PostLogin users
+func (m *Repository) PostLogin(rw http.ResponseWriter, r *http.Request) {
+	_ = m.App.Session.RenewToken(r.Context())
+
 	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(rw, err)
+		log.Println(err)
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email", "password")
+	form.IsEmail("email")
+	form.MinLength("password", 8)
+	if !form.Valid() {
+		renders.Template(rw, r, "login.page.html", &models.TemplateData{
+			Form: form,
+		})
 		return
 	}
 
-	year, _ := strconv.Atoi(r.Form.Get("y"))
-	month, _ := strconv.Atoi(r.Form.Get("m"))
+	email := strings.ToLower(strings.TrimSpace(r.Form.Get("email")))
+	password := r.Form.Get("password")
+	ip := clientIP(r)
 
-	//process blocks
-	rooms, err := m.DB.AllRooms()
+	wait, err := m.loginWait(r.Context(), email, ip)
 	if err != nil {
 		helpers.ServerError(rw, err)
 		return
 	}
+	if wait > 0 {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Too many failed login attempts, please try again in %s.", wait.Round(time.Second)))
+		http.Redirect(rw, r, "/login", http.StatusSeeOther)
+		return
+	}
 
-	form := forms.New(r.PostForm)
+	id, _, err := m.DB.Authenticate(r.Context(), email, password)
+	if err != nil {
+		m.recordLogin(r.Context(), email, ip, models.LoginFailure)
+		m.App.Session.Put(r.Context(), "error", "invalid login credentials")
+		http.Redirect(rw, r, "/login", http.StatusSeeOther)
+		return
+	}
 
-	for _, x := range rooms {
-		curMap := m.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", x.ID)).(map[string]int)
-		for name, value := range curMap {
-			if val, ok := curMap[name]; ok {
-				if val > 0 {
-					if !form.Has(fmt.Sprintf("remove_block_%d_%s", x.ID, name)) {
-						err = m.DB.DeleteBlockByID(value)
-						if err != nil {
-							log.Println(err)
-						}
-					}
-				}
-			}
-		}
+	user, err := m.DB.GetUserByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
 	}
 
-	for name, _ := range r.PostForm {
-		if strings.HasPrefix(name, "add_block_") {
-			exploded := strings.Split(name, "_")
-			roomID, _ := strconv.Atoi(exploded[2])
-			t, _ := time.Parse("2006-01-2", exploded[3])
-			err = m.DB.InsertBlockForRoom(roomID, t)
-			if err != nil {
-				log.Println(err)
-			}
-		}
+	// the login only counts as successful once the second step is done too
+	if user.TOTPEnabled {
+		m.App.Session.Put(r.Context(), "pending_user_id", id)
+		m.App.Session.Put(r.Context(), "pending_login_at", time.Now().Unix())
+		http.Redirect(rw, r, "/login/two-factor", http.StatusSeeOther)
+		return
 	}
 
-	m.App.Session.Put(r.Context(), "flash", "Changes saved!")
-	http.Redirect(rw, r, fmt.Sprintf("/admin/reservations-calender?y=%d&m=%d", year, month), http.StatusSeeOther)
+	m.recordLogin(r.Context(), email, ip, models.LoginSuccess)
+
+	m.logIn(r.Context(), id)
+
+	if user.TwoFactorRequired(m.App.TwoFactorLevel) {
+		m.App.Session.Put(r.Context(), "warning", "Please set up two-factor authentication to continue.")
+		http.Redirect(rw, r, "/admin/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
+	http.Redirect(rw, r, "/", http.StatusSeeOther)
+}
+
+// Logout users
+func (m *Repository) Logout(rw http.ResponseWriter, r *http.Request) {
+	_ = m.App.Session.Destroy(r.Context())
+	_ = m.App.Session.RenewToken(r.Context())
+
+	http.Redirect(rw, r, "/", http.StatusSeeOther)
+}
+
+// Dashboard admin
+func (m *Repository) Dashboard(rw http.ResponseWriter, r *http.Request) {
+	renders.Template(rw, r, "dashboard.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// AdminReservations lists reservations a page at a time, filtered by the status, q, sort, order and page
+// parameters
+func (m *Repository) AdminReservations(rw http.ResponseWriter, r *http.Request) {
+	list := newReservationList("/admin/reservations", r.URL.Query(), false)
+
+	// unknown statuses show every reservation
+	if !models.ValidReservationStatus(list.Filter.Status) {
+		list.Filter.Status = ""
+	}
+
+	m.renderAdminReservations(rw, r, list, "Recent reservations")
+}
+
+// AdminNewReservations lists the pending reservations a page at a time
+func (m *Repository) AdminNewReservations(rw http.ResponseWriter, r *http.Request) {
+	list := newReservationList("/admin/new-reservations", r.URL.Query(), true)
+	list.Filter.Status = models.ReservationPending
+
+	m.renderAdminReservations(rw, r, list, "New reservations")
+}
+
+func (m *Repository) renderAdminReservations(rw http.ResponseWriter, r *http.Request, list reservationList, title string) {
+	reservations, total, err := m.DB.SearchReservations(r.Context(), list.Filter)
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+	list.Reservations = reservations
+	list.Total = total
+
+	data := make(map[string]interface{})
+	data["list"] = list
+	if !list.fixedStatus {
+		data["statuses"] = models.ReservationStatuses
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["title"] = title
+
+	renders.Template(rw, r, "admin-reservations.page.html", &models.TemplateData{
+		Form:      forms.New(nil),
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// reservationsPerPage is how many reservations the admin lists show at a time
+const reservationsPerPage = 25
+
+// reservationList is a page of one of the admin reservation lists, with the links to search, sort and
+// page through it
+type reservationList struct {
+	Path         string
+	Filter       models.ReservationFilter
+	Page         int // from 1
+	Total        int // reservations matching the filter on every page
+	Reservations []models.Reservation
+	fixedStatus  bool // the list only shows one status, which isn't a parameter
+}
+
+// newReservationList reads the filter of the list at path from the status, q, sort, order and page
+// parameters, it shows the latest stays first by default
+func newReservationList(path string, query url.Values, fixedStatus bool) reservationList {
+	list := reservationList{
+		Path:        path,
+		Page:        1,
+		fixedStatus: fixedStatus,
+		Filter: models.ReservationFilter{
+			Search: strings.TrimSpace(query.Get("q")),
+			Sort:   models.ReservationSortStart,
+			Desc:   true,
+			Limit:  reservationsPerPage,
+		},
+	}
+
+	if !fixedStatus {
+		list.Filter.Status = query.Get("status")
+	}
+
+	for _, sort := range models.ReservationSorts {
+		if sort == query.Get("sort") {
+			list.Filter.Sort = sort
+			list.Filter.Desc = query.Get("order") == "desc"
+		}
+	}
+
+	if page, err := strconv.Atoi(query.Get("page")); err == nil && page > 1 {
+		list.Page = page
+	}
+	list.Filter.Offset = (list.Page - 1) * reservationsPerPage
+
+	return list
+}
+
+// Pages returns how many pages the matching reservations take
+func (l reservationList) Pages() int {
+	return (l.Total + reservationsPerPage - 1) / reservationsPerPage
+}
+
+// First returns the position of the first reservation on the page, counting from 1
+func (l reservationList) First() int {
+	if len(l.Reservations) == 0 {
+		return 0
+	}
+	return l.Filter.Offset + 1
+}
+
+// Last returns the position of the last reservation on the page
+func (l reservationList) Last() int {
+	return l.Filter.Offset + len(l.Reservations)
+}
+
+// PageURL links to page of the list keeping its filter and order
+func (l reservationList) PageURL(page int) string {
+	return l.url(l.Filter.Status, l.Filter.Sort, l.Filter.Desc, page)
+}
+
+// PrevURL links to the page before, it's empty on the first page
+func (l reservationList) PrevURL() string {
+	if l.Page <= 1 {
+		return ""
+	}
+	return l.PageURL(l.Page - 1)
+}
+
+// NextURL links to the page after, it's empty on the last page
+func (l reservationList) NextURL() string {
+	if l.Page >= l.Pages() {
+		return ""
+	}
+	return l.PageURL(l.Page + 1)
+}
+
+// SortURL links to the first page of the list sorted by sort, reversing the order if it's already sorted
+// by it
+func (l reservationList) SortURL(sort string) string {
+	desc := false
+	if sort == l.Filter.Sort {
+		desc = !l.Filter.Desc
+	}
+	return l.url(l.Filter.Status, sort, desc, 1)
+}
+
+// StatusURL links to the first page of the list showing only status, or every status if it's empty
+func (l reservationList) StatusURL(status string) string {
+	return l.url(status, l.Filter.Sort, l.Filter.Desc, 1)
+}
+
+// SortIndicator returns an arrow when the list is sorted by sort
+func (l reservationList) SortIndicator(sort string) string {
+	switch {
+	case sort != l.Filter.Sort:
+		return ""
+	case l.Filter.Desc:
+		return "\u25bc"
+	default:
+		return "\u25b2"
+	}
+}
+
+func (l reservationList) url(status, sort string, desc bool, page int) string {
+	query := url.Values{}
+	if status != "" && !l.fixedStatus {
+		query.Set("status", status)
+	}
+	if l.Filter.Search != "" {
+		query.Set("q", l.Filter.Search)
+	}
+	query.Set("sort", sort)
+	if desc {
+		query.Set("order", "desc")
+	} else {
+		query.Set("order", "asc")
+	}
+	if page > 1 {
+		query.Set("page", strconv.Itoa(page))
+	}
+	return l.Path + "?" + query.Encode()
+}
+
+func (m *Repository) AdminShowReservations(rw http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		http.Redirect(rw, r, "/admin/reservations", http.StatusSeeOther)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	m.renderAdminReservation(rw, r, forms.New(nil), res)
+}
+
+// renderAdminReservation shows the reservation page with the rooms it can be moved to
+func (m *Repository) renderAdminReservation(rw http.ResponseWriter, r *http.Request, form *forms.Form, res models.Reservation) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	// an archived room isn't offered anymore, but the reservation can stay in it
+	current := false
+	for _, room := range rooms {
+		if room.ID == res.RoomId {
+			current = true
+		}
+	}
+	if !current {
+		room := res.Room
+		room.ID = res.RoomId
+		rooms = append(rooms, room)
+	}
+
+	data := make(map[string]interface{})
+	data["referer"] = r.Referer()
+	data["reservation"] = res
+	data["rooms"] = rooms
+
+	renders.Template(rw, r, "admin-show-reservation.page.html", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// AdminPostShowReservations updates the guest of a reservation and, while it's active, its room and
+// dates, repricing the stay when they change. The guest is emailed about it on request.
+func (m *Repository) AdminPostShowReservations(rw http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "warning", "Something wrong happened!")
+		http.Redirect(rw, r, "/admin/reservations", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	validateGuestForm(form)
+
+	// inactive reservations don't hold a room so the page doesn't offer to change their stay
+	changeStay := form.Get("room_id") != "" || form.Get("start_date") != "" || form.Get("end_date") != ""
+	if changeStay {
+		form.Required("room_id", "start_date", "end_date")
+		form.IsInt("room_id", 1)
+		form.IsDate("start_date")
+		form.IsDate("end_date")
+	}
+
+	res.FirstName = form.Get("firstname")
+	res.LastName = form.Get("lastname")
+	res.Email = form.Get("email")
+	res.Phone = form.Get("phone")
+
+	if !form.Valid() {
+		m.renderAdminReservation(rw, r, form, res)
+		return
+	}
+
+	stayChanged := false
+	err = m.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		if changeStay {
+			roomID, _ := strconv.Atoi(form.Get("room_id"))
+			startDate, _ := time.Parse(forms.DateLayout, form.Get("start_date"))
+			endDate, _ := time.Parse(forms.DateLayout, form.Get("end_date"))
+
+			if roomID != res.RoomId || !startDate.Equal(res.StartDate) || !endDate.Equal(res.EndDate) {
+				var err error
+				res, err = m.moveReservation(r.Context(), repo, res, roomID, startDate, endDate)
+				if err != nil {
+					return err
+				}
+				stayChanged = true
+			}
+		}
+		return repo.UpdateReservation(r.Context(), res)
+	})
+	if err != nil {
+		m.redirectMoveError(rw, r, err, fmt.Sprintf("/admin/reservations/%d", id))
+		return
+	}
+
+	if form.Get("notify_guest") != "" {
+		m.sendReservationUpdatedMail(res)
+	}
+
+	if stayChanged {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation successfully updated, the new total is %s.", renders.FormatPrice(res.Amount)))
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "Reservation successfully updated.")
+	}
+	http.Redirect(rw, r, "/admin/reservations", http.StatusSeeOther)
+}
+
+// sendReservationUpdatedMail tells the guest what their reservation looks like after staff changed it
+func (m *Repository) sendReservationUpdatedMail(res models.Reservation) {
+	html := fmt.Sprintf(`
+		<strong>Reservation Updated</strong><br>
+		Dear %s: <br>
+		Your reservation %s is for %s from %s to %s, the total is %s.
+		`, res.FirstName, res.ConfirmationCode, res.Room.Title, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"),
+		renders.FormatPrice(res.Amount))
+
+	m.App.MailChan <- models.MailData{
+		To:      res.Email,
+		From:    "me@here.com",
+		Subject: "Reservation updated",
+		Content: html,
+	}
+}
+
+// AdminPostReservationStatus moves a reservation along its lifecycle, cancelling releases its dates
+func (m *Repository) AdminPostReservationStatus(rw http.ResponseWriter, r *http.Request) {
+
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		http.Redirect(rw, r, "/admin/reservations", http.StatusTemporaryRedirect)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		http.Redirect(rw, r, "/admin/reservations", http.StatusTemporaryRedirect)
+		return
+	}
+
+	status := r.Form.Get("status")
+	if status == models.ReservationCancelled && helpers.AccessLevel(r) < models.AccessLevelOwner {
+		m.App.Session.Put(r.Context(), "error", "Only the owner can cancel reservations!")
+		http.Redirect(rw, r, fmt.Sprintf("/admin/reservations/%d", id), http.StatusSeeOther)
+		return
+	}
+
+	if !res.CanTransitionTo(status) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("A %s reservation can't be marked as %s!", res.Status, status))
+		http.Redirect(rw, r, fmt.Sprintf("/admin/reservations/%d", id), http.StatusSeeOther)
+		return
+	}
+
+	if status == models.ReservationCancelled {
+		err = m.cancelReservation(r.Context(), id)
+	} else {
+		err = m.DB.UpdateReservationStatus(r.Context(), id, status)
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't update reservation status!")
+		http.Redirect(rw, r, fmt.Sprintf("/admin/reservations/%d", id), http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation marked as %s.", status))
+	http.Redirect(rw, r, "/admin/reservations", http.StatusSeeOther)
+}
+
+// cancelReservation marks a reservation as cancelled and frees its dates, keeping the record
+func (m *Repository) cancelReservation(ctx context.Context, id int) error {
+	return m.DB.WithTx(ctx, func(repo repository.DatabaseRepo) error {
+		err := repo.DeleteRestrictionsForReservation(ctx, id)
+		if err != nil {
+			return err
+		}
+		return repo.UpdateReservationStatus(ctx, id, models.ReservationCancelled)
+	})
+}
+
+// AdminReservationsCalender shows every room by day with its reservations and blocks, for a week, a month
+// or three months
+func (m *Repository) AdminReservationsCalender(rw http.ResponseWriter, r *http.Request) {
+	view := newCalendarView(r.URL.Query(), time.Now())
+
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	restrictions, err := m.DB.GetRestrictionsByDate(r.Context(), view.First, view.Last)
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	byRoom := make(map[int][]models.RoomRestriction)
+	for _, x := range restrictions {
+		byRoom[x.RoomId] = append(byRoom[x.RoomId], x)
+	}
+
+	data := make(map[string]interface{})
+	data["view"] = view
+	data["rooms"] = rooms
+
+	for _, x := range rooms {
+		data[fmt.Sprintf("spans_%d", x.ID)] = calendarSpans(view.First, view.Last, byRoom[x.ID])
+	}
+
+	allRestrictions, err := m.DB.AllRestrictions(r.Context())
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	data["restrictions"] = allRestrictions
+	data["block_reasons"] = models.BlockReasons
+
+	renders.Template(rw, r, "admin-reservations-calender.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+		Data: data,
+	})
+}
+
+// AdminPostReservationsCalender restricts a room for a range of days, unless any of them is reserved or
+// restricted already. Rooms are blocked by the owner unless another restriction is chosen.
+func (m *Repository) AdminPostReservationsCalender(rw http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	calendarURL := adminCalendarURL(r)
+
+	form := forms.New(r.PostForm)
+	form.Required("room_id", "start_date", "end_date")
+	form.IsInt("room_id", 1)
+	form.IsDate("start_date")
+	form.IsDate("end_date")
+	if form.Get("reason") != "" && !validBlockReason(form.Get("reason")) {
+		form.Errors.Add("reason", "choose a reason")
+	}
+
+	code := form.Get("restriction")
+	if code == "" {
+		code = models.RestrictionOwnerBlock
+	}
+
+	restriction, err := m.DB.GetRestrictionByCode(r.Context(), code)
+	if err != nil || !restriction.Blockable() {
+		form.Errors.Add("restriction", "choose a restriction")
+	}
+
+	if !form.Valid() {
+		m.App.Session.Put(r.Context(), "error", "Block is not valid!")
+		http.Redirect(rw, r, calendarURL, http.StatusSeeOther)
+		return
+	}
+
+	roomID, _ := strconv.Atoi(form.Get("room_id"))
+	startDate, _ := time.Parse(forms.DateLayout, form.Get("start_date"))
+	endDate, _ := time.Parse(forms.DateLayout, form.Get("end_date"))
+
+	if endDate.Before(startDate) {
+		m.App.Session.Put(r.Context(), "error", "Block can't end before it starts!")
+		http.Redirect(rw, r, calendarURL, http.StatusSeeOther)
+		return
+	}
+
+	restrictions, err := m.DB.GetRestrictionsForRoomByDate(r.Context(), roomID, startDate, endDate)
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	if len(restrictions) > 0 {
+		m.App.Session.Put(r.Context(), "error", takenMessage(restrictions[0]))
+		http.Redirect(rw, r, calendarURL, http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.InsertBlockForRoom(r.Context(), models.RoomRestriction{
+		RoomId:        roomID,
+		RestrictionId: restriction.ID,
+		Reason:        form.Get("reason"),
+		StartDate:     startDate,
+		EndDate:       endDate,
+	})
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.App.Session.Put(r.Context(), "error", "The room was reserved or blocked for some of those days in the meantime!")
+		http.Redirect(rw, r, calendarURL, http.StatusSeeOther)
+		return
+	} else if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Block could not be saved!")
+		http.Redirect(rw, r, calendarURL, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s added from %s to %s.", restriction.Name,
+		startDate.Format(forms.DateLayout), endDate.Format(forms.DateLayout)))
+	http.Redirect(rw, r, calendarURL, http.StatusSeeOther)
+}
+
+// AdminPostDeleteBlock removes a block from the calendar
+func (m *Repository) AdminPostDeleteBlock(rw http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	calendarURL := adminCalendarURL(r)
+
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "missing url parameter")
+		http.Redirect(rw, r, calendarURL, http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.DeleteBlockByID(r.Context(), id)
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Block could not be removed!")
+		http.Redirect(rw, r, calendarURL, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Block removed.")
+	http.Redirect(rw, r, calendarURL, http.StatusSeeOther)
+}
+
+// AdminPostMoveReservation moves a reservation to another room and/or other dates, it's what dragging a
+// reservation on the calendar posts
+func (m *Repository) AdminPostMoveReservation(rw http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	calendarURL := adminCalendarURL(r)
+
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "missing url parameter")
+		http.Redirect(rw, r, calendarURL, http.StatusSeeOther)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't find reservation!")
+		http.Redirect(rw, r, calendarURL, http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room_id", "start_date", "end_date")
+	form.IsInt("room_id", 1)
+	form.IsDate("start_date")
+	form.IsDate("end_date")
+
+	if !form.Valid() {
+		m.App.Session.Put(r.Context(), "error", "Move is not valid!")
+		http.Redirect(rw, r, calendarURL, http.StatusSeeOther)
+		return
+	}
+
+	roomID, _ := strconv.Atoi(form.Get("room_id"))
+	startDate, _ := time.Parse(forms.DateLayout, form.Get("start_date"))
+	endDate, _ := time.Parse(forms.DateLayout, form.Get("end_date"))
+
+	res, err = m.moveReservation(r.Context(), m.DB, res, roomID, startDate, endDate)
+	if err != nil {
+		m.redirectMoveError(rw, r, err, calendarURL)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation moved to %s from %s to %s, the new total is %s.",
+		res.Room.Title, startDate.Format(forms.DateLayout), endDate.Format(forms.DateLayout), renders.FormatPrice(res.Amount)))
+	http.Redirect(rw, r, calendarURL, http.StatusSeeOther)
+}
+
+// errReservationNotActive is returned when moving a reservation that no longer holds a room
+var errReservationNotActive = errors.New("reservation is not active")
+
+// moveReservation prices the stay of res in room roomID from start to end and moves it there through repo,
+// which can be a transaction
+func (m *Repository) moveReservation(ctx context.Context, repo repository.DatabaseRepo, res models.Reservation, roomID int, start, end time.Time) (models.Reservation, error) {
+	if !res.Active() {
+		return res, errReservationNotActive
+	}
+
+	room, err := repo.GetRoomById(ctx, roomID)
+	if err != nil {
+		return res, err
+	}
+
+	quote, err := m.quoteStay(ctx, room, start, end)
+	if err != nil {
+		return res, err
+	}
+
+	res.RoomId = roomID
+	res.Room = room
+	res.StartDate = start
+	res.EndDate = end
+	res.Amount = quote.Total
+
+	return res, repo.MoveReservation(ctx, res)
+}
+
+// redirectMoveError sends staff back to url explaining why a reservation couldn't be moved or edited
+func (m *Repository) redirectMoveError(rw http.ResponseWriter, r *http.Request, err error, url string) {
+	switch {
+	case isQuoteError(err):
+		m.redirectInvalidQuote(rw, r, err, url)
+		return
+	case errors.Is(err, errReservationNotActive):
+		m.App.Session.Put(r.Context(), "error", "Only pending, confirmed or checked in reservations can be moved!")
+	case errors.Is(err, repository.ErrRoomNotAvailable):
+		m.App.Session.Put(r.Context(), "error", "The room is not available for those dates!")
+	default:
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Reservation could not be changed, nothing was changed!")
+	}
+	http.Redirect(rw, r, url, http.StatusSeeOther)
+}
+
+// adminCalendarURL returns to the range of the calendar a form was posted from
+func adminCalendarURL(r *http.Request) string {
+	if r.Form.Get("view") == "" && r.Form.Get("start") == "" {
+		return "/admin/reservations-calender"
+	}
+	return fmt.Sprintf("/admin/reservations-calender?view=%s&start=%s", url.QueryEscape(r.Form.Get("view")), url.QueryEscape(r.Form.Get("start")))
+}
+
+// Ranges the reservations calendar can show
+const (
+	calendarWeek    = "week"
+	calendarMonth   = "month"
+	calendarQuarter = "3months"
+)
+
+// calendarQuarterMonths is how many months calendarQuarter shows
+const calendarQuarterMonths = 3
+
+// calendarView is the range of days shown on the reservations calendar
+type calendarView struct {
+	Name  string // calendarWeek, calendarMonth or calendarQuarter
+	First time.Time
+	Last  time.Time
+	Prev  time.Time // first day of the range before
+	Next  time.Time // first day of the range after
+}
+
+// newCalendarView reads the range from the view and start parameters, a week starts on the Monday of
+// start and the other ranges on the first of its month. Without start the range includes today, y and m
+// are still understood for links to the old monthly calendar.
+func newCalendarView(query url.Values, today time.Time) calendarView {
+	view := calendarView{Name: query.Get("view")}
+	if view.Name != calendarWeek && view.Name != calendarQuarter {
+		view.Name = calendarMonth
+	}
+
+	start := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	if date, err := time.Parse(forms.DateLayout, query.Get("start")); err == nil {
+		start = date
+	} else if year, err := strconv.Atoi(query.Get("y")); err == nil {
+		month, _ := strconv.Atoi(query.Get("m"))
+		if month >= 1 && month <= 12 {
+			start = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+		}
+	}
+
+	switch view.Name {
+	case calendarWeek:
+		view.First = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+		view.Last = view.First.AddDate(0, 0, 6)
+		view.Prev = view.First.AddDate(0, 0, -7)
+		view.Next = view.First.AddDate(0, 0, 7)
+	case calendarQuarter:
+		view.First = time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC)
+		view.Last = view.First.AddDate(0, calendarQuarterMonths, -1)
+		view.Prev = view.First.AddDate(0, -calendarQuarterMonths, 0)
+		view.Next = view.First.AddDate(0, calendarQuarterMonths, 0)
+	default:
+		view.First = time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC)
+		view.Last = view.First.AddDate(0, 1, -1)
+		view.Prev = view.First.AddDate(0, -1, 0)
+		view.Next = view.First.AddDate(0, 1, 0)
+	}
+
+	return view
+}
+
+// Title names the range, like "January 2050" or "3 Jan - 9 Jan 2050"
+func (v calendarView) Title() string {
+	switch {
+	case v.Name == calendarWeek:
+		return fmt.Sprintf("%s - %s", v.First.Format("2 Jan"), v.Last.Format("2 Jan 2006"))
+	case v.Name == calendarMonth:
+		return v.First.Format("January 2006")
+	case v.First.Year() == v.Last.Year():
+		return fmt.Sprintf("%s - %s", v.First.Format("January"), v.Last.Format("January 2006"))
+	default:
+		return fmt.Sprintf("%s - %s", v.First.Format("January 2006"), v.Last.Format("January 2006"))
+	}
+}
+
+// Days returns every day of the range
+func (v calendarView) Days() []time.Time {
+	var days []time.Time
+	for d := v.First; !d.After(v.Last); d = d.AddDate(0, 0, 1) {
+		days = append(days, d)
+	}
+	return days
+}
+
+// Months splits the range into the parts falling in each month, as spans of free days
+func (v calendarView) Months() []calendarSpan {
+	var months []calendarSpan
+	for _, d := range v.Days() {
+		if n := len(months); n > 0 && months[n-1].Start.Month() == d.Month() {
+			months[n-1].Days++
+			continue
+		}
+		months = append(months, calendarSpan{Start: d, Days: 1})
+	}
+	return months
+}
+
+// calendarSpan is a run of days on the calendar row of a room that are taken by the same reservation or
+// block, or a single free day
+type calendarSpan struct {
+	Start       time.Time
+	Days        int
+	Restriction models.RoomRestriction // zero for a free day
+}
+
+// Kind tells how the days of the span are taken: free, reservation, external or block
+func (s calendarSpan) Kind() string {
+	switch {
+	case s.Restriction.ID == 0:
+		return "free"
+	case s.Restriction.ReservationId > 0:
+		return "reservation"
+	case s.Restriction.Restriction.Code == models.RestrictionExternalCalendar:
+		return "external"
+	default:
+		return "block"
+	}
+}
+
+// calendarSpans splits the days from first through last into spans. Only restrictions that don't block
+// availability can overlap others, a day shows the restriction blocking it if there is one.
+func calendarSpans(first, last time.Time, restrictions []models.RoomRestriction) []calendarSpan {
+	var spans []calendarSpan
+
+	for d := first; !d.After(last); d = d.AddDate(0, 0, 1) {
+		day := d.Format(forms.DateLayout)
+
+		var taken models.RoomRestriction
+		for _, y := range restrictions {
+			if day < y.StartDate.Format(forms.DateLayout) || day > y.EndDate.Format(forms.DateLayout) {
+				continue
+			}
+			if taken.ID == 0 || y.Restriction.BlocksAvailability {
+				taken = y
+			}
+		}
+
+		if n := len(spans); n > 0 && taken.ID != 0 && spans[n-1].Restriction.ID == taken.ID {
+			spans[n-1].Days++
+			continue
+		}
+
+		spans = append(spans, calendarSpan{Start: d, Days: 1, Restriction: taken})
+	}
+
+	return spans
+}
+
+// takenMessage explains why a room can't be blocked on the days of restriction
+func takenMessage(restriction models.RoomRestriction) string {
+	dates := fmt.Sprintf("from %s to %s", restriction.StartDate.Format(forms.DateLayout), restriction.EndDate.Format(forms.DateLayout))
+
+	switch {
+	case restriction.ReservationId > 0:
+		return fmt.Sprintf("The room is reserved by %s %s %s!", restriction.Reservation.FirstName, restriction.Reservation.LastName, dates)
+	case restriction.Restriction.Code == models.RestrictionExternalCalendar:
+		return fmt.Sprintf("The room is booked on another site %s!", dates)
+	case restriction.Restriction.Code != models.RestrictionOwnerBlock && restriction.Restriction.Name != "":
+		return fmt.Sprintf("The room already has a %s %s!", strings.ToLower(restriction.Restriction.Name), dates)
+	default:
+		return fmt.Sprintf("The room is already blocked %s!", dates)
+	}
+}
+
+func validBlockReason(reason string) bool {
+	for _, r := range models.BlockReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminRooms lists every room, including archived ones
+func (m *Repository) AdminRooms(rw http.ResponseWriter, r *http.Request) {
+	data := make(map[string]interface{})
+	rooms, err := m.DB.AllRoomsWithArchived(r.Context())
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+	data["rooms"] = rooms
+	renders.Template(rw, r, "admin-rooms.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+		Data: data,
+	})
+}
+
+// AdminNewRoom shows the form to create a room
+func (m *Repository) AdminNewRoom(rw http.ResponseWriter, r *http.Request) {
+	data := make(map[string]interface{})
+	data["room"] = models.Room{}
+	renders.Template(rw, r, "admin-room.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+		Data: data,
+	})
+}
+
+// AdminPostNewRoom creates a room
+func (m *Repository) AdminPostNewRoom(rw http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	models.ValidateRoomForm(form)
+
+	var room models.Room
+	models.RoomFromForm(&room, form)
+
+	if !form.Valid() {
+		data := make(map[string]interface{})
+		data["room"] = room
+		renders.Template(rw, r, "admin-room.page.html", &models.TemplateData{
+			Form: form,
+			Data: data,
+		})
+		return
+	}
+
+	_, err = m.DB.InsertRoom(r.Context(), room)
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room successfully created.")
+	http.Redirect(rw, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminShowRoom shows the form to edit a room
+func (m *Repository) AdminShowRoom(rw http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		http.Redirect(rw, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(r.Context(), id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't find room!")
+		http.Redirect(rw, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	rates, err := m.DB.GetRatesForRoom(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	sources, err := m.DB.GetCalendarSourcesForRoom(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["rates"] = rates
+	data["calendar_sources"] = sources
+
+	stringMap := make(map[string]string)
+	stringMap["calendar_url"] = m.calendarURL(room)
+
+	renders.Template(rw, r, "admin-room.page.html", &models.TemplateData{
+		Form:      forms.New(nil),
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// AdminPostShowRoom renames a room
+func (m *Repository) AdminPostShowRoom(rw http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		http.Redirect(rw, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(r.Context(), id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't find room!")
+		http.Redirect(rw, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	models.ValidateRoomForm(form)
+
+	room.ID = id
+	models.RoomFromForm(&room, form)
+
+	if !form.Valid() {
+		data := make(map[string]interface{})
+		data["room"] = room
+		renders.Template(rw, r, "admin-room.page.html", &models.TemplateData{
+			Form: form,
+			Data: data,
+		})
+		return
+	}
+
+	err = m.DB.UpdateRoom(r.Context(), room)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't update room!")
+		http.Redirect(rw, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room successfully updated.")
+	http.Redirect(rw, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminPostArchiveRoom retires a room so it can no longer be booked
+func (m *Repository) AdminPostArchiveRoom(rw http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "invalid room id")
+		http.Redirect(rw, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.ArchiveRoom(r.Context(), id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't archive room!")
+		http.Redirect(rw, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room successfully archived.")
+	http.Redirect(rw, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminPostRoomRate adds a seasonal rate to a room
+func (m *Repository) AdminPostRoomRate(rw http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	roomID, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		http.Redirect(rw, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	roomURL := fmt.Sprintf("/admin/rooms/%d", roomID)
+
+	form := forms.New(r.PostForm)
+	form.Required("start_date", "end_date", "nightly_rate")
+	form.IsPrice("nightly_rate")
+	if form.Get("min_stay") != "" {
+		form.IsInt("min_stay", 0)
+	}
+
+	layout := "2006-01-02"
+	startDate, errStart := time.Parse(layout, r.Form.Get("start_date"))
+	endDate, errEnd := time.Parse(layout, r.Form.Get("end_date"))
+
+	if !form.Valid() || errStart != nil || errEnd != nil || endDate.Before(startDate) {
+		m.App.Session.Put(r.Context(), "error", "Seasonal rate is not valid!")
+		http.Redirect(rw, r, roomURL, http.StatusSeeOther)
+		return
+	}
+
+	rate := models.RoomRate{
+		RoomID:    roomID,
+		StartDate: startDate,
+		EndDate:   endDate,
+	}
+	rate.NightlyRate, _ = forms.ParsePrice(r.Form.Get("nightly_rate"))
+	rate.MinStay, _ = strconv.Atoi(r.Form.Get("min_stay"))
+
+	err = m.DB.InsertRoomRate(r.Context(), rate)
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Seasonal rate successfully added.")
+	http.Redirect(rw, r, roomURL, http.StatusSeeOther)
+}
+
+// AdminPostDeleteRoomRate removes a seasonal rate from a room
+func (m *Repository) AdminPostDeleteRoomRate(rw http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	roomID, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "invalid room id")
+		http.Redirect(rw, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	roomURL := fmt.Sprintf("/admin/rooms/%d", roomID)
+
+	id, err := strconv.Atoi(exploded[5])
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "invalid rate id")
+		http.Redirect(rw, r, roomURL, http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.DeleteRoomRate(r.Context(), roomID, id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't delete seasonal rate!")
+		http.Redirect(rw, r, roomURL, http.StatusSeeOther)
+		return
+	}
 
+	m.App.Session.Put(r.Context(), "flash", "Seasonal rate successfully deleted.")
+	http.Redirect(rw, r, roomURL, http.StatusSeeOther)
 }
@@ -1,10 +1,19 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"github.com/amiranbari/bookings/internal/forms"
+	"github.com/amiranbari/bookings/internal/repository/dbrepo"
+	"github.com/amiranbari/bookings/internal/tokens"
+	"github.com/amiranbari/bookings/internal/totp"
 	"github.com/amiranbari/bookings/pkg/models"
+	"html"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -33,8 +42,28 @@ var theTests = []struct {
 	{"dashboard", "/admin/dashboard", http.StatusOK},
 	{"admin-reservations", "/admin/reservations", http.StatusOK},
 	{"admin-new-reservations", "/admin/new-reservations", http.StatusOK},
+	{"admin-reservations-page", "/admin/reservations?status=pending&q=smith&sort=amount&order=asc&page=2", http.StatusOK},
+	{"admin-new-reservations-page", "/admin/new-reservations?sort=name&order=desc&page=3", http.StatusOK},
+	{"admin-reservations-search-error", "/admin/reservations?q=error", http.StatusInternalServerError},
 	{"admin-show-reservation", "/admin/reservations/1", http.StatusOK},
 	{"admin-fail-reservation", "/admin/reservations/non-roomID", http.StatusOK},
+	{"admin-rooms", "/admin/rooms", http.StatusOK},
+	{"admin-new-room", "/admin/rooms/new", http.StatusOK},
+	{"admin-show-room", "/admin/rooms/1", http.StatusOK},
+	{"my-reservation", "/my-reservation", http.StatusOK},
+	{"forgot-password", "/forgot-password", http.StatusOK},
+	{"reset-password-without-token", "/reset-password", http.StatusOK},
+	{"login-two-factor-without-password", "/login/two-factor", http.StatusOK},
+	{"admin-users", "/admin/users", http.StatusOK},
+	{"admin-logins", "/admin/logins", http.StatusOK},
+	{"admin-api-keys", "/admin/api-keys", http.StatusOK},
+	{"admin-import", "/admin/import", http.StatusOK},
+	{"admin-calendar-week", "/admin/reservations-calender?view=week&start=2050-01-05", http.StatusOK},
+	{"admin-calendar-3months", "/admin/reservations-calender?view=3months&start=2050-11-20", http.StatusOK},
+	{"admin-restrictions", "/admin/restrictions", http.StatusOK},
+	{"admin-new-restriction", "/admin/restrictions/new", http.StatusOK},
+	{"admin-show-restriction", "/admin/restrictions/4", http.StatusOK},
+	{"openapi", "/api/openapi.json", http.StatusOK},
 }
 
 func TestHandlers(t *testing.T) {
@@ -123,6 +152,23 @@ func TestRepository_Reservation(t *testing.T) {
 		t.Errorf("Reservation Handler return wrong response code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
 	}
 
+	//test with a stay shorter than the seasonal minimum
+	req, _ = http.NewRequest("GET", "/make-reservation", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+	reservation.RoomId = 1
+	reservation.StartDate, _ = time.Parse("2006-01-02", "2070-01-01")
+	reservation.EndDate, _ = time.Parse("2006-01-02", "2070-01-02")
+	session.Put(ctx, "reservation", reservation)
+
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("Reservation Handler return wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+	reservation.StartDate = time.Time{}
+	reservation.EndDate = time.Time{}
+
 	//test with existing room
 	req, _ = http.NewRequest("GET", "/make-reservation", nil)
 	ctx = getCtx(req)
@@ -202,8 +248,8 @@ func TestRepository_PostReservation(t *testing.T) {
 
 	handler = http.HandlerFunc(Repo.PostReservation)
 	handler.ServeHTTP(rr, req)
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("PostReservation Handler return wrong response code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("PostReservation Handler return wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
 
 	//test missing session
@@ -246,7 +292,7 @@ func TestRepository_PostReservation(t *testing.T) {
 		t.Errorf("PostReservation Handler return wrong response code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
 	}
 
-	//error in inserting restriction
+	//error in finding room
 	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
 	ctx = getCtx(req)
 	req = req.WithContext(ctx)
@@ -263,6 +309,54 @@ func TestRepository_PostReservation(t *testing.T) {
 	if rr.Code != http.StatusTemporaryRedirect {
 		t.Errorf("PostReservation Handler return wrong response code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
 	}
+
+	//room taken by another guest in the meantime
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+
+	reservation.RoomId = 1
+	reservation.StartDate, _ = time.Parse("2006-01-02", "2060-01-01")
+	reservation.EndDate, _ = time.Parse("2006-01-02", "2060-01-03")
+	session.Put(ctx, "reservation", reservation)
+
+	handler = http.HandlerFunc(Repo.PostReservation)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("PostReservation Handler return wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	actualLoc, _ := rr.Result().Location()
+	if actualLoc.String() != "/search" {
+		t.Errorf("PostReservation Handler redirected to %s, wanted /search", actualLoc.String())
+	}
+
+	//stay shorter than the seasonal minimum
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+
+	reservation.StartDate, _ = time.Parse("2006-01-02", "2070-01-01")
+	reservation.EndDate, _ = time.Parse("2006-01-02", "2070-01-03")
+	session.Put(ctx, "reservation", reservation)
+
+	handler = http.HandlerFunc(Repo.PostReservation)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("PostReservation Handler return wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	if msg := session.GetString(ctx, "error"); !strings.Contains(msg, "minimum stay of 7 nights") {
+		t.Errorf("PostReservation Handler set wrong error message: %s", msg)
+	}
 }
 
 func TestRepository_PostSearch(t *testing.T) {
@@ -355,6 +449,52 @@ func TestRepository_PostSearch(t *testing.T) {
 
 }
 
+var postSearchFilterTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+}{
+	{
+		"fits-guests-and-amenities",
+		url.Values{"start_date": {"2040-02-01"}, "end_date": {"2040-02-03"}, "guests": {"2"}, "amenities": {"wifi"}},
+		http.StatusOK,
+	},
+	{
+		"too-many-guests",
+		url.Values{"start_date": {"2040-02-01"}, "end_date": {"2040-02-03"}, "guests": {"5"}},
+		http.StatusSeeOther,
+	},
+	{
+		"missing-amenity",
+		url.Values{"start_date": {"2040-02-01"}, "end_date": {"2040-02-03"}, "amenities": {"wifi", "sauna"}},
+		http.StatusSeeOther,
+	},
+	{
+		"invalid-guests",
+		url.Values{"start_date": {"2040-02-01"}, "end_date": {"2040-02-03"}, "guests": {"zero"}},
+		http.StatusTemporaryRedirect,
+	},
+}
+
+func TestRepository_PostSearchFilters(t *testing.T) {
+	for _, e := range postSearchFilterTests {
+		req, _ := http.NewRequest("POST", "/search", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostSearch)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: PostSearch Handler return wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
 func TestRepository_Search(t *testing.T) {
 
 	req, _ := http.NewRequest("POST", "/search", nil)
@@ -441,6 +581,13 @@ var loginTests = []struct {
 		"",
 		"/",
 	},
+	{
+		"mixed-case-stored-email",
+		"mixed.case@gmail.com",
+		http.StatusSeeOther,
+		"",
+		"/",
+	},
 	{
 		"invalid-credentials",
 		"me@gmail.com",
@@ -496,6 +643,70 @@ func TestLogin(t *testing.T) {
 	}
 }
 
+func TestLoginLockout(t *testing.T) {
+	tests := []struct {
+		name       string
+		email      string
+		remoteAddr string
+		loggedIn   bool
+	}{
+		{"free-account", "admin@gmail.com", "10.0.0.1:5000", true},
+		{"locked-account", "locked@gmail.com", "10.0.0.1:5000", false},
+		{"locked-account-other-case", "Locked@Gmail.com", "10.0.0.1:5000", false},
+		{"locked-ip", "admin@gmail.com", "10.0.0.66:5000", false},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("email", e.email)
+		postedData.Add("password", "12345678")
+
+		req, _ := http.NewRequest("POST", "/login", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RemoteAddr = e.remoteAddr
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostLogin).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected %d but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		if loggedIn := session.Exists(ctx, "user_id"); loggedIn != e.loggedIn {
+			t.Errorf("%s: expected logged in to be %v but got %v", e.name, e.loggedIn, loggedIn)
+		}
+
+		if !e.loggedIn && !strings.Contains(session.GetString(ctx, "error"), "Too many failed login attempts") {
+			t.Errorf("%s: expected lockout message but got %q", e.name, session.GetString(ctx, "error"))
+		}
+	}
+}
+
+func TestAdminPostUnlockLogin(t *testing.T) {
+	for _, email := range []string{"locked@gmail.com", ""} {
+		postedData := url.Values{}
+		postedData.Add("email", email)
+
+		req, _ := http.NewRequest("POST", "/admin/logins/unlock", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostUnlockLogin).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/admin/logins" {
+			t.Errorf("unlocking %q: got %d to %s", email, rr.Code, rr.Header().Get("Location"))
+		}
+
+		if unlocked := session.GetString(ctx, "flash") != ""; unlocked != (email != "") {
+			t.Errorf("unlocking %q: expected unlocked to be %v", email, email != "")
+		}
+	}
+}
+
 var adminPostShowReservationTests = []struct {
 	url                string
 	name               string
@@ -508,10 +719,10 @@ var adminPostShowReservationTests = []struct {
 		"/admin/reservations/1",
 		"valid data",
 		url.Values{
-			"first_name": {"Amir"},
-			"last_name":  {"Anbari"},
-			"email":      {"amir@anbari.com"},
-			"phone":      {"555-555-5555"},
+			"firstname": {"Amir"},
+			"lastname":  {"Anbari"},
+			"email":     {"amir@anbari.com"},
+			"phone":     {"555-555-5555"},
 		},
 		http.StatusSeeOther,
 		"",
@@ -521,15 +732,123 @@ var adminPostShowReservationTests = []struct {
 		"/admin/reservations/2",
 		"invalid-roomID",
 		url.Values{
-			"first_name": {"Amir"},
-			"last_name":  {"Anbari"},
+			"firstname": {"Amir"},
+			"lastname":  {"Anbari"},
+			"email":     {"amir@anbari.com"},
+			"phone":     {"555-555-5555"},
+		},
+		http.StatusSeeOther,
+		"",
+		"/admin/reservations",
+	},
+	{
+		"/admin/reservations/1",
+		"move-and-notify",
+		url.Values{
+			"firstname":    {"Amir"},
+			"lastname":     {"Anbari"},
+			"email":        {"amir@anbari.com"},
+			"phone":        {"555-555-5555"},
+			"room_id":      {"1"},
+			"start_date":   {"2050-01-03"},
+			"end_date":     {"2050-01-05"},
+			"notify_guest": {"1"},
+		},
+		http.StatusSeeOther,
+		"",
+		"/admin/reservations",
+	},
+	{
+		"/admin/reservations/1",
+		"same-stay",
+		url.Values{
+			"firstname":  {"Amir"},
+			"lastname":   {"Anbari"},
 			"email":      {"amir@anbari.com"},
 			"phone":      {"555-555-5555"},
+			"room_id":    {"1"},
+			"start_date": {"2050-01-01"},
+			"end_date":   {"2050-01-02"},
 		},
 		http.StatusSeeOther,
 		"",
 		"/admin/reservations",
 	},
+	{
+		"/admin/reservations/1",
+		"missing-email",
+		url.Values{
+			"firstname": {"Amir"},
+			"lastname":  {"Anbari"},
+			"phone":     {"555-555-5555"},
+		},
+		http.StatusOK,
+		"email cannot be blank",
+		"",
+	},
+	{
+		"/admin/reservations/1",
+		"missing-departure",
+		url.Values{
+			"firstname":  {"Amir"},
+			"lastname":   {"Anbari"},
+			"email":      {"amir@anbari.com"},
+			"phone":      {"555-555-5555"},
+			"room_id":    {"1"},
+			"start_date": {"2050-01-03"},
+		},
+		http.StatusOK,
+		"end_date cannot be blank",
+		"",
+	},
+	{
+		"/admin/reservations/1",
+		"room-not-available",
+		url.Values{
+			"firstname":  {"Amir"},
+			"lastname":   {"Anbari"},
+			"email":      {"amir@anbari.com"},
+			"phone":      {"555-555-5555"},
+			"room_id":    {"1"},
+			"start_date": {"2060-01-01"},
+			"end_date":   {"2060-01-02"},
+		},
+		http.StatusSeeOther,
+		"",
+		"/admin/reservations/1",
+	},
+	{
+		"/admin/reservations/1",
+		"min-stay",
+		url.Values{
+			"firstname":  {"Amir"},
+			"lastname":   {"Anbari"},
+			"email":      {"amir@anbari.com"},
+			"phone":      {"555-555-5555"},
+			"room_id":    {"1"},
+			"start_date": {"2070-01-01"},
+			"end_date":   {"2070-01-02"},
+		},
+		http.StatusSeeOther,
+		"",
+		"/admin/reservations/1",
+	},
+	{
+		"/admin/reservations/3",
+		"cancelled-stay",
+		url.Values{
+			"firstname":  {"Amir"},
+			"lastname":   {"Anbari"},
+			"email":      {"amir@anbari.com"},
+			"phone":      {"555-555-5555"},
+			"room_id":    {"1"},
+			"start_date": {"2050-01-03"},
+			"end_date":   {"2050-01-05"},
+		},
+		http.StatusSeeOther,
+		"",
+		"/admin/reservations/3",
+	},
 }
 
 func TestAdminPostShowReservations(t *testing.T) {
@@ -568,178 +887,1880 @@ func TestAdminPostShowReservations(t *testing.T) {
 	}
 }
 
-func TestAdminPutShowReservations(t *testing.T) {
-	req, _ := http.NewRequest("GET", "/admin/reservations/1/processed", nil)
-	ctx := getCtx(req)
-	req = req.WithContext(ctx)
-	req.RequestURI = "/admin/reservations/1/processed"
-
-	rr := httptest.NewRecorder()
-
-	handler := http.HandlerFunc(Repo.AdminPutShowReservations)
-
-	handler.ServeHTTP(rr, req)
-	if rr.Code != http.StatusSeeOther {
-		t.Errorf("AdminPutShowReservations Handler return wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
-	}
+var adminReservationStatusTests = []struct {
+	name               string
+	url                string
+	status             string
+	accessLevel        int
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"confirm-pending", "/admin/reservations/4/status", "confirmed", models.AccessLevelStaff, http.StatusSeeOther, "/admin/reservations"},
+	{"owner-cancel-pending", "/admin/reservations/4/status", "cancelled", models.AccessLevelOwner, http.StatusSeeOther, "/admin/reservations"},
+	{"staff-cancel-pending", "/admin/reservations/4/status", "cancelled", models.AccessLevelStaff, http.StatusSeeOther, "/admin/reservations/4"},
+	{"check-in-confirmed", "/admin/reservations/1/status", "checked-in", models.AccessLevelStaff, http.StatusSeeOther, "/admin/reservations"},
+	{"check-out-pending", "/admin/reservations/4/status", "checked-out", models.AccessLevelStaff, http.StatusSeeOther, "/admin/reservations/4"},
+	{"reopen-cancelled", "/admin/reservations/3/status", "pending", models.AccessLevelOwner, http.StatusSeeOther, "/admin/reservations/3"},
+	{"unknown-status", "/admin/reservations/4/status", "deleted", models.AccessLevelOwner, http.StatusSeeOther, "/admin/reservations/4"},
+	{"invalid-reservation-id", "/admin/reservations/invalid-ID/status", "confirmed", models.AccessLevelStaff, http.StatusTemporaryRedirect, "/admin/reservations"},
+	{"missing-reservation", "/admin/reservations/2/status", "confirmed", models.AccessLevelStaff, http.StatusTemporaryRedirect, "/admin/reservations"},
+}
 
-	//invalid-reservation ID
-	req, _ = http.NewRequest("GET", "/admin/reservations/invalid-ID/processed", nil)
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-	req.RequestURI = "/admin/reservations/invalid-ID/processed"
+func TestAdminPostReservationStatus(t *testing.T) {
+	for _, e := range adminReservationStatusTests {
+		postedData := url.Values{}
+		postedData.Add("status", e.status)
 
-	rr = httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "access_level", e.accessLevel)
 
-	handler.ServeHTTP(rr, req)
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("AdminPutShowReservations Handler return wrong response code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
-	}
+		rr := httptest.NewRecorder()
 
-	//invalid-reservation ID in database
-	req, _ = http.NewRequest("GET", "/admin/reservations/2/processed", nil)
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-	req.RequestURI = "/admin/reservations/2/processed"
+		handler := http.HandlerFunc(Repo.AdminPostReservationStatus)
+		handler.ServeHTTP(rr, req)
 
-	rr = httptest.NewRecorder()
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminPostReservationStatus Handler return wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
 
-	handler.ServeHTTP(rr, req)
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("AdminPutShowReservations Handler return wrong response code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+		if rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: AdminPostReservationStatus Handler redirected to %s, wanted %s", e.name, rr.Header().Get("Location"), e.expectedLocation)
+		}
 	}
 }
 
-func TestAdminDeleteReservation(t *testing.T) {
-	req, _ := http.NewRequest("GET", "/admin/reservations/1/delete", nil)
-	ctx := getCtx(req)
-	req = req.WithContext(ctx)
-	req.RequestURI = "/admin/reservations/1/delete"
-
-	rr := httptest.NewRecorder()
-
-	handler := http.HandlerFunc(Repo.AdminDeleteReservation)
-
-	handler.ServeHTTP(rr, req)
-	if rr.Code != http.StatusSeeOther {
-		t.Errorf("AdminDeleteReservation Handler return wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
-	}
-
-	//invalid-reservation ID
-	req, _ = http.NewRequest("GET", "/admin/reservations/invalid-ID/delete", nil)
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-	req.RequestURI = "/admin/reservations/invalid-ID/delete"
-
-	rr = httptest.NewRecorder()
-
-	handler.ServeHTTP(rr, req)
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("AdminDeleteReservation Handler return wrong response code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
-	}
+func TestAdminReservationsStatusFilter(t *testing.T) {
+	for _, status := range []string{"", "cancelled", "unknown"} {
+		req, _ := http.NewRequest("GET", "/admin/reservations?status="+status, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
 
-	//invalid-reservation ID in database
-	req, _ = http.NewRequest("GET", "/admin/reservations/2/delete", nil)
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-	req.RequestURI = "/admin/reservations/2/delete"
+		rr := httptest.NewRecorder()
 
-	rr = httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminReservations)
+		handler.ServeHTTP(rr, req)
 
-	handler.ServeHTTP(rr, req)
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("AdminDeleteReservation Handler return wrong response code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+		if rr.Code != http.StatusOK {
+			t.Errorf("AdminReservations Handler with status %q return wrong response code: got %d, wanted %d", status, rr.Code, http.StatusOK)
+		}
 	}
 }
 
-var adminPostReservationCalendarTests = []struct {
-	name                 string
-	postedData           url.Values
-	expectedResponseCode int
-	expectedLocation     string
-	expectedHTML         string
-	blocks               int
-	reservations         int
-}{
-	{
-		name: "cal",
+var adminPostRoomTests = []struct {
+	name               string
+	url                string
+	handler            func(*Repository, http.ResponseWriter, *http.Request)
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{
+		"new-room",
+		"/admin/rooms/new",
+		(*Repository).AdminPostNewRoom,
+		url.Values{
+			"title":         {"Sea view suite"},
+			"description":   {"Top floor suite facing the sea"},
+			"max_occupancy": {"4"},
+			"nightly_rate":  {"180.00"},
+			"amenities":     {"wifi, sea view"},
+		},
+		http.StatusSeeOther,
+		"/admin/rooms",
+	},
+	{
+		"new-room-invalid",
+		"/admin/rooms/new",
+		(*Repository).AdminPostNewRoom,
+		url.Values{
+			"title":         {"a"},
+			"max_occupancy": {"0"},
+			"nightly_rate":  {"free"},
+		},
+		http.StatusOK,
+		"",
+	},
+	{
+		"update-room",
+		"/admin/rooms/1",
+		(*Repository).AdminPostShowRoom,
+		url.Values{
+			"title":         {"Garden room"},
+			"max_occupancy": {"2"},
+			"nightly_rate":  {"95"},
+		},
+		http.StatusSeeOther,
+		"/admin/rooms",
+	},
+	{
+		"update-room-invalid",
+		"/admin/rooms/1",
+		(*Repository).AdminPostShowRoom,
+		url.Values{"title": {""}},
+		http.StatusOK,
+		"",
+	},
+	{
+		"update-missing-room",
+		"/admin/rooms/3",
+		(*Repository).AdminPostShowRoom,
+		url.Values{
+			"title":         {"Garden room"},
+			"max_occupancy": {"2"},
+			"nightly_rate":  {"95"},
+		},
+		http.StatusSeeOther,
+		"/admin/rooms",
+	},
+}
+
+func TestAdminPostRooms(t *testing.T) {
+	for _, e := range adminPostRoomTests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+func TestAdminPostArchiveRoom(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/admin/rooms/1/archive", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.RequestURI = "/admin/rooms/1/archive"
+
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminPostArchiveRoom)
+
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminPostArchiveRoom Handler return wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	//invalid room ID in database
+	req, _ = http.NewRequest("POST", "/admin/rooms/2/archive", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.RequestURI = "/admin/rooms/2/archive"
+
+	rr = httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminPostArchiveRoom Handler return wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	if msg := session.GetString(ctx, "error"); msg != "can't archive room!" {
+		t.Errorf("expected the archive error to be shown but got %q", msg)
+	}
+}
+
+func TestAdminRoomRates(t *testing.T) {
+	postedData := url.Values{
+		"start_date":   {"2050-07-01"},
+		"end_date":     {"2050-08-31"},
+		"nightly_rate": {"150"},
+		"min_stay":     {"3"},
+	}
+
+	req, _ := http.NewRequest("POST", "/admin/rooms/1/rates", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.RequestURI = "/admin/rooms/1/rates"
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminPostRoomRate)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminPostRoomRate Handler return wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	if msg := session.GetString(ctx, "flash"); msg == "" {
+		t.Error("AdminPostRoomRate Handler did not add the rate")
+	}
+
+	//season ending before it starts
+	postedData.Set("end_date", "2050-06-01")
+
+	req, _ = http.NewRequest("POST", "/admin/rooms/1/rates", strings.NewReader(postedData.Encode()))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.RequestURI = "/admin/rooms/1/rates"
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if msg := session.GetString(ctx, "error"); msg == "" {
+		t.Error("AdminPostRoomRate Handler accepted an invalid season")
+	}
+
+	//delete rate
+	req, _ = http.NewRequest("POST", "/admin/rooms/1/rates/1/delete", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.RequestURI = "/admin/rooms/1/rates/1/delete"
+
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.AdminPostDeleteRoomRate)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminPostDeleteRoomRate Handler return wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	//delete missing rate
+	req, _ = http.NewRequest("POST", "/admin/rooms/1/rates/2/delete", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.RequestURI = "/admin/rooms/1/rates/2/delete"
+
+	rr = httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminPostDeleteRoomRate Handler return wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	if msg := session.GetString(ctx, "error"); msg != "can't delete seasonal rate!" {
+		t.Errorf("expected the delete error to be shown but got %q", msg)
+	}
+}
+
+var adminPostReservationCalendarTests = []struct {
+	name                 string
+	postedData           url.Values
+	expectedResponseCode int
+	expectedLocation     string
+	expectedKey          string
+	expectedMessage      string
+}{
+	{
+		name: "block",
 		postedData: url.Values{
-			"year":  {time.Now().Format("2006")},
-			"month": {time.Now().Format("01")},
-			fmt.Sprintf("add_block_1_%s", time.Now().AddDate(0, 0, 2).Format("2006-01-2")): {"1"},
+			"view":       {"week"},
+			"start":      {"2050-01-03"},
+			"room_id":    {"2"},
+			"start_date": {"2050-01-10"},
+			"end_date":   {"2050-01-14"},
+			"reason":     {models.BlockReasonRenovation},
 		},
 		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-calender?view=week&start=2050-01-03",
+		expectedKey:          "flash",
+		expectedMessage:      "from 2050-01-10 to 2050-01-14",
 	},
 	{
-		name:                 "cal-blocks",
-		postedData:           url.Values{},
+		name: "single-day-block",
+		postedData: url.Values{
+			"room_id":    {"2"},
+			"start_date": {"2050-01-10"},
+			"end_date":   {"2050-01-10"},
+			"reason":     {models.BlockReasonOwnerUse},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-calender",
+		expectedKey:          "flash",
+	},
+	{
+		name: "reserved",
+		postedData: url.Values{
+			"room_id":    {"1"},
+			"start_date": {"2050-01-10"},
+			"end_date":   {"2050-01-14"},
+			"reason":     {models.BlockReasonMaintenance},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedKey:          "error",
+		expectedMessage:      "reserved by John Smith",
+	},
+	{
+		name: "reserved-in-the-meantime",
+		postedData: url.Values{
+			"room_id":    {"2"},
+			"start_date": {"2060-01-01"},
+			"end_date":   {"2060-01-03"},
+			"reason":     {models.BlockReasonMaintenance},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedKey:          "error",
+		expectedMessage:      "in the meantime",
+	},
+	{
+		name: "ends-before-start",
+		postedData: url.Values{
+			"room_id":    {"2"},
+			"start_date": {"2050-01-14"},
+			"end_date":   {"2050-01-10"},
+			"reason":     {models.BlockReasonMaintenance},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedKey:          "error",
+		expectedMessage:      "can't end before it starts",
+	},
+	{
+		name: "unknown-reason",
+		postedData: url.Values{
+			"room_id":    {"2"},
+			"start_date": {"2050-01-10"},
+			"end_date":   {"2050-01-14"},
+			"reason":     {"holiday"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedKey:          "error",
+		expectedMessage:      "not valid",
+	},
+	{
+		name: "custom-restriction",
+		postedData: url.Values{
+			"room_id":     {"2"},
+			"start_date":  {"2050-01-10"},
+			"end_date":    {"2050-01-10"},
+			"restriction": {"cleaning"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedKey:          "flash",
+		expectedMessage:      "Cleaning added",
+	},
+	{
+		name: "reservation-restriction",
+		postedData: url.Values{
+			"room_id":     {"2"},
+			"start_date":  {"2050-01-10"},
+			"end_date":    {"2050-01-10"},
+			"restriction": {models.RestrictionReservation},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedKey:          "error",
+		expectedMessage:      "not valid",
+	},
+	{
+		name: "unknown-restriction",
+		postedData: url.Values{
+			"room_id":     {"2"},
+			"start_date":  {"2050-01-10"},
+			"end_date":    {"2050-01-10"},
+			"restriction": {"holiday"},
+		},
 		expectedResponseCode: http.StatusSeeOther,
-		blocks:               1,
+		expectedKey:          "error",
+		expectedMessage:      "not valid",
 	},
 	{
-		name:                 "cal-res",
+		name:                 "missing-fields",
 		postedData:           url.Values{},
 		expectedResponseCode: http.StatusSeeOther,
-		reservations:         1,
+		expectedKey:          "error",
+		expectedMessage:      "not valid",
+	},
+	{
+		name: "database-error",
+		postedData: url.Values{
+			"room_id":    {"100"},
+			"start_date": {"2050-01-10"},
+			"end_date":   {"2050-01-14"},
+			"reason":     {models.BlockReasonMaintenance},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedKey:          "error",
+		expectedMessage:      "could not be saved",
 	},
 }
 
-func TestPostReservationCalendar(t *testing.T) {
-	for _, e := range adminPostReservationCalendarTests {
-		var req *http.Request
-		if e.postedData != nil {
-			req, _ = http.NewRequest("POST", "/admin/reservations-calender", strings.NewReader(e.postedData.Encode()))
-		} else {
-			req, _ = http.NewRequest("POST", "/admin/reservations-calender", nil)
-		}
+func TestRepository_PostMyReservation(t *testing.T) {
+	tests := []struct {
+		name             string
+		code             string
+		email            string
+		expectedCode     int
+		expectedLocation string
+	}{
+		{"found", "ABC123", "john@smith.com", http.StatusSeeOther, "/my-reservation/view"},
+		{"wrong-email", "ABC123", "jane@smith.com", http.StatusSeeOther, "/my-reservation"},
+		{"wrong-code", "XYZ999", "john@smith.com", http.StatusSeeOther, "/my-reservation"},
+		{"invalid-form", "", "john@smith.com", http.StatusOK, ""},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("code", e.code)
+		postedData.Add("email", e.email)
+
+		req, _ := http.NewRequest("POST", "/my-reservation", strings.NewReader(postedData.Encode()))
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-		now := time.Now()
-		bm := make(map[string]int)
-		rm := make(map[string]int)
-
-		currentYear, currentMonth, _ := now.Date()
-		currentLocation := now.Location()
+		rr := httptest.NewRecorder()
 
-		firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
-		lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+		handler := http.HandlerFunc(Repo.PostMyReservation)
+		handler.ServeHTTP(rr, req)
 
-		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
-			rm[d.Format("2006-01-2")] = 0
-			bm[d.Format("2006-01-2")] = 0
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: PostMyReservation Handler return wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedCode)
 		}
 
-		if e.blocks > 0 {
-			bm[firstOfMonth.Format("2006-01-2")] = e.blocks
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: PostMyReservation Handler redirected to %s, wanted %s", e.name, rr.Header().Get("Location"), e.expectedLocation)
 		}
 
-		if e.reservations > 0 {
-			rm[lastOfMonth.Format("2006-01-2")] = e.reservations
+		_, found := session.Get(ctx, "guest_reservation_id").(int)
+		if found != (e.name == "found") {
+			t.Errorf("%s: PostMyReservation Handler set guest reservation to %v", e.name, found)
 		}
+	}
+}
 
-		session.Put(ctx, "block_map_1", bm)
-		session.Put(ctx, "reservation_map_1", rm)
+func TestRepository_MyReservationView(t *testing.T) {
+	//no reservation looked up yet
+	req, _ := http.NewRequest("GET", "/my-reservation/view", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
 
-		// set the header
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.MyReservationView)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("MyReservationView Handler return wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	req, _ = http.NewRequest("GET", "/my-reservation/view", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "guest_reservation_id", 1)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("MyReservationView Handler return wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	//reservation no longer in database
+	req, _ = http.NewRequest("GET", "/my-reservation/view", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "guest_reservation_id", 2)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("MyReservationView Handler return wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+}
+
+func TestRepository_PostMyReservationDates(t *testing.T) {
+	tests := []struct {
+		name          string
+		startDate     string
+		endDate       string
+		expectedFlash bool
+		expectedError string
+	}{
+		{"valid-dates", "2050-02-01", "2050-02-03", true, ""},
+		{"room-taken", "2060-01-01", "2060-01-03", false, "Sorry, the room is not available for those dates."},
+		{"min-stay", "2070-01-01", "2070-01-03", false, ""},
+		{"departure-before-arrival", "2050-02-03", "2050-02-01", false, ""},
+		{"arrival-in-past", "2000-01-01", "2000-01-03", false, "Arrival can't be in the past!"},
+		{"invalid-date", "invalid", "2050-02-03", false, "Arrival date is not valid!"},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("start_date", e.startDate)
+		postedData.Add("end_date", e.endDate)
+
+		req, _ := http.NewRequest("POST", "/my-reservation/dates", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "guest_reservation_id", 1)
+
 		rr := httptest.NewRecorder()
 
-		// call the handler
-		handler := http.HandlerFunc(Repo.AdminPostReservationsCalender)
+		handler := http.HandlerFunc(Repo.PostMyReservationDates)
 		handler.ServeHTTP(rr, req)
 
-		if rr.Code != e.expectedResponseCode {
-			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: PostMyReservationDates Handler return wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
 		}
 
+		if changed := session.GetString(ctx, "flash") != ""; changed != e.expectedFlash {
+			t.Errorf("%s: PostMyReservationDates Handler changed reservation: got %v, wanted %v", e.name, changed, e.expectedFlash)
+		}
+
+		if msg := session.GetString(ctx, "error"); e.expectedError != "" && msg != e.expectedError {
+			t.Errorf("%s: expected error %q but got %q", e.name, e.expectedError, msg)
+		}
 	}
 }
 
-func getCtx(req *http.Request) context.Context {
-	ctx, err := session.Load(req.Context(), req.Header.Get("X-Session"))
-	if err != nil {
-		log.Println(err)
+func TestRepository_PostMyReservationCancel(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/my-reservation/cancel", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "guest_reservation_id", 1)
+
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.PostMyReservationCancel)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("PostMyReservationCancel Handler return wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	if session.Exists(ctx, "guest_reservation_id") {
+		t.Error("PostMyReservationCancel Handler did not forget the cancelled reservation")
+	}
+
+	//no reservation looked up
+	req, _ = http.NewRequest("POST", "/my-reservation/cancel", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/my-reservation" {
+		t.Errorf("PostMyReservationCancel Handler return wrong response: got %d to %s", rr.Code, rr.Header().Get("Location"))
+	}
+}
+
+var userFormTests = []struct {
+	name               string
+	url                string
+	handler            func(*Repository, http.ResponseWriter, *http.Request)
+	userID             int
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{
+		"invite-user",
+		"/admin/users",
+		(*Repository).AdminPostNewUser,
+		1,
+		url.Values{"first_name": {"Jane"}, "last_name": {"Doe"}, "email": {"jane@gmail.com"}, "access_level": {"1"}},
+		http.StatusSeeOther,
+		"/admin/users",
+	},
+	{
+		"invite-taken-email",
+		"/admin/users",
+		(*Repository).AdminPostNewUser,
+		1,
+		url.Values{"first_name": {"Jane"}, "last_name": {"Doe"}, "email": {"staff@gmail.com"}, "access_level": {"1"}},
+		http.StatusSeeOther,
+		"/admin/users",
+	},
+	{
+		"invite-unknown-level",
+		"/admin/users",
+		(*Repository).AdminPostNewUser,
+		1,
+		url.Values{"first_name": {"Jane"}, "last_name": {"Doe"}, "email": {"jane@gmail.com"}, "access_level": {"9"}},
+		http.StatusOK,
+		"",
+	},
+	{
+		"forgot-known-email",
+		"/forgot-password",
+		(*Repository).PostForgotPassword,
+		0,
+		url.Values{"email": {"staff@gmail.com"}},
+		http.StatusSeeOther,
+		"/login",
+	},
+	{
+		"forgot-unknown-email",
+		"/forgot-password",
+		(*Repository).PostForgotPassword,
+		0,
+		url.Values{"email": {"nobody@gmail.com"}},
+		http.StatusSeeOther,
+		"/login",
+	},
+	{
+		"forgot-invalid-email",
+		"/forgot-password",
+		(*Repository).PostForgotPassword,
+		0,
+		url.Values{"email": {"nobody"}},
+		http.StatusOK,
+		"",
+	},
+	{
+		"change-password",
+		"/admin/profile",
+		(*Repository).PostProfilePassword,
+		2,
+		url.Values{"current_password": {"old-password"}, "password": {"new-password"}, "password_confirmation": {"new-password"}},
+		http.StatusSeeOther,
+		"/admin/profile",
+	},
+	{
+		"change-password-wrong-current",
+		"/admin/profile",
+		(*Repository).PostProfilePassword,
+		2,
+		url.Values{"current_password": {"wrong"}, "password": {"new-password"}, "password_confirmation": {"new-password"}},
+		http.StatusOK,
+		"",
+	},
+	{
+		"change-password-mismatch",
+		"/admin/profile",
+		(*Repository).PostProfilePassword,
+		2,
+		url.Values{"current_password": {"old-password"}, "password": {"new-password"}, "password_confirmation": {"other-password"}},
+		http.StatusOK,
+		"",
+	},
+}
+
+func TestUserForms(t *testing.T) {
+	for _, e := range userFormTests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if e.userID > 0 {
+			session.Put(ctx, "user_id", e.userID)
+		}
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			e.handler(Repo, rw, r)
+		})
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected redirect to %q but got %q", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	valid := tokens.Sign(app.SecretKey, 2, "staff-hash", time.Now().Add(time.Hour))
+	expired := tokens.Sign(app.SecretKey, 2, "staff-hash", time.Now().Add(-time.Minute))
+	used := tokens.Sign(app.SecretKey, 2, "old-hash", time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name               string
+		token              string
+		password           string
+		confirmation       string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"valid", valid, "new-password", "new-password", http.StatusSeeOther, "/login"},
+		{"mismatch", valid, "new-password", "other-password", http.StatusOK, ""},
+		{"too-short", valid, "short", "short", http.StatusOK, ""},
+		{"expired", expired, "new-password", "new-password", http.StatusSeeOther, "/forgot-password"},
+		{"password-already-changed", used, "new-password", "new-password", http.StatusSeeOther, "/forgot-password"},
+		{"malformed", "nope", "new-password", "new-password", http.StatusSeeOther, "/forgot-password"},
+	}
+
+	for _, e := range tests {
+		//the form is only shown for a valid link
+		req, _ := http.NewRequest("GET", "/reset-password?token="+url.QueryEscape(e.token), nil)
+		req = req.WithContext(getCtx(req))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.ResetPassword).ServeHTTP(rr, req)
+
+		if e.expectedLocation == "/forgot-password" && rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: ResetPassword showed the form for an invalid link", e.name)
+		}
+
+		postedData := url.Values{}
+		postedData.Add("token", e.token)
+		postedData.Add("password", e.password)
+		postedData.Add("password_confirmation", e.confirmation)
+
+		req, _ = http.NewRequest("POST", "/reset-password", strings.NewReader(postedData.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr = httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostResetPassword).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: PostResetPassword expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: PostResetPassword expected redirect to %q but got %q", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestPostReservationCalendar(t *testing.T) {
+	for _, e := range adminPostReservationCalendarTests {
+		req, _ := http.NewRequest("POST", "/admin/reservations-calender", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		// set the header
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		// call the handler
+		handler := http.HandlerFunc(Repo.AdminPostReservationsCalender)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		msg := session.GetString(ctx, e.expectedKey)
+		if msg == "" || !strings.Contains(msg, e.expectedMessage) {
+			t.Errorf("failed %s: expected %s %q, but got %q", e.name, e.expectedKey, e.expectedMessage, msg)
+		}
+	}
+}
+
+func TestAdminPostDeleteBlock(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		expectedKey string
+	}{
+		{"valid", "/admin/blocks/2/delete", "flash"},
+		{"invalid-id", "/admin/blocks/two/delete", "error"},
+		{"database-error", "/admin/blocks/100/delete", "error"},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"view": {"month"}, "start": {"2050-01-01"}}
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostDeleteBlock)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("for %s, expected %d but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		if actualLoc, _ := rr.Result().Location(); actualLoc.String() != "/admin/reservations-calender?view=month&start=2050-01-01" {
+			t.Errorf("for %s, expected to return to the calendar but got %s", e.name, actualLoc)
+		}
+
+		if session.GetString(ctx, e.expectedKey) == "" {
+			t.Errorf("for %s, expected a message in %s", e.name, e.expectedKey)
+		}
+	}
+}
+
+func TestAdminPostMoveReservation(t *testing.T) {
+	tests := []struct {
+		name            string
+		url             string
+		roomID          string
+		startDate       string
+		endDate         string
+		expectedKey     string
+		expectedMessage string
+	}{
+		{"valid", "/admin/reservations/1/move", "1", "2050-01-03", "2050-01-04", "flash", "Reservation moved to General's Quarters"},
+		{"invalid-id", "/admin/reservations/one/move", "1", "2050-01-03", "2050-01-04", "error", "missing url parameter"},
+		{"not-found", "/admin/reservations/2/move", "1", "2050-01-03", "2050-01-04", "error", "can't find reservation!"},
+		{"cancelled", "/admin/reservations/3/move", "1", "2050-01-03", "2050-01-04", "error", "can be moved"},
+		{"missing-room", "/admin/reservations/1/move", "", "2050-01-03", "2050-01-04", "error", "Move is not valid!"},
+		{"invalid-date", "/admin/reservations/1/move", "1", "invalid", "2050-01-04", "error", "Move is not valid!"},
+		{"ends-before-start", "/admin/reservations/1/move", "1", "2050-01-04", "2050-01-03", "error", "Departure can't be before arrival!"},
+		{"min-stay", "/admin/reservations/1/move", "1", "2070-01-01", "2070-01-02", "error", "minimum stay"},
+		{"unavailable", "/admin/reservations/1/move", "1", "2060-01-01", "2060-01-02", "error", "The room is not available for those dates!"},
+		{"unknown-room", "/admin/reservations/1/move", "3", "2050-01-03", "2050-01-04", "error", "Reservation could not be changed, nothing was changed!"},
+		{"database-error", "/admin/reservations/1/move", "2", "2050-01-03", "2050-01-04", "error", "Reservation could not be changed, nothing was changed!"},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{
+			"view":       {"month"},
+			"start":      {"2050-01-01"},
+			"room_id":    {e.roomID},
+			"start_date": {e.startDate},
+			"end_date":   {e.endDate},
+		}
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostMoveReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("for %s, expected %d but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		if actualLoc, _ := rr.Result().Location(); actualLoc.String() != "/admin/reservations-calender?view=month&start=2050-01-01" {
+			t.Errorf("for %s, expected to return to the calendar but got %s", e.name, actualLoc)
+		}
+
+		msg := session.GetString(ctx, e.expectedKey)
+		if msg == "" || !strings.Contains(msg, e.expectedMessage) {
+			t.Errorf("for %s, expected %s %q, but got %q", e.name, e.expectedKey, e.expectedMessage, msg)
+		}
+	}
+}
+
+func TestCalendarSpans(t *testing.T) {
+	first := time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	last := time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	blocking := models.Restriction{BlocksAvailability: true}
+	external := models.Restriction{Code: models.RestrictionExternalCalendar, BlocksAvailability: true}
+
+	restrictions := []models.RoomRestriction{
+		// starts in the previous month
+		{ID: 1, ReservationId: 1, StartDate: first.AddDate(0, 0, -3), EndDate: first.AddDate(0, 0, 1), Restriction: blocking},
+		// doesn't block availability, so it only shows on days nothing else takes
+		{ID: 4, StartDate: first.AddDate(0, 0, 1), EndDate: first.AddDate(0, 0, 2)},
+		{ID: 2, StartDate: first.AddDate(0, 0, 4), EndDate: first.AddDate(0, 0, 6), Restriction: blocking},
+		{ID: 3, StartDate: first.AddDate(0, 0, 7), EndDate: first.AddDate(0, 0, 7), Restriction: external},
+	}
+
+	var got []string
+	for _, span := range calendarSpans(first, last, restrictions) {
+		got = append(got, fmt.Sprintf("%d:%s:%d", span.Start.Day(), span.Kind(), span.Days))
+	}
+
+	expected := "1:reservation:2 3:block:1 4:free:1 5:block:3 8:external:1 9:free:1 10:free:1"
+	if strings.Join(got, " ") != expected {
+		t.Errorf("expected spans %s but got %s", expected, strings.Join(got, " "))
+	}
+}
+
+func TestCalendarView(t *testing.T) {
+	today := time.Date(2050, 1, 5, 15, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name          string
+		query         string
+		expectedTitle string
+		expectedFirst string
+		expectedLast  string
+		expectedPrev  string
+		expectedNext  string
+	}{
+		{"default", "", "January 2050", "2050-01-01", "2050-01-31", "2049-12-01", "2050-02-01"},
+		{"week", "view=week&start=2050-01-05", "3 Jan - 9 Jan 2050", "2050-01-03", "2050-01-09", "2049-12-27", "2050-01-10"},
+		{"week-from-monday", "view=week&start=2050-01-03", "3 Jan - 9 Jan 2050", "2050-01-03", "2050-01-09", "2049-12-27", "2050-01-10"},
+		{"week-from-sunday", "view=week&start=2050-01-09", "3 Jan - 9 Jan 2050", "2050-01-03", "2050-01-09", "2049-12-27", "2050-01-10"},
+		{"month", "view=month&start=2050-02-14", "February 2050", "2050-02-01", "2050-02-28", "2050-01-01", "2050-03-01"},
+		{"3months", "view=3months&start=2050-01-20", "January - March 2050", "2050-01-01", "2050-03-31", "2049-10-01", "2050-04-01"},
+		{"3months-over-new-year", "view=3months&start=2050-11-20", "November 2050 - January 2051", "2050-11-01", "2051-01-31", "2050-08-01", "2051-02-01"},
+		{"old-link", "y=2050&m=03", "March 2050", "2050-03-01", "2050-03-31", "2050-02-01", "2050-04-01"},
+		{"unknown-view", "view=year&start=2050-06-10", "June 2050", "2050-06-01", "2050-06-30", "2050-05-01", "2050-07-01"},
+	}
+
+	for _, e := range tests {
+		query, _ := url.ParseQuery(e.query)
+		view := newCalendarView(query, today)
+
+		got := []string{view.Title(), view.First.Format(forms.DateLayout), view.Last.Format(forms.DateLayout),
+			view.Prev.Format(forms.DateLayout), view.Next.Format(forms.DateLayout)}
+		expected := []string{e.expectedTitle, e.expectedFirst, e.expectedLast, e.expectedPrev, e.expectedNext}
+		if strings.Join(got, " | ") != strings.Join(expected, " | ") {
+			t.Errorf("%s: expected %s but got %s", e.name, strings.Join(expected, " | "), strings.Join(got, " | "))
+		}
+
+		if days := view.Days(); days[0] != view.First || days[len(days)-1] != view.Last {
+			t.Errorf("%s: days don't cover the range", e.name)
+		}
+	}
+
+	months := newCalendarView(url.Values{"view": {"3months"}, "start": {"2050-11-01"}}, today).Months()
+	if len(months) != 3 || months[0].Days != 30 || months[2].Start.Format(forms.DateLayout) != "2051-01-01" {
+		t.Errorf("expected November, December and January but got %v", months)
+	}
+}
+
+func TestRepository_CancelledContext(t *testing.T) {
+	// admin reservations list
+	req, _ := http.NewRequest("GET", "/admin/reservations", nil)
+	ctx, cancel := context.WithCancel(getCtx(req))
+	cancel()
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminReservations)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("AdminReservations Handler return wrong response code with cancelled context: got %d, wanted %d", rr.Code, http.StatusInternalServerError)
+	}
+
+	// availability search
+	reqBody := "start_date=2040-02-01"
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2040-02-01")
+
+	req, _ = http.NewRequest("POST", "/search", strings.NewReader(reqBody))
+	ctx, cancel = context.WithCancel(getCtx(req))
+	cancel()
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.PostSearch)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("PostSearch Handler return wrong response code with cancelled context: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	}
+
+	// making a reservation
+	postedData := url.Values{}
+	postedData.Add("firstname", "amir")
+	postedData.Add("lastname", "anbari")
+	postedData.Add("email", "amir@gmail.com")
+	postedData.Add("phone", "+989335716724")
+
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	sessionCtx := getCtx(req)
+	session.Put(sessionCtx, "reservation", models.Reservation{RoomId: 1})
+	ctx, cancel = context.WithCancel(sessionCtx)
+	cancel()
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.PostReservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("PostReservation Handler return wrong response code with cancelled context: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	}
+}
+
+func TestLoginTwoFactor(t *testing.T) {
+	code, _ := totp.Code(dbrepo.TestTOTPSecret, time.Now())
+	wrong := fmt.Sprintf("%06d", (atoi(code)+1)%1000000)
+
+	tests := []struct {
+		name             string
+		pendingUserID    int
+		pendingAt        time.Time
+		code             string
+		expectedLocation string
+		loggedIn         bool
+	}{
+		{"valid-code", 4, time.Now(), code, "/", true},
+		{"recovery-code", 4, time.Now(), strings.ToLower(dbrepo.TestRecoveryCode), "/", true},
+		{"wrong-code", 4, time.Now(), wrong, "/login/two-factor", false},
+		{"used-recovery-code", 4, time.Now(), "ZZZZZ-ZZZZZ", "/login/two-factor", false},
+		{"expired", 4, time.Now().Add(-time.Hour), code, "/login", false},
+		{"no-password-first", 0, time.Now(), code, "/login", false},
+		{"user-without-two-factor", 2, time.Now(), code, "/login", false},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("code", e.code)
+
+		req, _ := http.NewRequest("POST", "/login/two-factor", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if e.pendingUserID > 0 {
+			session.Put(ctx, "pending_user_id", e.pendingUserID)
+			session.Put(ctx, "pending_login_at", e.pendingAt.Unix())
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostLoginTwoFactor).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected %d but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		if rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected redirect to %q but got %q", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+
+		if loggedIn := session.Exists(ctx, "user_id"); loggedIn != e.loggedIn {
+			t.Errorf("%s: expected logged in to be %v but got %v", e.name, e.loggedIn, loggedIn)
+		}
+	}
+}
+
+func TestPostLoginTwoFactorSteps(t *testing.T) {
+	defer func(level int) { app.TwoFactorLevel = level }(app.TwoFactorLevel)
+	app.TwoFactorLevel = models.AccessLevelOwner
+
+	tests := []struct {
+		name             string
+		email            string
+		expectedLocation string
+		loggedIn         bool
+	}{
+		{"second-step", "totp@gmail.com", "/login/two-factor", false},
+		{"required-for-owner", "admin@gmail.com", "/admin/two-factor", true},
+		{"optional-for-staff", "staff@gmail.com", "/", true},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("email", e.email)
+		postedData.Add("password", "12345678")
+
+		req, _ := http.NewRequest("POST", "/login", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostLogin).ServeHTTP(rr, req)
+
+		if rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected redirect to %q but got %q", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+
+		if loggedIn := session.Exists(ctx, "user_id"); loggedIn != e.loggedIn {
+			t.Errorf("%s: expected logged in to be %v but got %v", e.name, e.loggedIn, loggedIn)
+		}
+	}
+}
+
+func TestTwoFactorEnrolment(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/two-factor", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "user_id", 2)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.TwoFactor).ServeHTTP(rr, req)
+
+	secret := session.GetString(ctx, "totp_secret")
+	if rr.Code != http.StatusOK || secret == "" || !strings.Contains(rr.Body.String(), secret) {
+		t.Fatalf("TwoFactor returned %d without showing the secret from the session", rr.Code)
+	}
+
+	code, _ := totp.Code(secret, time.Now())
+	wrong := fmt.Sprintf("%06d", (atoi(code)+1)%1000000)
+
+	//the test repo doesn't remember enabling it, user 4 is reported as enrolled afterwards
+	session.Put(ctx, "user_id", 4)
+
+	for _, c := range []string{wrong, code} {
+		postedData := url.Values{}
+		postedData.Add("code", c)
+
+		req, _ = http.NewRequest("POST", "/admin/two-factor", strings.NewReader(postedData.Encode()))
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr = httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostTwoFactor).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("PostTwoFactor with code %s returned %d", c, rr.Code)
+		}
+
+		if enrolled := !session.Exists(ctx, "totp_secret"); enrolled != (c == code) {
+			t.Errorf("PostTwoFactor with code %s: expected enrolled to be %v", c, c == code)
+		}
+
+		if shown := strings.Contains(rr.Body.String(), "Save these recovery codes"); shown != (c == code) {
+			t.Errorf("PostTwoFactor with code %s: expected recovery codes shown to be %v", c, c == code)
+		}
+	}
+}
+
+func TestPostTwoFactorDisable(t *testing.T) {
+	defer func(level int) { app.TwoFactorLevel = level }(app.TwoFactorLevel)
+
+	tests := []struct {
+		name               string
+		level              int
+		password           string
+		expectedStatusCode int
+		expectedError      string
+	}{
+		{"disable", 0, "12345678", http.StatusSeeOther, ""},
+		{"wrong-password", 0, "wrong", http.StatusOK, ""},
+		{"required", models.AccessLevelStaff, "12345678", http.StatusSeeOther, "Two-factor authentication is required for your account."},
+	}
+
+	for _, e := range tests {
+		app.TwoFactorLevel = e.level
+
+		postedData := url.Values{}
+		postedData.Add("current_password", e.password)
+
+		req, _ := http.NewRequest("POST", "/admin/two-factor/disable", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "user_id", 4)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostTwoFactorDisable).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if err := session.GetString(ctx, "error"); err != e.expectedError {
+			t.Errorf("%s: expected error %q but got %q", e.name, e.expectedError, err)
+		}
+	}
+}
+
+var apiTests = []struct {
+	name               string
+	method             string
+	url                string
+	body               string
+	expectedStatusCode int
+	expectedErrorCode  string
+}{
+	{"availability", "GET", "/api/v1/availability?start_date=2040-02-01&end_date=2040-02-03&amenities=wifi", "", http.StatusOK, ""},
+	{"availability-missing-dates", "GET", "/api/v1/availability", "", http.StatusUnprocessableEntity, "validation_failed"},
+	{"availability-bad-date", "GET", "/api/v1/availability?start_date=01/02/2040&end_date=2040-02-03", "", http.StatusUnprocessableEntity, "validation_failed"},
+	{"availability-end-before-start", "GET", "/api/v1/availability?start_date=2040-02-03&end_date=2040-02-01", "", http.StatusUnprocessableEntity, "validation_failed"},
+	{"availability-db-error", "GET", "/api/v1/availability?start_date=2040-01-01&end_date=2040-01-03", "", http.StatusInternalServerError, "internal_error"},
+	{"rooms", "GET", "/api/v1/rooms", "", http.StatusOK, ""},
+	{"room", "GET", "/api/v1/rooms/1", "", http.StatusOK, ""},
+	{"room-not-found", "GET", "/api/v1/rooms/3", "", http.StatusNotFound, "not_found"},
+	{"room-bad-id", "GET", "/api/v1/rooms/one", "", http.StatusNotFound, "not_found"},
+	{
+		"book",
+		"POST",
+		"/api/v1/reservations",
+		`{"firstname": "John", "lastname": "Smith", "email": "john@smith.com", "phone": "555", "room_id": 1, "start_date": "2050-03-01", "end_date": "2050-03-03"}`,
+		http.StatusCreated,
+		"",
+	},
+	{
+		"book-invalid",
+		"POST",
+		"/api/v1/reservations",
+		`{"firstname": "John", "email": "john", "room_id": 1, "start_date": "2050-03-01", "end_date": "2050-03-03"}`,
+		http.StatusUnprocessableEntity,
+		"validation_failed",
+	},
+	{
+		"book-unknown-room",
+		"POST",
+		"/api/v1/reservations",
+		`{"firstname": "John", "lastname": "Smith", "email": "john@smith.com", "phone": "555", "room_id": 3, "start_date": "2050-03-01", "end_date": "2050-03-03"}`,
+		http.StatusUnprocessableEntity,
+		"validation_failed",
+	},
+	{
+		"book-taken",
+		"POST",
+		"/api/v1/reservations",
+		`{"firstname": "John", "lastname": "Smith", "email": "john@smith.com", "phone": "555", "room_id": 1, "start_date": "2060-01-01", "end_date": "2060-01-03"}`,
+		http.StatusConflict,
+		"room_not_available",
+	},
+	{
+		"book-minimum-stay",
+		"POST",
+		"/api/v1/reservations",
+		`{"firstname": "John", "lastname": "Smith", "email": "john@smith.com", "phone": "555", "room_id": 1, "start_date": "2070-01-01", "end_date": "2070-01-02"}`,
+		http.StatusUnprocessableEntity,
+		"minimum_stay",
+	},
+	{
+		"book-db-error",
+		"POST",
+		"/api/v1/reservations",
+		`{"firstname": "John", "lastname": "Smith", "email": "john@smith.com", "phone": "555", "room_id": 2, "start_date": "2050-03-01", "end_date": "2050-03-03"}`,
+		http.StatusInternalServerError,
+		"internal_error",
+	},
+	{"book-malformed", "POST", "/api/v1/reservations", `{"firstname": `, http.StatusBadRequest, "bad_request"},
+	{"book-unknown-field", "POST", "/api/v1/reservations", `{"first_name": "John"}`, http.StatusBadRequest, "bad_request"},
+	{"reservation", "GET", "/api/v1/reservations/ABC123?email=john@smith.com", "", http.StatusOK, ""},
+	{"reservation-wrong-email", "GET", "/api/v1/reservations/ABC123?email=jane@smith.com", "", http.StatusNotFound, "not_found"},
+	{"reservation-missing-email", "GET", "/api/v1/reservations/ABC123", "", http.StatusUnprocessableEntity, "validation_failed"},
+	{"cancel", "POST", "/api/v1/reservations/ABC123/cancel", `{"email": "john@smith.com"}`, http.StatusOK, ""},
+	{"cancel-wrong-email", "POST", "/api/v1/reservations/ABC123/cancel", `{"email": "jane@smith.com"}`, http.StatusNotFound, "not_found"},
+	{"cancel-past-stay", "POST", "/api/v1/reservations/OLD123/cancel", `{"email": "john@smith.com"}`, http.StatusConflict, "not_changeable"},
+}
+
+func TestAPI(t *testing.T) {
+	routes := getRoutes()
+
+	for _, e := range apiTests {
+		req := httptest.NewRequest(e.method, e.url, strings.NewReader(e.body))
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d: %s", e.name, e.expectedStatusCode, rr.Code, rr.Body.String())
+		}
+
+		if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: expected a json response but got %q", e.name, ct)
+		}
+
+		var body struct {
+			Error struct {
+				Code string
+			}
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Errorf("%s: response is not valid json: %s", e.name, err)
+		}
+
+		if body.Error.Code != e.expectedErrorCode {
+			t.Errorf("%s: expected error code %q but got %q", e.name, e.expectedErrorCode, body.Error.Code)
+		}
+	}
+}
+
+func TestAPIPostReservationResponse(t *testing.T) {
+	body := `{"firstname": "John", "lastname": "Smith", "email": "john@smith.com", "phone": "555", "room_id": 1, "start_date": "2050-03-01", "end_date": "2050-03-03"}`
+
+	req := httptest.NewRequest("POST", "/api/v1/reservations", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	getRoutes().ServeHTTP(rr, req)
+
+	var res struct {
+		ConfirmationCode string `json:"confirmation_code"`
+		Status           string `json:"status"`
+		StartDate        string `json:"start_date"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.ConfirmationCode) != 12 || res.Status != models.ReservationPending || res.StartDate != "2050-03-01" {
+		t.Errorf("unexpected reservation %+v", res)
+	}
+
+	if loc := rr.Header().Get("Location"); loc != "/api/v1/reservations/"+res.ConfirmationCode {
+		t.Errorf("expected location of the new reservation but got %q", loc)
+	}
+}
+
+func TestAdminPostNewAPIKey(t *testing.T) {
+	tests := []struct {
+		name               string
+		postedData         url.Values
+		expectedStatusCode int
+		keyShown           bool
+	}{
+		{"valid", url.Values{"name": {"tablet"}, "scopes": {"rooms:read", "reservations:write"}}, http.StatusOK, true},
+		{"missing-name", url.Values{"scopes": {"rooms:read"}}, http.StatusOK, false},
+		{"missing-scopes", url.Values{"name": {"tablet"}}, http.StatusOK, false},
+		{"unknown-scope", url.Values{"name": {"tablet"}, "scopes": {"admin"}}, http.StatusOK, false},
+		{"database-error", url.Values{"name": {"error"}, "scopes": {"rooms:read"}}, http.StatusInternalServerError, false},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/api-keys", strings.NewReader(e.postedData.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostNewAPIKey).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if shown := strings.Contains(rr.Body.String(), "<pre class=\"mt-2 mb-0\">bk_"); shown != e.keyShown {
+			t.Errorf("%s: expected key shown to be %v", e.name, e.keyShown)
+		}
+	}
+}
+
+func TestAdminPostRevokeAPIKey(t *testing.T) {
+	tests := []struct {
+		name          string
+		url           string
+		expectedError string
+	}{
+		{"revoke", "/admin/api-keys/1/revoke", ""},
+		{"not-found", "/admin/api-keys/2/revoke", "can't revoke API key!"},
+		{"bad-id", "/admin/api-keys/one/revoke", "missing url parameter"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostRevokeAPIKey).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/admin/api-keys" {
+			t.Errorf("%s: expected redirect to /admin/api-keys but got %d %q", e.name, rr.Code, rr.Header().Get("Location"))
+		}
+
+		if err := session.GetString(ctx, "error"); err != e.expectedError {
+			t.Errorf("%s: expected error %q but got %q", e.name, e.expectedError, err)
+		}
+	}
+}
+
+func atoi(s string) int {
+	var n int
+	fmt.Sscan(s, &n)
+	return n
+}
+
+func getCtx(req *http.Request) context.Context {
+	ctx, err := session.Load(req.Context(), req.Header.Get("X-Session"))
+	if err != nil {
+		log.Println(err)
+	}
+	return ctx
+}
+
+var roomCalendarTests = []struct {
+	name               string
+	url                string
+	expectedStatusCode int
+}{
+	{"valid-token", "/rooms/1/calendar.ics?token=" + dbrepo.TestCalendarToken, http.StatusOK},
+	{"wrong-token", "/rooms/1/calendar.ics?token=guess", http.StatusNotFound},
+	{"missing-token", "/rooms/1/calendar.ics", http.StatusNotFound},
+	{"room-without-feed", "/rooms/2/calendar.ics?token=", http.StatusNotFound},
+	{"unknown-room", "/rooms/3/calendar.ics?token=" + dbrepo.TestCalendarToken, http.StatusNotFound},
+	{"invalid-room", "/rooms/one/calendar.ics?token=" + dbrepo.TestCalendarToken, http.StatusNotFound},
+}
+
+func TestRoomCalendar(t *testing.T) {
+	for _, e := range roomCalendarTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.RoomCalendar)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/calendar") {
+			t.Errorf("for %s, expected a calendar but got %s", e.name, rr.Header().Get("Content-Type"))
+		}
+
+		body := rr.Body.String()
+		for _, want := range []string{
+			"X-WR-CALNAME:General's Quarters",
+			"SUMMARY:Reserved\r\n",
+			"DESCRIPTION:http://localhost:8000/admin/reservations/1\r\n",
+			"SUMMARY:Blocked: maintenance",
+			"SUMMARY:Booked on another site",
+			"SUMMARY:Unavailable",
+			"UID:room-restriction-2@localhost:8000",
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("for %s, feed is missing %q", e.name, want)
+			}
+		}
+
+		for _, leak := range []string{"John", "Smith", "ABC123"} {
+			if strings.Contains(body, leak) {
+				t.Errorf("for %s, feed shows the guest's %q", e.name, leak)
+			}
+		}
+	}
+}
+
+func TestAdminPostRoomCalendarToken(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		expectedKey string
+	}{
+		{"valid", "/admin/rooms/1/calendar-token", "flash"},
+		{"database-error", "/admin/rooms/2/calendar-token", "error"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostRoomCalendarToken)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("for %s, expected %d but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		if msg := session.GetString(ctx, e.expectedKey); msg == "" {
+			t.Errorf("for %s, expected a message in %s", e.name, e.expectedKey)
+		}
+	}
+}
+
+func TestAdminPostCalendarSource(t *testing.T) {
+	// stands in for the calendar of another booking site
+	other := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/room-1.ics" {
+			http.NotFound(rw, r)
+			return
+		}
+		rw.Header().Set("Content-Type", "text/calendar")
+		fmt.Fprint(rw, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"+
+			"BEGIN:VEVENT\r\nUID:stay@other\r\nDTSTART;VALUE=DATE:20500101\r\nDTEND;VALUE=DATE:20500104\r\nEND:VEVENT\r\n"+
+			"END:VCALENDAR\r\n")
+	}))
+	defer other.Close()
+
+	tests := []struct {
+		name               string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedKey        string
+	}{
+		{"valid", url.Values{"name": {"Other site"}, "url": {other.URL + "/room-1.ics"}}, http.StatusSeeOther, "flash"},
+		{"not-found", url.Values{"name": {"Other site"}, "url": {other.URL + "/missing.ics"}}, http.StatusSeeOther, "error"},
+		{"missing-url", url.Values{"name": {"Other site"}}, http.StatusSeeOther, "error"},
+		{"local-file", url.Values{"name": {"Other site"}, "url": {"/etc/passwd"}}, http.StatusSeeOther, "error"},
+		{"database-error", url.Values{"name": {"error"}, "url": {other.URL}}, http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/1/calendar-sources", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = "/admin/rooms/1/calendar-sources"
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostCalendarSource)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedKey != "" && session.GetString(ctx, e.expectedKey) == "" {
+			t.Errorf("for %s, expected a message in %s", e.name, e.expectedKey)
+		}
+	}
+}
+
+func TestAdminCalendarSourceActions(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		handler     http.HandlerFunc
+		expectedKey string
+	}{
+		// the test calendar source can't be reached
+		{"sync", "/admin/rooms/1/calendar-sources/1/sync", Repo.AdminPostSyncCalendarSource, "error"},
+		{"sync-unknown", "/admin/rooms/2/calendar-sources/1/sync", Repo.AdminPostSyncCalendarSource, "error"},
+		{"delete", "/admin/rooms/1/calendar-sources/1/delete", Repo.AdminPostDeleteCalendarSource, "flash"},
+		{"delete-unknown", "/admin/rooms/1/calendar-sources/2/delete", Repo.AdminPostDeleteCalendarSource, "error"},
+		{"delete-invalid", "/admin/rooms/1/calendar-sources/x/delete", Repo.AdminPostDeleteCalendarSource, "error"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+
+		rr := httptest.NewRecorder()
+		e.handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("for %s, expected %d but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		if session.GetString(ctx, e.expectedKey) == "" {
+			t.Errorf("for %s, expected a message in %s", e.name, e.expectedKey)
+		}
+	}
+}
+
+func TestAdminPostNewRestriction(t *testing.T) {
+	tests := []struct {
+		name               string
+		postedData         url.Values
+		expectedStatusCode int
+	}{
+		{"valid", url.Values{"name": {"Painting"}, "code": {"painting"}, "colour": {"#aabbcc"}, "blocks_availability": {"1"}}, http.StatusSeeOther},
+		{"missing-name", url.Values{"code": {"painting"}, "colour": {"#aabbcc"}}, http.StatusOK},
+		{"invalid-code", url.Values{"name": {"Painting"}, "code": {"paint job"}, "colour": {"#aabbcc"}}, http.StatusOK},
+		{"code-in-use", url.Values{"name": {"Painting"}, "code": {"cleaning"}, "colour": {"#aabbcc"}}, http.StatusOK},
+		{"invalid-colour", url.Values{"name": {"Painting"}, "code": {"painting"}, "colour": {"red"}}, http.StatusOK},
+		{"database-error", url.Values{"name": {"error"}, "code": {"painting"}, "colour": {"#aabbcc"}}, http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/restrictions/new", strings.NewReader(e.postedData.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostNewRestriction).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func TestAdminPostShowRestriction(t *testing.T) {
+	tests := []struct {
+		name               string
+		url                string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedKey        string
+	}{
+		{"valid", "/admin/restrictions/4", url.Values{"name": {"Deep clean"}, "code": {"cleaning"}, "colour": {"#ffc107"}}, http.StatusSeeOther, "flash"},
+		// the code of a built in restriction can't change, so reservation isn't taken by the restriction itself
+		{"built-in", "/admin/restrictions/1", url.Values{"name": {"Booking"}, "code": {"cleaning"}, "colour": {"#dc3545"}}, http.StatusSeeOther, "flash"},
+		{"code-in-use", "/admin/restrictions/4", url.Values{"name": {"Cleaning"}, "code": {"late-checkout"}, "colour": {"#ffc107"}}, http.StatusOK, ""},
+		{"overlapping", "/admin/restrictions/5", url.Values{"name": {"Late checkout"}, "code": {"late-checkout"}, "colour": {"#fd7e14"}, "blocks_availability": {"1"}}, http.StatusOK, ""},
+		{"database-error", "/admin/restrictions/4", url.Values{"name": {"error"}, "code": {"cleaning"}, "colour": {"#ffc107"}}, http.StatusSeeOther, "error"},
+		{"not-found", "/admin/restrictions/100", url.Values{"name": {"Cleaning"}, "code": {"cleaning"}, "colour": {"#ffc107"}}, http.StatusSeeOther, "error"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostShowRestriction).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedKey != "" && session.GetString(ctx, e.expectedKey) == "" {
+			t.Errorf("%s: expected a message in %s", e.name, e.expectedKey)
+		}
+	}
+}
+
+func TestAdminPostDeleteRestriction(t *testing.T) {
+	tests := []struct {
+		name          string
+		url           string
+		expectedError string
+	}{
+		{"delete", "/admin/restrictions/5/delete", ""},
+		{"in-use", "/admin/restrictions/4/delete", "Rooms are still restricted with it, remove those restrictions first!"},
+		{"built-in", "/admin/restrictions/2/delete", "Built in restrictions can't be deleted!"},
+		{"not-found", "/admin/restrictions/100/delete", "can't find restriction!"},
+		{"bad-id", "/admin/restrictions/one/delete", "missing url parameter"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostDeleteRestriction).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/admin/restrictions" {
+			t.Errorf("%s: expected redirect to /admin/restrictions but got %d %q", e.name, rr.Code, rr.Header().Get("Location"))
+		}
+
+		if err := session.GetString(ctx, "error"); err != e.expectedError {
+			t.Errorf("%s: expected error %q but got %q", e.name, e.expectedError, err)
+		}
+	}
+}
+
+func TestReservationList(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		query          string
+		fixedStatus    bool
+		expectedFilter models.ReservationFilter
+		expectedPage   int
+		expectedNext   string
+		expectedSort   string
+	}{
+		{"default", "/admin/reservations", "", false,
+			models.ReservationFilter{Sort: "start_date", Desc: true, Limit: 25},
+			1, "/admin/reservations?order=desc&page=2&sort=start_date", "/admin/reservations?order=asc&sort=start_date"},
+		{"filtered", "/admin/reservations", "status=pending&q=+smith+&sort=amount&order=asc&page=2", false,
+			models.ReservationFilter{Status: "pending", Search: "smith", Sort: "amount", Limit: 25, Offset: 25},
+			2, "/admin/reservations?order=asc&page=3&q=smith&sort=amount&status=pending", "/admin/reservations?order=desc&q=smith&sort=amount&status=pending"},
+		{"last-page", "/admin/reservations", "sort=name&order=desc&page=3", false,
+			models.ReservationFilter{Sort: "name", Desc: true, Limit: 25, Offset: 50},
+			3, "", "/admin/reservations?order=asc&sort=name"},
+		{"unknown-sort", "/admin/reservations", "sort=password&order=asc&page=-1", false,
+			models.ReservationFilter{Sort: "start_date", Desc: true, Limit: 25},
+			1, "/admin/reservations?order=desc&page=2&sort=start_date", "/admin/reservations?order=asc&sort=start_date"},
+		{"fixed-status", "/admin/new-reservations", "status=cancelled", true,
+			models.ReservationFilter{Sort: "start_date", Desc: true, Limit: 25},
+			1, "/admin/new-reservations?order=desc&page=2&sort=start_date", "/admin/new-reservations?order=asc&sort=start_date"},
+	}
+
+	for _, e := range tests {
+		query, _ := url.ParseQuery(e.query)
+		list := newReservationList(e.path, query, e.fixedStatus)
+		list.Total = 60
+
+		if list.Filter != e.expectedFilter {
+			t.Errorf("for %s, expected filter %+v but got %+v", e.name, e.expectedFilter, list.Filter)
+		}
+
+		if list.Page != e.expectedPage || list.Pages() != 3 {
+			t.Errorf("for %s, expected page %d of 3 but got %d of %d", e.name, e.expectedPage, list.Page, list.Pages())
+		}
+
+		if next := list.NextURL(); next != e.expectedNext {
+			t.Errorf("for %s, expected next page %q but got %q", e.name, e.expectedNext, next)
+		}
+
+		if sort := list.SortURL(list.Filter.Sort); sort != e.expectedSort {
+			t.Errorf("for %s, expected to reverse the order with %q but got %q", e.name, e.expectedSort, sort)
+		}
+	}
+}
+
+func TestAdminReservations(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/reservations?q=smith", nil)
+	req = req.WithContext(getCtx(req))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminReservations)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected %d but got %d", http.StatusOK, rr.Code)
+	}
+
+	html := rr.Body.String()
+	for _, expected := range []string{"Showing 1 to 2 of 60 reservations", "Page 1 of 3", `action="/admin/reservations/export"`, `href="/admin/reservations?order=desc&amp;page=2&amp;q=smith&amp;sort=start_date"`} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("expected html %s", expected)
+		}
+	}
+}
+
+func TestAdminReservationsExport(t *testing.T) {
+	tests := []struct {
+		name                string
+		query               string
+		expectedStatusCode  int
+		expectedContentType string
+		expectedFilename    string
+	}{
+		{"csv", "", http.StatusOK, "text/csv; charset=utf-8", "reservations.csv"},
+		{"xlsx", "format=xlsx&from=2050-01-01&to=2050-01-31&status=confirmed", http.StatusOK,
+			"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "reservations-from-2050-01-01-to-2050-01-31-confirmed.xlsx"},
+		{"unknown-format", "format=pdf", http.StatusSeeOther, "", ""},
+		{"invalid-date", "from=2050-13-01", http.StatusSeeOther, "", ""},
+		{"ends-before-start", "from=2050-02-01&to=2050-01-01", http.StatusSeeOther, "", ""},
+		{"unknown-status", "status=deleted", http.StatusSeeOther, "", ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/reservations/export?"+e.query, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminReservationsExport)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedStatusCode == http.StatusSeeOther {
+			if session.GetString(ctx, "error") == "" {
+				t.Errorf("for %s, expected an error message", e.name)
+			}
+			continue
+		}
+
+		if contentType := rr.Header().Get("Content-Type"); contentType != e.expectedContentType {
+			t.Errorf("for %s, expected content type %s but got %s", e.name, e.expectedContentType, contentType)
+		}
+
+		if disposition := rr.Header().Get("Content-Disposition"); disposition != `attachment; filename="`+e.expectedFilename+`"` {
+			t.Errorf("for %s, expected file %s but got %s", e.name, e.expectedFilename, disposition)
+		}
+	}
+}
+
+func TestAdminReservationsExportCSV(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/reservations/export?format=csv", nil)
+	req = req.WithContext(getCtx(req))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminReservationsExport)
+	handler.ServeHTTP(rr, req)
+
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+
+	// the test repository says 60 reservations match
+	if len(lines) != 61 {
+		t.Fatalf("expected a header and 60 rows but got %d lines", len(lines))
+	}
+
+	if lines[0] != "ID,Confirmation code,First name,Last name,Email,Phone,Room,Arrival,Departure,Nights,Status,Amount" {
+		t.Errorf("unexpected header %s", lines[0])
+	}
+
+	if lines[1] != "1,ABC123,John,Smith,john@smith.com,,General's Quarters,2050-01-01,2050-01-02,1,confirmed," {
+		t.Errorf("unexpected row %s", lines[1])
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &csvWriter{csv.NewWriter(&buf)}
+
+	_ = w.Write([]interface{}{"=HYPERLINK(\"x\")", "+44 (0)20 7946-0958", "+1+cmd|' /C calc'!A0", "@SUM(A1)", "Smith, John", 2,
+		123.4, nil, time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `"'=HYPERLINK(""x"")",+44 (0)20 7946-0958,'+1+cmd|' /C calc'!A0,'@SUM(A1),"Smith, John",2,123.40,,2050-01-01` + "\n"
+	if buf.String() != expected {
+		t.Errorf("expected %s but got %s", expected, buf.String())
+	}
+}
+
+func TestAdminPostImport(t *testing.T) {
+	tests := []struct {
+		name               string
+		kind               string
+		file               string
+		expectedStatusCode int
+		expectedText       string
+	}{
+		{"rooms", "rooms", "title,max_occupancy,nightly_rate\nColonel's Room,2,100\n", http.StatusOK, "Import done, 1 imported, 0 not imported!"},
+		{"invalid-room", "rooms", "title,max_occupancy,nightly_rate\nColonel's Room,2,100\nTi,2,100\n", http.StatusOK, "title: this field must be at least 3 characters long"},
+		{"unknown-room", "reservations", "firstname,lastname,email,room,start_date,end_date\nJohn,Smith,john@smith.com,1,2050-01-01,2050-01-03\n", http.StatusOK, "room: there is no room 1"},
+		{"missing-column", "reservations", "firstname,lastname,email\n", http.StatusOK, "invalid file: the header lacks the room, start_date, end_date columns"},
+		{"unknown-kind", "users", "email\n", http.StatusOK, "only rooms and reservations can be imported"},
+		{"missing-file", "rooms", "", http.StatusOK, "choose a CSV file"},
+		{"database-error", "rooms", "title,max_occupancy,nightly_rate\nerror,2,100\n", http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		mw.WriteField("kind", e.kind)
+		if e.file != "" {
+			fw, _ := mw.CreateFormFile("file", "import.csv")
+			fw.Write([]byte(e.file))
+		}
+		mw.Close()
+
+		req, _ := http.NewRequest("POST", "/admin/import", &body)
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostImport).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedText != "" && !strings.Contains(html.UnescapeString(rr.Body.String()), e.expectedText) {
+			t.Errorf("%s: expected the page to show %q", e.name, e.expectedText)
+		}
+	}
+
+	// a form that isn't a file upload can't be read
+	req, _ := http.NewRequest("POST", "/admin/import", strings.NewReader("kind=rooms"))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminPostImport).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/admin/import" {
+		t.Errorf("expected redirect to /admin/import but got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if err := session.GetString(ctx, "error"); !strings.Contains(err, "The file can't be read") {
+		t.Errorf("expected the upload to be refused but got %q", err)
 	}
-	return ctx
 }
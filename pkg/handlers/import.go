@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/amiranbari/bookings/internal/forms"
+	"github.com/amiranbari/bookings/internal/helpers"
+	"github.com/amiranbari/bookings/internal/importer"
+	"github.com/amiranbari/bookings/pkg/models"
+	"github.com/amiranbari/bookings/pkg/renders"
+)
+
+// maxImportSize is the largest CSV file that can be uploaded
+const maxImportSize = 10 << 20
+
+// AdminImport shows the form to import rooms or reservations from a CSV file
+func (m *Repository) AdminImport(rw http.ResponseWriter, r *http.Request) {
+	m.renderAdminImport(rw, r, forms.New(nil), nil)
+}
+
+// renderAdminImport shows the import page, result is only set right after a file was imported
+func (m *Repository) renderAdminImport(rw http.ResponseWriter, r *http.Request, form *forms.Form, result *importer.Result) {
+	data := make(map[string]interface{})
+	data["kinds"] = importer.Kinds
+	data["columns"] = map[string]string{
+		importer.Rooms:        strings.Join(importer.RoomColumns, ", "),
+		importer.Reservations: strings.Join(importer.ReservationColumns, ", "),
+	}
+	if result != nil {
+		data["result"] = result
+	}
+
+	renders.Template(rw, r, "admin-import.page.html", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// AdminPostImport imports the uploaded CSV file, the valid rows are stored and the others are listed with
+// what's wrong with them
+func (m *Repository) AdminPostImport(rw http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(rw, r.Body, maxImportSize)
+	err := r.ParseMultipartForm(maxImportSize)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("The file can't be read, it must be a CSV file of at most %d MB!", maxImportSize>>20))
+		http.Redirect(rw, r, "/admin/import", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("kind")
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		form.Errors.Add("file", "choose a CSV file")
+	} else {
+		defer file.Close()
+	}
+
+	if !form.Valid() {
+		m.renderAdminImport(rw, r, form, nil)
+		return
+	}
+
+	result, err := importer.New(m.DB).Import(r.Context(), form.Get("kind"), file)
+	if errors.Is(err, importer.ErrUnknownKind) {
+		form.Errors.Add("kind", err.Error())
+		m.renderAdminImport(rw, r, form, nil)
+		return
+	} else if errors.Is(err, importer.ErrInvalidFile) {
+		form.Errors.Add("file", err.Error())
+		m.renderAdminImport(rw, r, form, nil)
+		return
+	} else if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	if len(result.Errors) > 0 {
+		m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("Import done, %s!", result))
+	} else {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Import done, %s!", result))
+	}
+	m.renderAdminImport(rw, r, forms.New(nil), &result)
+}
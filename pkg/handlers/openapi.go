@@ -0,0 +1,345 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/amiranbari/bookings/internal/openapi"
+	"github.com/amiranbari/bookings/internal/xlsx"
+	"github.com/amiranbari/bookings/pkg/models"
+)
+
+// apiAvailability is the body of a successful availability search
+type apiAvailability struct {
+	StartDate string             `json:"start_date"`
+	EndDate   string             `json:"end_date"`
+	Rooms     []apiAvailableRoom `json:"rooms"`
+}
+
+// apiRooms is the body of the room list
+type apiRooms struct {
+	Rooms []apiRoom `json:"rooms"`
+}
+
+const (
+	// apiKeySecurity is the bearer API key the /api/v1 routes require
+	apiKeySecurity = "apiKey"
+	// sessionSecurity is the session cookie set by logging in
+	sessionSecurity = "session"
+)
+
+// OpenAPI serves the OpenAPI document describing every route of the app
+func (m *Repository) OpenAPI(rw http.ResponseWriter, r *http.Request) {
+	m.writeJSON(rw, http.StatusOK, APISpec())
+}
+
+// APISpec describes every route of the app. Routes added to route() must be added here too, the
+// route tests fail otherwise.
+func APISpec() *openapi.Document {
+	doc := openapi.New("Bookings", "1.0.0",
+		"The JSON API under /api/v1 is authenticated with an API key sent as a bearer token. "+
+			"The remaining routes serve the website and the admin dashboard.")
+	doc.Components.SecuritySchemes = map[string]openapi.SecurityScheme{
+		apiKeySecurity: {
+			Type:        "http",
+			Scheme:      "bearer",
+			Description: "API key issued by an owner under /admin/api-keys",
+		},
+		sessionSecurity: {
+			Type:        "apiKey",
+			In:          "cookie",
+			Name:        "session",
+			Description: "Session cookie of a logged in staff member",
+		},
+	}
+
+	addAPIRoutes(doc)
+	addSiteRoutes(doc)
+	addAdminRoutes(doc)
+
+	return doc
+}
+
+func addAPIRoutes(doc *openapi.Document) {
+	doc.Add("GET", "/api/openapi.json", openapi.Operation{
+		Summary: "This document",
+		Tags:    []string{"api"},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "The OpenAPI document", Content: openapi.JSON(map[string]interface{}{})},
+		},
+	})
+
+	dateParam := func(name, description string) openapi.Parameter {
+		return openapi.Parameter{
+			Name:        name,
+			In:          "query",
+			Description: description,
+			Required:    true,
+			Schema:      &openapi.Schema{Type: "string", Format: "date"},
+		}
+	}
+
+	doc.Add("GET", "/api/v1/availability", apiOperation(models.APIScopeRoomsRead, openapi.Operation{
+		Summary:     "Rooms that can be booked for a stay",
+		Description: "Rooms with a minimum stay longer than the searched stay are left out.",
+		Parameters: []openapi.Parameter{
+			dateParam("start_date", "Arrival"),
+			dateParam("end_date", "Departure"),
+			{Name: "guests", In: "query", Description: "Number of guests the room must fit", Schema: &openapi.Schema{Type: "integer"}},
+			{Name: "amenities", In: "query", Description: "Amenities the room must have, may be repeated", Schema: &openapi.Schema{Type: "array", Items: &openapi.Schema{Type: "string"}}},
+		},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "The available rooms with the price of the stay", Content: openapi.JSON(apiAvailability{})},
+			"422": apiErrorResponse("The dates are missing or invalid"),
+		},
+	}))
+
+	doc.Add("GET", "/api/v1/rooms", apiOperation(models.APIScopeRoomsRead, openapi.Operation{
+		Summary: "Every room that can be booked",
+		Responses: map[string]openapi.Response{
+			"200": {Description: "The rooms", Content: openapi.JSON(apiRooms{})},
+		},
+	}))
+
+	doc.Add("GET", "/api/v1/rooms/{id}", apiOperation(models.APIScopeRoomsRead, openapi.Operation{
+		Summary:    "A room",
+		Parameters: []openapi.Parameter{pathParam("id", "Room ID", "integer")},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "The room", Content: openapi.JSON(apiRoom{})},
+			"404": apiErrorResponse("There is no such room"),
+		},
+	}))
+
+	doc.Add("POST", "/api/v1/reservations", apiOperation(models.APIScopeReservationsWrite, openapi.Operation{
+		Summary:     "Book a room",
+		Description: "The guest gets the same confirmation mail as when booking on the website.",
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(apiNewReservation{})},
+		Responses: map[string]openapi.Response{
+			"201": {Description: "The reservation, its URL is in the Location header", Content: openapi.JSON(apiReservation{})},
+			"400": apiErrorResponse("The body is not valid JSON"),
+			"404": apiErrorResponse("There is no such room"),
+			"409": apiErrorResponse("The room is not available for those dates"),
+			"422": apiErrorResponse("A field is invalid or the stay is shorter than the minimum stay"),
+		},
+	}))
+
+	doc.Add("GET", "/api/v1/reservations/{code}", apiOperation(models.APIScopeReservationsRead, openapi.Operation{
+		Summary: "A reservation, looked up the way a guest does",
+		Parameters: []openapi.Parameter{
+			pathParam("code", "Confirmation code", "string"),
+			{Name: "email", In: "query", Description: "Email the reservation was made with", Required: true, Schema: &openapi.Schema{Type: "string", Format: "email"}},
+		},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "The reservation", Content: openapi.JSON(apiReservation{})},
+			"404": apiErrorResponse("No reservation with that code and email"),
+			"422": apiErrorResponse("The email is missing or invalid"),
+		},
+	}))
+
+	doc.Add("POST", "/api/v1/reservations/{code}/cancel", apiOperation(models.APIScopeReservationsWrite, openapi.Operation{
+		Summary:     "Cancel a reservation for the guest",
+		Parameters:  []openapi.Parameter{pathParam("code", "Confirmation code", "string")},
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(apiGuest{})},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "The cancelled reservation", Content: openapi.JSON(apiReservation{})},
+			"400": apiErrorResponse("The body is not valid JSON"),
+			"404": apiErrorResponse("No reservation with that code and email"),
+			"409": apiErrorResponse("The reservation can no longer be cancelled online"),
+			"422": apiErrorResponse("The email is missing or invalid"),
+		},
+	}))
+}
+
+func addSiteRoutes(doc *openapi.Document) {
+	doc.Add("GET", "/", pageOperation("Home page", "site"))
+	doc.Add("GET", "/about", pageOperation("About page", "site"))
+	doc.Add("GET", "/json", openapi.Operation{
+		Summary: "Availability of a room as JSON, used by the booking modal",
+		Tags:    []string{"site"},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "Whether the room is available", Content: openapi.JSON(map[string]interface{}{})},
+		},
+	})
+	doc.Add("GET", "/reservation", pageOperation("Reservation page", "site"))
+
+	doc.Add("GET", "/search", pageOperation("Search form", "booking"))
+	doc.Add("POST", "/search", pageOperation("Available rooms for the searched dates", "booking"))
+	doc.Add("GET", "/choose-room/{id}", redirectOperation("Pick a room from the search results", "booking", pathParam("id", "Room ID", "integer")))
+	doc.Add("GET", "/make-reservation", pageOperation("Guest details form", "booking"))
+	doc.Add("POST", "/make-reservation", redirectOperation("Book the chosen room", "booking"))
+	doc.Add("GET", "/rooms/{id}/calendar.ics", openapi.Operation{
+		Summary:     "Reservations and blocks of a room as an iCalendar feed",
+		Description: "The token is part of the feed link an owner creates on the room page.",
+		Tags:        []string{"calendar"},
+		Parameters: []openapi.Parameter{
+			pathParam("id", "Room ID", "integer"),
+			{Name: "token", In: "query", Description: "Calendar token of the room", Required: true, Schema: &openapi.Schema{Type: "string"}},
+		},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "The feed", Content: map[string]openapi.MediaType{"text/calendar": {Schema: &openapi.Schema{Type: "string"}}}},
+			"404": {Description: "There is no such room or the token is wrong"},
+		},
+	})
+
+	doc.Add("GET", "/my-reservation", pageOperation("Form to look up a reservation", "guest"))
+	doc.Add("POST", "/my-reservation", redirectOperation("Look up a reservation by confirmation code and email", "guest"))
+	doc.Add("GET", "/my-reservation/view", pageOperation("The guest's reservation", "guest"))
+	doc.Add("POST", "/my-reservation/dates", redirectOperation("Change the dates of the guest's reservation", "guest"))
+	doc.Add("POST", "/my-reservation/cancel", redirectOperation("Cancel the guest's reservation", "guest"))
+
+	doc.Add("GET", "/login", pageOperation("Login form", "account"))
+	doc.Add("POST", "/login", redirectOperation("Log in", "account"))
+	doc.Add("GET", "/login/two-factor", pageOperation("Form for the authentication code", "account"))
+	doc.Add("POST", "/login/two-factor", redirectOperation("Finish logging in with an authentication or recovery code", "account"))
+	doc.Add("GET", "/logout", redirectOperation("Log out", "account"))
+	doc.Add("GET", "/forgot-password", pageOperation("Form to request a password reset link", "account"))
+	doc.Add("POST", "/forgot-password", redirectOperation("Mail a password reset link", "account"))
+	doc.Add("GET", "/reset-password", pageOperation("Form to choose a new password", "account"))
+	doc.Add("POST", "/reset-password", redirectOperation("Set a new password", "account"))
+}
+
+func addAdminRoutes(doc *openapi.Document) {
+	id := pathParam("id", "ID", "integer")
+	sourceID := pathParam("sourceID", "Calendar source ID", "integer")
+
+	staff := func(method, path string, op openapi.Operation) {
+		doc.Add(method, path, adminOperation(models.AccessLevelStaff, op))
+	}
+	owner := func(method, path string, op openapi.Operation) {
+		doc.Add(method, path, adminOperation(models.AccessLevelOwner, op))
+	}
+
+	staff("GET", "/admin/dashboard", pageOperation("Dashboard", "admin"))
+	reservationList := []openapi.Parameter{
+		{Name: "q", In: "query", Description: "Part of the guest's name, email or phone", Schema: &openapi.Schema{Type: "string"}},
+		{Name: "sort", In: "query", Description: "Column to sort by, the start date by default", Schema: &openapi.Schema{Type: "string", Enum: models.ReservationSorts}},
+		{Name: "order", In: "query", Description: "asc or desc", Schema: &openapi.Schema{Type: "string", Enum: []string{"asc", "desc"}}},
+		{Name: "page", In: "query", Description: fmt.Sprintf("Page of %d reservations, from 1", reservationsPerPage), Schema: &openapi.Schema{Type: "integer"}},
+	}
+	staff("GET", "/admin/reservations", pageOperation("All reservations", "admin", append([]openapi.Parameter{
+		{Name: "status", In: "query", Description: "Only show reservations in this status", Schema: &openapi.Schema{Type: "string", Enum: models.ReservationStatuses}},
+	}, reservationList...)...))
+	staff("GET", "/admin/new-reservations", pageOperation("Reservations that weren't processed yet", "admin", reservationList...))
+	staff("GET", "/admin/reservations/export", openapi.Operation{
+		Summary: "Reservations as a spreadsheet",
+		Tags:    []string{"admin"},
+		Parameters: []openapi.Parameter{
+			{Name: "format", In: "query", Description: "csv by default", Schema: &openapi.Schema{Type: "string", Enum: []string{exportCSV, exportXLSX}}},
+			{Name: "from", In: "query", Description: "Earliest arrival", Schema: &openapi.Schema{Type: "string", Format: "date"}},
+			{Name: "to", In: "query", Description: "Latest arrival", Schema: &openapi.Schema{Type: "string", Format: "date"}},
+			{Name: "status", In: "query", Description: "Only export reservations in this status", Schema: &openapi.Schema{Type: "string", Enum: models.ReservationStatuses}},
+		},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "The spreadsheet", Content: map[string]openapi.MediaType{
+				"text/csv":       {Schema: &openapi.Schema{Type: "string"}},
+				xlsx.ContentType: {Schema: &openapi.Schema{Type: "string", Format: "binary"}},
+			}},
+			"303": {Description: "Redirect to the reservations when the parameters aren't valid"},
+		},
+	})
+	staff("GET", "/admin/reservations/{id}", pageOperation("A reservation", "admin", id))
+	staff("POST", "/admin/reservations/{id}", redirectOperation("Update a reservation", "admin", id))
+	staff("POST", "/admin/reservations/{id}/status", redirectOperation("Move a reservation to another status", "admin", id))
+	staff("POST", "/admin/reservations/{id}/move", redirectOperation("Move a reservation to another room or dates", "admin", id))
+	staff("GET", "/admin/reservations-calender", pageOperation("Calendar of reservations and blocks for every room", "admin",
+		openapi.Parameter{Name: "view", In: "query", Description: "Range shown, week, month or 3months", Schema: &openapi.Schema{Type: "string", Enum: []string{calendarWeek, calendarMonth, calendarQuarter}}},
+		openapi.Parameter{Name: "start", In: "query", Description: "A day in the range, defaults to today", Schema: &openapi.Schema{Type: "string", Format: "date"}},
+	))
+	staff("POST", "/admin/reservations-calender", redirectOperation("Block a room for a range of days", "admin"))
+	staff("POST", "/admin/blocks/{id}/delete", redirectOperation("Remove a block", "admin", id))
+	staff("GET", "/admin/profile", pageOperation("Profile of the logged in user", "admin"))
+	staff("POST", "/admin/profile", redirectOperation("Change password", "admin"))
+	staff("GET", "/admin/two-factor", pageOperation("Two-factor authentication settings", "admin"))
+	staff("POST", "/admin/two-factor", pageOperation("Turn on two-factor authentication, shows the recovery codes", "admin"))
+	staff("POST", "/admin/two-factor/disable", redirectOperation("Turn off two-factor authentication", "admin"))
+
+	owner("GET", "/admin/rooms", pageOperation("Rooms", "admin"))
+	owner("GET", "/admin/rooms/new", pageOperation("Form for a new room", "admin"))
+	owner("POST", "/admin/rooms/new", redirectOperation("Add a room", "admin"))
+	owner("GET", "/admin/rooms/{id}", pageOperation("A room with its seasonal rates", "admin", id))
+	owner("POST", "/admin/rooms/{id}", redirectOperation("Update a room", "admin", id))
+	owner("POST", "/admin/rooms/{id}/archive", redirectOperation("Archive a room", "admin", id))
+	owner("POST", "/admin/rooms/{id}/rates", redirectOperation("Add a seasonal rate to a room", "admin", id))
+	owner("POST", "/admin/rooms/{id}/calendar-token", redirectOperation("Create or reset the calendar feed link of a room", "admin", id))
+	owner("POST", "/admin/rooms/{id}/calendar-sources", redirectOperation("Import the calendar of another booking site into a room", "admin", id))
+	owner("POST", "/admin/rooms/{id}/calendar-sources/{sourceID}/sync", redirectOperation("Import a calendar right away", "admin", id, sourceID))
+	owner("POST", "/admin/rooms/{id}/calendar-sources/{sourceID}/delete", redirectOperation("Stop importing a calendar and remove its blocks", "admin", id, sourceID))
+	owner("POST", "/admin/rooms/{id}/rates/{rateID}/delete", redirectOperation("Delete a seasonal rate", "admin", id, pathParam("rateID", "Rate ID", "integer")))
+	owner("GET", "/admin/restrictions", pageOperation("Types of room restriction", "admin"))
+	owner("GET", "/admin/restrictions/new", pageOperation("Form for a new type of restriction", "admin"))
+	owner("POST", "/admin/restrictions/new", redirectOperation("Add a type of restriction", "admin"))
+	owner("GET", "/admin/restrictions/{id}", pageOperation("A type of restriction", "admin", id))
+	owner("POST", "/admin/restrictions/{id}", redirectOperation("Update a type of restriction", "admin", id))
+	owner("POST", "/admin/restrictions/{id}/delete", redirectOperation("Delete a type of restriction no room is restricted with", "admin", id))
+	owner("GET", "/admin/users", pageOperation("Staff members and pending invitations", "admin"))
+	owner("POST", "/admin/users", redirectOperation("Invite a staff member", "admin"))
+	owner("GET", "/admin/logins", pageOperation("Recent logins and locked out accounts", "admin"))
+	owner("POST", "/admin/logins/unlock", redirectOperation("Unlock an account", "admin"))
+	owner("GET", "/admin/api-keys", pageOperation("API keys", "admin"))
+	owner("POST", "/admin/api-keys", pageOperation("Issue an API key, shows the key once", "admin"))
+	owner("POST", "/admin/api-keys/{id}/revoke", redirectOperation("Revoke an API key", "admin", id))
+	owner("GET", "/admin/import", pageOperation("Form to import rooms or reservations from a CSV file", "admin"))
+	owner("POST", "/admin/import", pageOperation("Import rooms or reservations from a CSV file, shows the rows that weren't imported", "admin"))
+}
+
+// apiOperation adds the API key requirement to an /api/v1 operation
+func apiOperation(scope string, op openapi.Operation) openapi.Operation {
+	op.Tags = []string{"api"}
+	op.Description = strings.TrimSpace(fmt.Sprintf("%s Requires the %s scope.", op.Description, scope))
+	op.Security = []map[string][]string{{apiKeySecurity: {}}}
+
+	op.Responses["401"] = apiErrorResponse("The API key is missing, unknown or revoked")
+	op.Responses["403"] = apiErrorResponse("The API key lacks the required scope")
+	op.Responses["500"] = apiErrorResponse("Something went wrong")
+	return op
+}
+
+// adminOperation adds the login requirement to an /admin operation
+func adminOperation(level int, op openapi.Operation) openapi.Operation {
+	role := "staff members"
+	if level >= models.AccessLevelOwner {
+		role = "owners"
+	}
+	op.Description = fmt.Sprintf("Only for %s, others are redirected to the login page.", role)
+	op.Security = []map[string][]string{{sessionSecurity: {}}}
+	return op
+}
+
+func pageOperation(summary, tag string, params ...openapi.Parameter) openapi.Operation {
+	return openapi.Operation{
+		Summary:    summary,
+		Tags:       []string{tag},
+		Parameters: params,
+		Responses: map[string]openapi.Response{
+			"200": {Description: "HTML page", Content: openapi.HTML()},
+		},
+	}
+}
+
+// redirectOperation describes a route that does its work and redirects, results are shown as a message
+// on the next page
+func redirectOperation(summary, tag string, params ...openapi.Parameter) openapi.Operation {
+	return openapi.Operation{
+		Summary:    summary,
+		Tags:       []string{tag},
+		Parameters: params,
+		Responses: map[string]openapi.Response{
+			"303": {Description: "Redirect to the next page"},
+		},
+	}
+}
+
+func pathParam(name, description, typ string) openapi.Parameter {
+	return openapi.Parameter{
+		Name:        name,
+		In:          "path",
+		Description: description,
+		Required:    true,
+		Schema:      &openapi.Schema{Type: typ},
+	}
+}
+
+func apiErrorResponse(description string) openapi.Response {
+	return openapi.Response{Description: description, Content: openapi.JSON(apiError{})}
+}
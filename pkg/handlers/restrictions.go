@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/amiranbari/bookings/internal/forms"
+	"github.com/amiranbari/bookings/internal/helpers"
+	"github.com/amiranbari/bookings/internal/repository"
+	"github.com/amiranbari/bookings/pkg/models"
+	"github.com/amiranbari/bookings/pkg/renders"
+)
+
+var (
+	restrictionCode   = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	restrictionColour = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+)
+
+// AdminRestrictions lists the types of restriction rooms can have
+func (m *Repository) AdminRestrictions(rw http.ResponseWriter, r *http.Request) {
+	restrictions, err := m.DB.AllRestrictions(r.Context())
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["restrictions"] = restrictions
+	renders.Template(rw, r, "admin-restrictions.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+		Data: data,
+	})
+}
+
+// AdminNewRestriction shows the form to create a type of restriction
+func (m *Repository) AdminNewRestriction(rw http.ResponseWriter, r *http.Request) {
+	data := make(map[string]interface{})
+	data["restriction"] = models.Restriction{Colour: "#6c757d", BlocksAvailability: true}
+	renders.Template(rw, r, "admin-restriction.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+		Data: data,
+	})
+}
+
+// AdminPostNewRestriction creates a type of restriction
+func (m *Repository) AdminPostNewRestriction(rw http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+
+	var restriction models.Restriction
+	restrictionFromForm(&restriction, form)
+	m.validateRestrictionForm(r, form, restriction)
+
+	if !form.Valid() {
+		m.renderAdminRestriction(rw, r, form, restriction)
+		return
+	}
+
+	_, err = m.DB.InsertRestriction(r.Context(), restriction)
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Restriction successfully created.")
+	http.Redirect(rw, r, "/admin/restrictions", http.StatusSeeOther)
+}
+
+// AdminShowRestriction shows the form to edit a type of restriction
+func (m *Repository) AdminShowRestriction(rw http.ResponseWriter, r *http.Request) {
+	restriction, ok := m.adminRestriction(rw, r)
+	if !ok {
+		return
+	}
+
+	m.renderAdminRestriction(rw, r, forms.New(nil), restriction)
+}
+
+// AdminPostShowRestriction updates a type of restriction, built in ones keep their code and always
+// block availability
+func (m *Repository) AdminPostShowRestriction(rw http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	restriction, ok := m.adminRestriction(rw, r)
+	if !ok {
+		return
+	}
+
+	form := forms.New(r.PostForm)
+
+	builtIn := restriction.BuiltIn()
+	code := restriction.Code
+	restrictionFromForm(&restriction, form)
+	if builtIn {
+		restriction.Code = code
+		restriction.BlocksAvailability = true
+	}
+	m.validateRestrictionForm(r, form, restriction)
+
+	if !form.Valid() {
+		m.renderAdminRestriction(rw, r, form, restriction)
+		return
+	}
+
+	err = m.DB.UpdateRestriction(r.Context(), restriction)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		form.Errors.Add("blocks_availability", "some of these restrictions overlap reservations or blocks, remove them first")
+		m.renderAdminRestriction(rw, r, form, restriction)
+		return
+	} else if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't update restriction!")
+		http.Redirect(rw, r, "/admin/restrictions", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Restriction successfully updated.")
+	http.Redirect(rw, r, "/admin/restrictions", http.StatusSeeOther)
+}
+
+// AdminPostDeleteRestriction removes a type of restriction no room is restricted with
+func (m *Repository) AdminPostDeleteRestriction(rw http.ResponseWriter, r *http.Request) {
+	restriction, ok := m.adminRestriction(rw, r)
+	if !ok {
+		return
+	}
+
+	if restriction.BuiltIn() {
+		m.App.Session.Put(r.Context(), "error", "Built in restrictions can't be deleted!")
+		http.Redirect(rw, r, "/admin/restrictions", http.StatusSeeOther)
+		return
+	}
+
+	err := m.DB.DeleteRestriction(r.Context(), restriction.ID)
+	if errors.Is(err, repository.ErrRestrictionInUse) {
+		m.App.Session.Put(r.Context(), "error", "Rooms are still restricted with it, remove those restrictions first!")
+		http.Redirect(rw, r, "/admin/restrictions", http.StatusSeeOther)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "can't find restriction!")
+		http.Redirect(rw, r, "/admin/restrictions", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't delete restriction!")
+		http.Redirect(rw, r, "/admin/restrictions", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Restriction successfully deleted.")
+	http.Redirect(rw, r, "/admin/restrictions", http.StatusSeeOther)
+}
+
+// adminRestriction looks up the restriction in the url, redirecting to the list if there is none
+func (m *Repository) adminRestriction(rw http.ResponseWriter, r *http.Request) (models.Restriction, bool) {
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "missing url parameter")
+		http.Redirect(rw, r, "/admin/restrictions", http.StatusSeeOther)
+		return models.Restriction{}, false
+	}
+
+	restriction, err := m.DB.GetRestrictionByID(r.Context(), id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't find restriction!")
+		http.Redirect(rw, r, "/admin/restrictions", http.StatusSeeOther)
+		return models.Restriction{}, false
+	}
+
+	return restriction, true
+}
+
+func (m *Repository) renderAdminRestriction(rw http.ResponseWriter, r *http.Request, form *forms.Form, restriction models.Restriction) {
+	data := make(map[string]interface{})
+	data["restriction"] = restriction
+	renders.Template(rw, r, "admin-restriction.page.html", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// validateRestrictionForm checks the fields posted by the restriction forms, codes have to be unique
+func (m *Repository) validateRestrictionForm(r *http.Request, form *forms.Form, restriction models.Restriction) {
+	form.Required("name")
+	form.MaxLength("name", 255)
+
+	if !restrictionCode.MatchString(restriction.Code) {
+		form.Errors.Add("code", "use lowercase letters, digits and dashes")
+	} else if other, err := m.DB.GetRestrictionByCode(r.Context(), restriction.Code); err == nil && other.ID != restriction.ID {
+		form.Errors.Add("code", "this code is already in use")
+	}
+
+	if !restrictionColour.MatchString(restriction.Colour) {
+		form.Errors.Add("colour", "choose a colour")
+	}
+}
+
+// restrictionFromForm copies the posted restriction fields to restriction
+func restrictionFromForm(restriction *models.Restriction, form *forms.Form) {
+	restriction.Code = strings.ToLower(strings.TrimSpace(form.Get("code")))
+	restriction.Name = strings.TrimSpace(form.Get("name"))
+	restriction.Colour = form.Get("colour")
+	restriction.BlocksAvailability = form.Get("blocks_availability") != ""
+	restriction.GuestVisible = form.Get("guest_visible") != ""
+}
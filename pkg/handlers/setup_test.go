@@ -4,6 +4,8 @@ import (
 	"encoding/gob"
 	"fmt"
 	"github.com/alexedwards/scs/v2"
+	"github.com/amiranbari/bookings/internal/helpers"
+	"github.com/amiranbari/bookings/internal/pricing"
 	"github.com/amiranbari/bookings/pkg/config"
 	"github.com/amiranbari/bookings/pkg/models"
 	"github.com/amiranbari/bookings/pkg/renders"
@@ -15,6 +17,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -23,9 +26,12 @@ var app config.AppConfig
 var session *scs.SessionManager
 var pathToTemplates = "templates"
 var functions = template.FuncMap{
-	"humanDate":  renders.HumanDate,
-	"formatDate": renders.FormatDate,
-	"iterate":    renders.Iterate,
+	"humanDate":   renders.HumanDate,
+	"formatDate":  renders.FormatDate,
+	"iterate":     renders.Iterate,
+	"formatPrice": renders.FormatPrice,
+	"join":        strings.Join,
+	"statusColor": renders.StatusColor,
 }
 
 func listenForMail() {
@@ -44,9 +50,12 @@ func TestMain(m *testing.M) {
 	gob.Register(models.Restriction{})
 	gob.Register(models.RoomRestriction{})
 	gob.Register(map[string]int{})
+	gob.Register(pricing.Quote{})
 
 	// change this to true in production
 	app.InProduction = false
+	app.SecretKey = []byte("test-secret")
+	app.BaseURL = "http://localhost:8000"
 
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	app.InfoLog = infoLog
@@ -80,6 +89,7 @@ func TestMain(m *testing.M) {
 	NewHandlers(repo)
 
 	renders.NewRenderer(&app)
+	helpers.NewHelpers(&app)
 
 	os.Exit(m.Run())
 }
@@ -158,21 +168,79 @@ func getRoutes() http.Handler {
 	mux.Get("/choose-room/{id}", Repo.ChooseRoom)
 	mux.Get("/make-reservation", Repo.MakeReservation)
 	mux.Post("/make-reservation", Repo.PostReservation)
+	mux.Get("/rooms/{id}/calendar.ics", Repo.RoomCalendar)
+
+	mux.Get("/my-reservation", Repo.MyReservation)
+	mux.Post("/my-reservation", Repo.PostMyReservation)
+	mux.Get("/my-reservation/view", Repo.MyReservationView)
+	mux.Post("/my-reservation/dates", Repo.PostMyReservationDates)
+	mux.Post("/my-reservation/cancel", Repo.PostMyReservationCancel)
+
+	//api
+	mux.Get("/api/openapi.json", Repo.OpenAPI)
+	mux.Get("/api/v1/availability", Repo.APIAvailability)
+	mux.Get("/api/v1/rooms", Repo.APIRooms)
+	mux.Get("/api/v1/rooms/{id}", Repo.APIRoom)
+	mux.Post("/api/v1/reservations", Repo.APIPostReservation)
+	mux.Get("/api/v1/reservations/{code}", Repo.APIReservation)
+	mux.Post("/api/v1/reservations/{code}/cancel", Repo.APIPostReservationCancel)
 
 	//user
 	mux.Get("/login", Repo.Login)
 	mux.Post("/login", Repo.PostLogin)
+	mux.Get("/login/two-factor", Repo.LoginTwoFactor)
+	mux.Post("/login/two-factor", Repo.PostLoginTwoFactor)
 	mux.Get("/logout", Repo.Logout)
+	mux.Get("/forgot-password", Repo.ForgotPassword)
+	mux.Post("/forgot-password", Repo.PostForgotPassword)
+	mux.Get("/reset-password", Repo.ResetPassword)
+	mux.Post("/reset-password", Repo.PostResetPassword)
 
 	mux.Get("/admin/dashboard", Repo.Dashboard)
 	mux.Get("/admin/reservations", Repo.AdminReservations)
 	mux.Get("/admin/new-reservations", Repo.AdminNewReservations)
+	mux.Get("/admin/reservations/export", Repo.AdminReservationsExport)
 	mux.Get("/admin/reservations/{id}", Repo.AdminShowReservations)
 	mux.Post("/admin/reservations/{id}", Repo.AdminPostShowReservations)
-	mux.Get("/admin/reservations/{id}/processed", Repo.AdminPutShowReservations)
-	mux.Get("/admin/reservations/{id}/delete", Repo.AdminDeleteReservation)
+	mux.Post("/admin/reservations/{id}/status", Repo.AdminPostReservationStatus)
+	mux.Post("/admin/reservations/{id}/move", Repo.AdminPostMoveReservation)
 	mux.Get("/admin/reservations-calender", Repo.AdminReservationsCalender)
 	mux.Post("/admin/reservations-calender", Repo.AdminPostReservationsCalender)
+	mux.Post("/admin/blocks/{id}/delete", Repo.AdminPostDeleteBlock)
+
+	mux.Get("/admin/rooms", Repo.AdminRooms)
+	mux.Get("/admin/rooms/new", Repo.AdminNewRoom)
+	mux.Post("/admin/rooms/new", Repo.AdminPostNewRoom)
+	mux.Get("/admin/rooms/{id}", Repo.AdminShowRoom)
+	mux.Post("/admin/rooms/{id}", Repo.AdminPostShowRoom)
+	mux.Post("/admin/rooms/{id}/archive", Repo.AdminPostArchiveRoom)
+	mux.Post("/admin/rooms/{id}/rates", Repo.AdminPostRoomRate)
+	mux.Post("/admin/rooms/{id}/calendar-token", Repo.AdminPostRoomCalendarToken)
+	mux.Post("/admin/rooms/{id}/calendar-sources", Repo.AdminPostCalendarSource)
+	mux.Post("/admin/rooms/{id}/calendar-sources/{sourceID}/sync", Repo.AdminPostSyncCalendarSource)
+	mux.Post("/admin/rooms/{id}/calendar-sources/{sourceID}/delete", Repo.AdminPostDeleteCalendarSource)
+	mux.Post("/admin/rooms/{id}/rates/{rateID}/delete", Repo.AdminPostDeleteRoomRate)
+	mux.Get("/admin/restrictions", Repo.AdminRestrictions)
+	mux.Get("/admin/restrictions/new", Repo.AdminNewRestriction)
+	mux.Post("/admin/restrictions/new", Repo.AdminPostNewRestriction)
+	mux.Get("/admin/restrictions/{id}", Repo.AdminShowRestriction)
+	mux.Post("/admin/restrictions/{id}", Repo.AdminPostShowRestriction)
+	mux.Post("/admin/restrictions/{id}/delete", Repo.AdminPostDeleteRestriction)
+
+	mux.Get("/admin/users", Repo.AdminUsers)
+	mux.Post("/admin/users", Repo.AdminPostNewUser)
+	mux.Get("/admin/logins", Repo.AdminLogins)
+	mux.Post("/admin/logins/unlock", Repo.AdminPostUnlockLogin)
+	mux.Get("/admin/api-keys", Repo.AdminAPIKeys)
+	mux.Post("/admin/api-keys", Repo.AdminPostNewAPIKey)
+	mux.Post("/admin/api-keys/{id}/revoke", Repo.AdminPostRevokeAPIKey)
+	mux.Get("/admin/import", Repo.AdminImport)
+	mux.Post("/admin/import", Repo.AdminPostImport)
+	mux.Get("/admin/profile", Repo.Profile)
+	mux.Post("/admin/profile", Repo.PostProfilePassword)
+	mux.Get("/admin/two-factor", Repo.TwoFactor)
+	mux.Post("/admin/two-factor", Repo.PostTwoFactor)
+	mux.Post("/admin/two-factor/disable", Repo.PostTwoFactorDisable)
 
 	fileServer := http.FileServer(http.Dir("../../static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
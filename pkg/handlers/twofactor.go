@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/amiranbari/bookings/internal/forms"
+	"github.com/amiranbari/bookings/internal/helpers"
+	"github.com/amiranbari/bookings/internal/totp"
+	"github.com/amiranbari/bookings/pkg/models"
+	"github.com/amiranbari/bookings/pkg/renders"
+)
+
+const (
+	// totpIssuer is the account name shown in authenticator apps
+	totpIssuer = "Bookings"
+	// pendingLoginTTL is how long a user has to enter the code after their password was accepted
+	pendingLoginTTL = 5 * time.Minute
+	// recoveryCodeCount is how many recovery codes are handed out when two-factor authentication is set up
+	recoveryCodeCount = 10
+)
+
+// logIn finishes a login, from here on the user can reach the admin pages
+func (m *Repository) logIn(ctx context.Context, userID int) {
+	m.App.Session.Remove(ctx, "pending_user_id")
+	m.App.Session.Remove(ctx, "pending_login_at")
+	m.App.Session.Put(ctx, "user_id", userID)
+}
+
+// pendingLogin returns the user whose password was accepted but who still has to enter their code
+func (m *Repository) pendingLogin(ctx context.Context) (models.User, bool) {
+	id := m.App.Session.GetInt(ctx, "pending_user_id")
+	at := time.Unix(m.App.Session.GetInt64(ctx, "pending_login_at"), 0)
+
+	if id == 0 || time.Since(at) > pendingLoginTTL {
+		return models.User{}, false
+	}
+
+	user, err := m.DB.GetUserByID(ctx, id)
+	if err != nil || !user.TOTPEnabled {
+		return models.User{}, false
+	}
+
+	return user, true
+}
+
+func (m *Repository) redirectPendingLogin(rw http.ResponseWriter, r *http.Request) {
+	m.App.Session.Remove(r.Context(), "pending_user_id")
+	m.App.Session.Remove(r.Context(), "pending_login_at")
+	m.App.Session.Put(r.Context(), "error", "Your login has expired, please log in again.")
+	http.Redirect(rw, r, "/login", http.StatusSeeOther)
+}
+
+// LoginTwoFactor shows the form for the second login step
+func (m *Repository) LoginTwoFactor(rw http.ResponseWriter, r *http.Request) {
+	if _, ok := m.pendingLogin(r.Context()); !ok {
+		m.redirectPendingLogin(rw, r)
+		return
+	}
+
+	renders.Template(rw, r, "login-two-factor.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostLoginTwoFactor logs the user in once the code from their authenticator app, or one of their
+// recovery codes, checks out. Wrong codes count as failed logins.
+func (m *Repository) PostLoginTwoFactor(rw http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	user, ok := m.pendingLogin(r.Context())
+	if !ok {
+		m.redirectPendingLogin(rw, r)
+		return
+	}
+
+	ip := clientIP(r)
+
+	wait, err := m.loginWait(r.Context(), user.Email, ip)
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+	if wait > 0 {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Too many failed login attempts, please try again in %s.", wait.Round(time.Second)))
+		http.Redirect(rw, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+	if !form.Valid() {
+		renders.Template(rw, r, "login-two-factor.page.html", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	code := strings.TrimSpace(form.Get("code"))
+	recovery := false
+
+	valid := totp.Validate(user.TOTPSecret, code, time.Now())
+	if !valid && len(code) > totp.Digits {
+		valid, err = m.DB.UseRecoveryCode(r.Context(), user.ID, totp.HashRecoveryCode(code))
+		if err != nil {
+			helpers.ServerError(rw, err)
+			return
+		}
+		recovery = valid
+	}
+
+	if !valid {
+		m.recordLogin(r.Context(), user.Email, ip, models.LoginFailure)
+		m.App.Session.Put(r.Context(), "error", "invalid authentication code")
+		http.Redirect(rw, r, "/login/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	m.recordLogin(r.Context(), user.Email, ip, models.LoginSuccess)
+
+	_ = m.App.Session.RenewToken(r.Context())
+	m.logIn(r.Context(), user.ID)
+
+	if recovery {
+		left, err := m.DB.RemainingRecoveryCodes(r.Context(), user.ID)
+		if err != nil {
+			helpers.ServerError(rw, err)
+			return
+		}
+		m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("You logged in with a recovery code, %d left.", left))
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
+	http.Redirect(rw, r, "/", http.StatusSeeOther)
+}
+
+// TwoFactor shows whether two-factor authentication is set up for the logged in user, and if not
+// the secret to add to an authenticator app
+func (m *Repository) TwoFactor(rw http.ResponseWriter, r *http.Request) {
+	m.renderTwoFactor(rw, r, forms.New(nil), nil)
+}
+
+func (m *Repository) renderTwoFactor(rw http.ResponseWriter, r *http.Request, form *forms.Form, recoveryCodes []string) {
+	user, err := m.DB.GetUserByID(r.Context(), m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["user"] = user
+	data["required"] = user.TwoFactorRequired(m.App.TwoFactorLevel)
+	stringMap := make(map[string]string)
+
+	if user.TOTPEnabled {
+		data["recovery_codes"] = recoveryCodes
+		data["remaining"], err = m.DB.RemainingRecoveryCodes(r.Context(), user.ID)
+		if err != nil {
+			helpers.ServerError(rw, err)
+			return
+		}
+	} else {
+		// the secret is only stored for the user once they proved their app is set up with it
+		secret := m.App.Session.GetString(r.Context(), "totp_secret")
+		if secret == "" {
+			secret, err = totp.NewSecret()
+			if err != nil {
+				helpers.ServerError(rw, err)
+				return
+			}
+			m.App.Session.Put(r.Context(), "totp_secret", secret)
+		}
+
+		stringMap["secret"] = secret
+		stringMap["uri"] = totp.ProvisioningURI(totpIssuer, user.Email, secret)
+	}
+
+	renders.Template(rw, r, "admin-two-factor.page.html", &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// PostTwoFactor turns on two-factor authentication once the user entered a code from their app, and
+// shows the recovery codes once
+func (m *Repository) PostTwoFactor(rw http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+
+	secret := m.App.Session.GetString(r.Context(), "totp_secret")
+	if secret == "" {
+		m.App.Session.Put(r.Context(), "error", "Please scan the code again.")
+		http.Redirect(rw, r, "/admin/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+	if form.Valid() && !totp.Validate(secret, form.Get("code"), time.Now()) {
+		form.Errors.Add("code", "this code is not valid, check the time on your phone")
+	}
+
+	if !form.Valid() {
+		m.renderTwoFactor(rw, r, form, nil)
+		return
+	}
+
+	codes, err := totp.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = totp.HashRecoveryCode(code)
+	}
+
+	err = m.DB.EnableTOTP(r.Context(), userID, secret, hashes)
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	m.App.Session.Remove(r.Context(), "totp_secret")
+	_ = m.App.Session.RenewToken(r.Context())
+
+	// rendered instead of redirected so the recovery codes never end up in the session
+	m.App.Session.Put(r.Context(), "flash", "Two-factor authentication is on.")
+	m.renderTwoFactor(rw, r, forms.New(nil), codes)
+}
+
+// PostTwoFactorDisable turns off two-factor authentication after checking the user's password
+func (m *Repository) PostTwoFactorDisable(rw http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	user, err := m.DB.GetUserByID(r.Context(), m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	if user.TwoFactorRequired(m.App.TwoFactorLevel) {
+		m.App.Session.Put(r.Context(), "error", "Two-factor authentication is required for your account.")
+		http.Redirect(rw, r, "/admin/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("current_password")
+
+	if form.Valid() {
+		_, _, err = m.DB.Authenticate(r.Context(), user.Email, form.Get("current_password"))
+		if err != nil {
+			form.Errors.Add("current_password", "this is not your current password")
+		}
+	}
+
+	if !form.Valid() {
+		m.renderTwoFactor(rw, r, form, nil)
+		return
+	}
+
+	err = m.DB.DisableTOTP(r.Context(), user.ID)
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Two-factor authentication is off.")
+	http.Redirect(rw, r, "/admin/two-factor", http.StatusSeeOther)
+}
@@ -0,0 +1,460 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amiranbari/bookings/internal/forms"
+	"github.com/amiranbari/bookings/internal/helpers"
+	"github.com/amiranbari/bookings/internal/lockout"
+	"github.com/amiranbari/bookings/internal/tokens"
+	"github.com/amiranbari/bookings/pkg/models"
+	"github.com/amiranbari/bookings/pkg/renders"
+)
+
+const (
+	// resetTokenTTL is how long a forgotten password link stays valid
+	resetTokenTTL = time.Hour
+	// inviteTokenTTL is how long a new staff member has to choose a password
+	inviteTokenTTL    = 72 * time.Hour
+	minPasswordLength = 8
+)
+
+// AdminUsers lists every user together with the form to invite a new one
+func (m *Repository) AdminUsers(rw http.ResponseWriter, r *http.Request) {
+	m.renderAdminUsers(rw, r, forms.New(nil))
+}
+
+func (m *Repository) renderAdminUsers(rw http.ResponseWriter, r *http.Request, form *forms.Form) {
+	users, err := m.DB.AllUsers(r.Context())
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["users"] = users
+
+	renders.Template(rw, r, "admin-users.page.html", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// AdminPostNewUser creates a user without a usable password and emails them a link to choose one
+func (m *Repository) AdminPostNewUser(rw http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email", "access_level")
+	form.IsEmail("email")
+	form.MaxLength("first_name", 255)
+	form.MaxLength("last_name", 255)
+	if form.IsInt("access_level", models.AccessLevelStaff) {
+		if level, _ := strconv.Atoi(form.Get("access_level")); level > models.AccessLevelOwner {
+			form.Errors.Add("access_level", "unknown access level")
+		}
+	}
+
+	if !form.Valid() {
+		m.renderAdminUsers(rw, r, form)
+		return
+	}
+
+	password, err := randomPassword()
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	user := models.User{
+		FirstName: form.Get("first_name"),
+		LastName:  form.Get("last_name"),
+		Email:     strings.ToLower(strings.TrimSpace(form.Get("email"))),
+		Password:  password,
+	}
+	user.AccessLevel, _ = strconv.Atoi(form.Get("access_level"))
+
+	user.ID, err = m.DB.InsertUser(r.Context(), user)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't create user, is the email already taken?")
+		http.Redirect(rw, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	err = m.sendPasswordLink(r.Context(), user.ID, inviteTokenTTL, "You have been invited",
+		fmt.Sprintf("An account has been created for you, please choose a password within %d hours:", int(inviteTokenTTL.Hours())))
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Invitation sent to %s.", user.Email))
+	http.Redirect(rw, r, "/admin/users", http.StatusSeeOther)
+}
+
+// ForgotPassword shows the form to request a password reset link
+func (m *Repository) ForgotPassword(rw http.ResponseWriter, r *http.Request) {
+	renders.Template(rw, r, "forgot-password.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostForgotPassword emails a reset link if the address belongs to a user. The response is the same
+// either way so the form can't be used to find out who has an account.
+func (m *Repository) PostForgotPassword(rw http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.IsEmail("email")
+
+	if !form.Valid() {
+		renders.Template(rw, r, "forgot-password.page.html", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	user, err := m.DB.GetUserByEmail(r.Context(), strings.TrimSpace(form.Get("email")))
+	if err == nil {
+		err = m.sendPasswordLink(r.Context(), user.ID, resetTokenTTL, "Reset your password",
+			"Someone asked to reset the password of your account, if it was you use the link below within an hour:")
+		if err != nil {
+			helpers.ServerError(rw, err)
+			return
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "If an account exists for that email, a reset link is on its way.")
+	http.Redirect(rw, r, "/login", http.StatusSeeOther)
+}
+
+// ResetPassword shows the form to choose a new password for a valid reset link
+func (m *Repository) ResetPassword(rw http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	_, err := m.userFromToken(r.Context(), token)
+	if err != nil {
+		m.redirectInvalidToken(rw, r, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["token"] = token
+
+	renders.Template(rw, r, "reset-password.page.html", &models.TemplateData{
+		Form:      forms.New(nil),
+		StringMap: stringMap,
+	})
+}
+
+// PostResetPassword stores the new password, which also invalidates the link
+func (m *Repository) PostResetPassword(rw http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	token := r.Form.Get("token")
+
+	user, err := m.userFromToken(r.Context(), token)
+	if err != nil {
+		m.redirectInvalidToken(rw, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	validatePasswordForm(form)
+
+	if !form.Valid() {
+		stringMap := make(map[string]string)
+		stringMap["token"] = token
+
+		renders.Template(rw, r, "reset-password.page.html", &models.TemplateData{
+			Form:      form,
+			StringMap: stringMap,
+		})
+		return
+	}
+
+	err = m.DB.UpdatePassword(r.Context(), user.ID, form.Get("password"))
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your password has been changed, you can log in now.")
+	http.Redirect(rw, r, "/login", http.StatusSeeOther)
+}
+
+// Profile shows the logged in user's details and the change password form
+func (m *Repository) Profile(rw http.ResponseWriter, r *http.Request) {
+	m.renderProfile(rw, r, forms.New(nil))
+}
+
+func (m *Repository) renderProfile(rw http.ResponseWriter, r *http.Request, form *forms.Form) {
+	user, err := m.DB.GetUserByID(r.Context(), m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["user"] = user
+
+	renders.Template(rw, r, "admin-profile.page.html", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// PostProfilePassword changes the logged in user's password after checking the current one
+func (m *Repository) PostProfilePassword(rw http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	user, err := m.DB.GetUserByID(r.Context(), m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("current_password")
+	validatePasswordForm(form)
+
+	if form.Valid() {
+		_, _, err = m.DB.Authenticate(r.Context(), user.Email, form.Get("current_password"))
+		if err != nil {
+			form.Errors.Add("current_password", "this is not your current password")
+		}
+	}
+
+	if !form.Valid() {
+		m.renderProfile(rw, r, form)
+		return
+	}
+
+	err = m.DB.UpdatePassword(r.Context(), user.ID, form.Get("password"))
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	_ = m.App.Session.RenewToken(r.Context())
+
+	m.App.Session.Put(r.Context(), "flash", "Your password has been changed.")
+	http.Redirect(rw, r, "/admin/profile", http.StatusSeeOther)
+}
+
+func validatePasswordForm(form *forms.Form) {
+	form.Required("password", "password_confirmation")
+	form.MinLength("password", minPasswordLength)
+	form.MaxLength("password", 72) // bcrypt ignores anything longer
+	form.Matches("password_confirmation", "password")
+}
+
+// sendPasswordLink emails the user a signed link to choose a new password, valid for ttl
+func (m *Repository) sendPasswordLink(ctx context.Context, userID int, ttl time.Duration, subject, intro string) error {
+	user, err := m.DB.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	token := tokens.Sign(m.App.SecretKey, user.ID, user.Password, time.Now().Add(ttl))
+	link := fmt.Sprintf("%s/reset-password?token=%s", m.App.BaseURL, url.QueryEscape(token))
+
+	html := fmt.Sprintf(`
+		<strong>%s</strong><br>
+		Dear %s: <br>
+		%s<br>
+		<a href="%s">%s</a>
+		`, subject, user.FirstName, intro, link, link)
+
+	m.App.MailChan <- models.MailData{
+		To:      user.Email,
+		From:    "me@here.com",
+		Subject: subject,
+		Content: html,
+	}
+
+	return nil
+}
+
+// userFromToken returns the user a password reset token was issued for, if it is still valid
+func (m *Repository) userFromToken(ctx context.Context, token string) (models.User, error) {
+	id, err := tokens.UserID(token)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	user, err := m.DB.GetUserByID(ctx, id)
+	if err != nil {
+		return models.User{}, tokens.ErrInvalidToken
+	}
+
+	err = tokens.Verify(m.App.SecretKey, token, user.Password, time.Now())
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+func (m *Repository) redirectInvalidToken(rw http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, tokens.ErrExpiredToken) {
+		m.App.Session.Put(r.Context(), "error", "This link has expired, please request a new one.")
+	} else {
+		m.App.Session.Put(r.Context(), "error", "This link is not valid, please request a new one.")
+	}
+	http.Redirect(rw, r, "/forgot-password", http.StatusSeeOther)
+}
+
+// randomPassword returns a password nobody knows, used until an invited user chooses their own
+func randomPassword() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+var (
+	// accountLockout slows down guessing the password of a single account
+	accountLockout = lockout.Policy{FreeAttempts: 5, BaseDelay: 30 * time.Second, MaxDelay: time.Hour}
+	// ipLockout slows down a single client trying many accounts
+	ipLockout = lockout.Policy{FreeAttempts: 20, BaseDelay: time.Minute, MaxDelay: time.Hour}
+)
+
+// lockoutWindow is how far back failed logins are counted
+const lockoutWindow = 24 * time.Hour
+
+// loginWait returns how long logins for email from ip are refused because of earlier failures
+func (m *Repository) loginWait(ctx context.Context, email, ip string) (time.Duration, error) {
+	since := time.Now().Add(-lockoutWindow)
+
+	byEmail, err := m.DB.LoginFailuresByEmail(ctx, email, since)
+	if err != nil {
+		return 0, err
+	}
+
+	byIP, err := m.DB.LoginFailuresByIP(ctx, ip, since)
+	if err != nil {
+		return 0, err
+	}
+
+	until := accountLockout.LockedUntil(byEmail.Count, byEmail.Last)
+	if ipUntil := ipLockout.LockedUntil(byIP.Count, byIP.Last); ipUntil.After(until) {
+		until = ipUntil
+	}
+
+	if wait := time.Until(until); wait > 0 {
+		return wait, nil
+	}
+	return 0, nil
+}
+
+// recordLogin adds a login attempt to the audit log. A failure to record is logged rather than
+// refusing the login, so a database hiccup doesn't lock everybody out.
+func (m *Repository) recordLogin(ctx context.Context, email, ip, event string) {
+	err := m.DB.InsertLoginAttempt(ctx, models.LoginAttempt{
+		Email:     email,
+		IPAddress: ip,
+		Event:     event,
+	})
+	if err != nil {
+		m.App.ErrorLog.Println("can't record login attempt:", err)
+	}
+}
+
+// clientIP returns the address the request came from, without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// AdminLogins shows the accounts that are currently locked out and the latest login attempts
+func (m *Repository) AdminLogins(rw http.ResponseWriter, r *http.Request) {
+	failures, err := m.DB.AllLoginFailures(r.Context(), time.Now().Add(-lockoutWindow))
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	var locked []models.LoginFailures
+	for _, f := range failures {
+		if accountLockout.Locked(f.Count, f.Last, time.Now()) {
+			f.LockedUntil = accountLockout.LockedUntil(f.Count, f.Last)
+			locked = append(locked, f)
+		}
+	}
+
+	attempts, err := m.DB.RecentLoginAttempts(r.Context(), 100)
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["locked"] = locked
+	data["attempts"] = attempts
+
+	renders.Template(rw, r, "admin-logins.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+		Data: data,
+	})
+}
+
+// AdminPostUnlockLogin clears the failed logins of an account
+func (m *Repository) AdminPostUnlockLogin(rw http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.Form.Get("email")))
+	if email == "" {
+		m.App.Session.Put(r.Context(), "error", "missing email!")
+		http.Redirect(rw, r, "/admin/logins", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.InsertLoginAttempt(r.Context(), models.LoginAttempt{
+		Email:     email,
+		IPAddress: clientIP(r),
+		Event:     models.LoginUnlock,
+	})
+	if err != nil {
+		helpers.ServerError(rw, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s has been unlocked.", email))
+	http.Redirect(rw, r, "/admin/logins", http.StatusSeeOther)
+}
@@ -12,25 +12,73 @@ type User struct {
 	Email       string
 	Password    string
 	AccessLevel int
+	TOTPSecret  string
+	TOTPEnabled bool
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
 
+// User access levels, higher levels include everything the lower ones may do
+const (
+	AccessLevelStaff = 1
+	AccessLevelOwner = 2
+)
+
+// IsOwner reports whether the user may perform owner-only actions
+func (u User) IsOwner() bool {
+	return u.AccessLevel >= AccessLevelOwner
+}
+
+// TwoFactorRequired reports whether the user must use two-factor authentication when it is required
+// from level up, a level of 0 never requires it
+func (u User) TwoFactorRequired(level int) bool {
+	return level > 0 && u.AccessLevel >= level
+}
+
 // Room is the Rooms model
 type Room struct {
-	ID        int
-	Title     string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID            int
+	Title         string
+	Description   string
+	MaxOccupancy  int
+	Amenities     []string
+	NightlyRate   int // in cents
+	WeekendUplift int // percentage added to Friday and Saturday nights
+	MinStay       int
+	Archived      bool
+	CalendarToken string // secret part of the calendar feed link, empty until an owner creates one
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
 }
 
-// Restriction is the Restrictions model
+// Restriction is a type of room restriction, like a reservation or an owner block
 type Restriction struct {
-	ID              int
-	Title           string
-	RestrictionName time.Time
-	CreatedAt       time.Time
-	UpdatedAt       time.Time
+	ID                 int
+	Code               string // stable identifier the application looks restrictions up by
+	Name               string
+	Colour             string // hex colour of the restriction on the calendar
+	BlocksAvailability bool   // whether the room can't be booked while it is restricted
+	GuestVisible       bool   // whether the room's calendar feed tells what the restriction is
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+}
+
+// Codes of the restrictions the application creates itself
+const (
+	RestrictionReservation      = "reservation"
+	RestrictionOwnerBlock       = "owner-block"
+	RestrictionExternalCalendar = "external-calendar"
+)
+
+// BuiltIn reports whether the application relies on the restriction, built in restrictions always block
+// availability and can't be deleted or have their code changed
+func (r Restriction) BuiltIn() bool {
+	return r.Code == RestrictionReservation || r.Code == RestrictionOwnerBlock || r.Code == RestrictionExternalCalendar
+}
+
+// Blockable reports whether staff can block rooms with the restriction from the calendar
+func (r Restriction) Blockable() bool {
+	return r.Code != RestrictionReservation && r.Code != RestrictionExternalCalendar
 }
 
 // Reservation is the Reservations model
@@ -46,22 +94,214 @@ type Reservation struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	Room      Room
-	Processed int
+	Status    string
+	Amount    int // quoted total in cents
+	// ConfirmationCode lets the guest find the reservation again
+	ConfirmationCode string
+}
+
+// Reservation statuses
+const (
+	ReservationPending    = "pending"
+	ReservationConfirmed  = "confirmed"
+	ReservationCancelled  = "cancelled"
+	ReservationCheckedIn  = "checked-in"
+	ReservationCheckedOut = "checked-out"
+	ReservationNoShow     = "no-show"
+)
+
+// ReservationStatuses lists every reservation status in lifecycle order
+var ReservationStatuses = []string{
+	ReservationPending,
+	ReservationConfirmed,
+	ReservationCheckedIn,
+	ReservationCheckedOut,
+	ReservationNoShow,
+	ReservationCancelled,
+}
+
+// ValidReservationStatus reports whether status is one of ReservationStatuses
+func ValidReservationStatus(status string) bool {
+	for _, s := range ReservationStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// reservationTransitions maps each status to the statuses it may move to
+var reservationTransitions = map[string][]string{
+	ReservationPending:   {ReservationConfirmed, ReservationCancelled},
+	ReservationConfirmed: {ReservationCheckedIn, ReservationNoShow, ReservationCancelled},
+	ReservationCheckedIn: {ReservationCheckedOut},
+}
+
+// NextStatuses returns the statuses the reservation may move to from its current one
+func (r Reservation) NextStatuses() []string {
+	return reservationTransitions[r.Status]
+}
+
+// CanTransitionTo reports whether the reservation may move to status
+func (r Reservation) CanTransitionTo(status string) bool {
+	for _, s := range r.NextStatuses() {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Active reports whether the reservation still holds its room
+func (r Reservation) Active() bool {
+	return r.Status == ReservationPending || r.Status == ReservationConfirmed || r.Status == ReservationCheckedIn
+}
+
+// ReservationFilter selects a page of reservations and the order they're listed in
+type ReservationFilter struct {
+	Status string    // empty for every status
+	Search string    // part of the guest's name, email or phone
+	From   time.Time // earliest arrival, zero for no bound
+	To     time.Time // latest arrival, zero for no bound
+	Sort   string    // one of ReservationSorts, the start date when empty
+	Desc   bool
+	Limit  int // 0 for every reservation
+	Offset int
+}
+
+// Columns reservations can be sorted by
+const (
+	ReservationSortID     = "id"
+	ReservationSortName   = "name"
+	ReservationSortStart  = "start_date"
+	ReservationSortEnd    = "end_date"
+	ReservationSortRoom   = "room"
+	ReservationSortAmount = "amount"
+	ReservationSortStatus = "status"
+)
+
+// ReservationSorts lists every column reservations can be sorted by
+var ReservationSorts = []string{
+	ReservationSortID,
+	ReservationSortName,
+	ReservationSortStart,
+	ReservationSortEnd,
+	ReservationSortRoom,
+	ReservationSortAmount,
+	ReservationSortStatus,
+}
+
+// RoomRate is a seasonal nightly rate overriding the room's base rate between StartDate and EndDate
+type RoomRate struct {
+	ID          int
+	RoomID      int
+	StartDate   time.Time
+	EndDate     time.Time
+	NightlyRate int // in cents
+	MinStay     int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
 }
 
 // RoomRestriction is the RoomRestrictions model
 type RoomRestriction struct {
-	ID            int
-	RoomId        int
-	ReservationId int
-	RestrictionId int
-	StartDate     time.Time
-	EndDate       time.Time
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
-	Room          Room
-	Reservation   Reservation
-	Restriction   Restriction
+	ID               int
+	RoomId           int
+	ReservationId    int
+	RestrictionId    int
+	CalendarSourceID int    // set for blocks imported from a calendar source
+	ExternalUID      string // UID of the imported event
+	Reason           string // why the owner blocked the room, one of BlockReasons
+	StartDate        time.Time
+	EndDate          time.Time
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	Room             Room
+	Reservation      Reservation
+	Restriction      Restriction
+}
+
+// Reasons a room can be blocked for
+const (
+	BlockReasonMaintenance = "maintenance"
+	BlockReasonOwnerUse    = "owner use"
+	BlockReasonRenovation  = "renovation"
+)
+
+// BlockReasons lists the reasons staff can choose from when blocking a room
+var BlockReasons = []string{BlockReasonMaintenance, BlockReasonOwnerUse, BlockReasonRenovation}
+
+// CalendarSource is a calendar of another booking site whose events block a room
+type CalendarSource struct {
+	ID           int
+	RoomID       int
+	Name         string
+	URL          string    // http(s) URL, or path of a local file
+	LastSyncedAt time.Time // zero until the first sync
+	LastError    string    // why the last sync failed, or which events couldn't be blocked
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Room         Room
+}
+
+// Login attempt events
+const (
+	LoginFailure = "failure"
+	LoginSuccess = "success"
+	LoginUnlock  = "unlock" // an admin cleared the failures of an account
+)
+
+// LoginAttempt is an entry in the login audit log
+type LoginAttempt struct {
+	ID        int
+	Email     string
+	IPAddress string
+	Event     string
+	CreatedAt time.Time
+}
+
+// LoginFailures counts the recent failed logins for an email or ip address
+type LoginFailures struct {
+	Key         string
+	Count       int
+	Last        time.Time
+	LockedUntil time.Time // filled in from the lockout policy, not stored
+}
+
+// APIKey lets a program use the API, only a hash of the key itself is stored
+type APIKey struct {
+	ID         int
+	Name       string
+	Prefix     string // the first characters of the key, to tell keys apart
+	Scopes     []string
+	LastUsedAt time.Time // zero if the key was never used
+	Revoked    bool
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// API key scopes, each one allows a group of API routes
+const (
+	APIScopeRoomsRead         = "rooms:read"
+	APIScopeReservationsRead  = "reservations:read"
+	APIScopeReservationsWrite = "reservations:write"
+)
+
+// APIScopes lists every scope a key can be given
+var APIScopes = []string{
+	APIScopeRoomsRead,
+	APIScopeReservationsRead,
+	APIScopeReservationsWrite,
+}
+
+// HasScope reports whether the key may be used for scope
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
 }
 
 type MailData struct {
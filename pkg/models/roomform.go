@@ -0,0 +1,44 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/amiranbari/bookings/internal/forms"
+)
+
+// ValidateRoomForm checks the fields of a room, as posted by the room forms or read from an import
+func ValidateRoomForm(form *forms.Form) {
+	form.Required("title", "max_occupancy", "nightly_rate")
+	form.MinLength("title", 3)
+	form.MaxLength("title", 255)
+	form.IsInt("max_occupancy", 1)
+	form.IsPrice("nightly_rate")
+	if form.Get("weekend_uplift") != "" {
+		form.IsInt("weekend_uplift", 0)
+	}
+	if form.Get("min_stay") != "" {
+		form.IsInt("min_stay", 1)
+	}
+}
+
+// RoomFromForm copies the room fields of form to room, amenities are comma separated
+func RoomFromForm(room *Room, form *forms.Form) {
+	room.Title = strings.TrimSpace(form.Get("title"))
+	room.Description = strings.TrimSpace(form.Get("description"))
+	room.MaxOccupancy, _ = strconv.Atoi(form.Get("max_occupancy"))
+	room.NightlyRate, _ = forms.ParsePrice(form.Get("nightly_rate"))
+	room.WeekendUplift, _ = strconv.Atoi(form.Get("weekend_uplift"))
+	room.MinStay, _ = strconv.Atoi(form.Get("min_stay"))
+	if room.MinStay < 1 {
+		room.MinStay = 1
+	}
+
+	room.Amenities = []string{}
+	for _, amenity := range strings.Split(form.Get("amenities"), ",") {
+		amenity = strings.ToLower(strings.TrimSpace(amenity))
+		if amenity != "" {
+			room.Amenities = append(room.Amenities, amenity)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"html/template"
 	"net/http"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/amiranbari/bookings/pkg/config"
@@ -15,9 +16,12 @@ import (
 )
 
 var functions = template.FuncMap{
-	"humanDate":  HumanDate,
-	"formatDate": FormatDate,
-	"iterate":    Iterate,
+	"humanDate":   HumanDate,
+	"formatDate":  FormatDate,
+	"iterate":     Iterate,
+	"formatPrice": FormatPrice,
+	"join":        strings.Join,
+	"statusColor": StatusColor,
 }
 
 var app *config.AppConfig
@@ -32,13 +36,34 @@ func Iterate(count int) []int {
 }
 
 func HumanDate(t time.Time) string {
-	return t.Format("2006-02-01")
+	return t.Format("2006-01-02")
 }
 
 func FormatDate(t time.Time, f string) string {
 	return t.Format(f)
 }
 
+// FormatPrice formats an amount in cents, e.g. 12050 as 120.50
+func FormatPrice(cents int) string {
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
+
+// StatusColor returns the bootstrap colour used for a reservation status badge
+func StatusColor(status string) string {
+	switch status {
+	case models.ReservationPending:
+		return "warning"
+	case models.ReservationConfirmed:
+		return "primary"
+	case models.ReservationCheckedIn:
+		return "success"
+	case models.ReservationCancelled, models.ReservationNoShow:
+		return "danger"
+	default:
+		return "secondary"
+	}
+}
+
 func NewRenderer(a *config.AppConfig) {
 	app = a
 }
@@ -53,6 +78,7 @@ func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateDa
 
 	if app.Session.Exists(r.Context(), "user_id") {
 		td.IsAuthenticated = true
+		td.AccessLevel = app.Session.GetInt(r.Context(), "access_level")
 	}
 
 	return td